@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	blockStart = "# >>> sd managed block >>>"
+	blockEnd   = "# <<< sd managed block <<<"
+)
+
+var aliasFiles = map[string]string{
+	BASH: filepath.Join(getHomeDir(), ".bash_aliases"),
+	ZSH:  filepath.Join(getHomeDir(), ".zshrc"),
+	FISH: filepath.Join(filepath.Dir(fishConfigFile()), "conf.d", "sd.fish"),
+	SH:   filepath.Join(getHomeDir(), ".profile"),
+}
+
+var (
+	rShellName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+	rPOSIXName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+func quoteSh(val string) string {
+	return "'" + strings.Replace(val, "'", `'\''`, -1) + "'"
+}
+
+func quoteFish(val string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(val) + "'"
+}
+
+// shellBody converts the placeholders of cmd into positional parameters of
+// shell, followed by the arguments beyond the last placeholder just like
+// parseCmd appends them. POSIX sh has no way to expand the arguments from a
+// given position on, so they are dropped there.
+func shellBody(cmd, shell string) string {
+	maxIdx := 0
+	body := rAll.ReplaceAllStringFunc(cmd, func(matchedVal string) string {
+		p := parsePlaceholder(matchedVal)
+		if p.idx > maxIdx {
+			maxIdx = p.idx
+		}
+		if shell == FISH {
+			if p.hasDef {
+				return fmt.Sprintf("(set -q argv[%d]; and echo $argv[%d]; or echo %s)", p.idx, p.idx, quoteFish(p.def))
+			}
+			return fmt.Sprintf("$argv[%d]", p.idx)
+		}
+		if p.hasDef {
+			return fmt.Sprintf("${%d:-%s}", p.idx, p.def)
+		}
+		return fmt.Sprintf("${%d}", p.idx)
+	})
+	switch shell {
+	case FISH:
+		return body + " $argv[" + strconv.Itoa(maxIdx+1) + "..-1]"
+	case SH:
+		return body
+	}
+	return body + ` "${@:` + strconv.Itoa(maxIdx+1) + `}"`
+}
+
+// renderAliases renders sdMap for shell: keys without placeholders become
+// aliases, keys with placeholders become functions. Keys which are not valid
+// alias or function names for shell, or which use secrets only sd can
+// resolve, are returned as skipped along with the reason.
+func renderAliases(sdMap map[string]entry, shell string) (string, []string) {
+	var keys, skipped []string
+	for key := range sdMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	str := ""
+	for _, key := range keys {
+		cmd := sdMap[key].Cmd
+		validName := rShellName.MatchString(key)
+		if shell == SH {
+			validName = rPOSIXName.MatchString(key)
+		}
+		if !validName {
+			skipped = append(skipped, fmt.Sprintf("%s: not a valid %s alias or function name", key, shell))
+			continue
+		}
+		if rSecret.MatchString(cmd) {
+			skipped = append(skipped, fmt.Sprintf("%s: uses secrets, which only sd can resolve", key))
+			continue
+		}
+		hasPlaceholders := len(placeholders(cmd)) > 0
+		switch {
+		case shell == FISH && hasPlaceholders:
+			str += fmt.Sprintf("function %s\n    %s\nend\n", key, shellBody(cmd, shell))
+		case shell == FISH:
+			str += fmt.Sprintf("alias %s %s\n", key, quoteFish(cmd))
+		case hasPlaceholders:
+			str += fmt.Sprintf("%s() {\n    %s\n}\n", key, shellBody(cmd, shell))
+		default:
+			str += fmt.Sprintf("alias %s=%s\n", key, quoteSh(cmd))
+		}
+	}
+	return str, skipped
+}
+
+// replaceManagedBlock replaces the managed block of content by block, or
+// appends block when content has none yet. Anything outside of the block is
+// left untouched.
+func replaceManagedBlock(content, block string) string {
+	managed := blockStart + "\n# Generated by \"sd export -to-alias\", changes are overwritten on the next export.\n" + block + blockEnd + "\n"
+	start := strings.Index(content, blockStart)
+	end := strings.Index(content, blockEnd)
+	if start >= 0 && end > start {
+		rest := content[end+len(blockEnd):]
+		return content[:start] + managed + strings.TrimPrefix(rest, "\n")
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	if content != "" {
+		content += "\n"
+	}
+	return content + managed
+}
+
+var exportToAlias = func(shell, file string) int {
+	defaultFile, ok := aliasFiles[shell]
+	if !ok {
+		print("cannot execute command: %s, unknown shell \"%s\", expected one of: bash, zsh, fish, sh\n", EXPORT, shell)
+		return 1
+	}
+	if file == "" {
+		file = defaultFile
+	}
+	if !fileExists() {
+		return 1
+	}
+	block, skipped := renderAliases(readFile(), shell)
+	content, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		print("cannot read %s: %v\n", file, err)
+		return 1
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		print("cannot write %s: %v\n", file, err)
+		return 1
+	}
+	if err := ioutil.WriteFile(file, []byte(replaceManagedBlock(string(content), block)), 0644); err != nil {
+		print("cannot write %s: %v\n", file, err)
+		return 1
+	}
+	for _, reason := range skipped {
+		print("skipped key %s\n", reason)
+	}
+	print("Wrote speed-dial content to %s as %s aliases\n", file, shell)
+	return 0
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShellBody(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName:   "Test bash body",
+			tInput:  []T{"kubectl logs {1} -n {2|default}", BASH},
+			tFunc:   shellBody,
+			tOutput: "kubectl logs ${1} -n ${2:-default} \"${@:3}\"",
+		},
+		{
+			tName:   "Test sh body",
+			tInput:  []T{"ssh {1}@host", SH},
+			tFunc:   shellBody,
+			tOutput: "ssh ${1}@host",
+		},
+		{
+			tName:   "Test fish body",
+			tInput:  []T{"kubectl logs {1} -n {2|default}", FISH},
+			tFunc:   shellBody,
+			tOutput: "kubectl logs $argv[1] -n (set -q argv[2]; and echo $argv[2]; or echo 'default') $argv[3..-1]",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestRenderAliases(t *testing.T) {
+	sdMap := map[string]entry{
+		"say":    {Cmd: "echo \"it's\""},
+		"logs":   {Cmd: "kubectl logs {1}"},
+		"k8s/ns": {Cmd: "kubectl get ns"},
+		"my-ls":  {Cmd: "ls -l"},
+		"login":  {Cmd: "docker login -p {secret:REGISTRY} {1}"},
+	}
+	render := func(shell string) string {
+		str, skipped := renderAliases(sdMap, shell)
+		return fmt.Sprintf("%s%q", str, skipped)
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test render bash aliases",
+			tInput:  []T{BASH},
+			tFunc:   render,
+			tOutput: "logs() {\n    kubectl logs ${1} \"${@:2}\"\n}\nalias my-ls='ls -l'\nalias say='echo \"it'\\''s\"'\n[\"k8s/ns: not a valid bash alias or function name\" \"login: uses secrets, which only sd can resolve\"]",
+		},
+		{
+			tName:   "Test render sh aliases",
+			tInput:  []T{SH},
+			tFunc:   render,
+			tOutput: "logs() {\n    kubectl logs ${1}\n}\nalias say='echo \"it'\\''s\"'\n[\"k8s/ns: not a valid sh alias or function name\" \"login: uses secrets, which only sd can resolve\" \"my-ls: not a valid sh alias or function name\"]",
+		},
+		{
+			tName:   "Test render fish aliases",
+			tInput:  []T{FISH},
+			tFunc:   render,
+			tOutput: "function logs\n    kubectl logs $argv[1] $argv[2..-1]\nend\nalias my-ls 'ls -l'\nalias say 'echo \"it\\'s\"'\n[\"k8s/ns: not a valid fish alias or function name\" \"login: uses secrets, which only sd can resolve\"]",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestReplaceManagedBlock(t *testing.T) {
+	header := "# >>> sd managed block >>>\n# Generated by \"sd export -to-alias\", changes are overwritten on the next export.\n"
+	footer := "# <<< sd managed block <<<\n"
+	tt := []ttFStruct{
+		{
+			tName:   "Test managed block in empty file",
+			tInput:  []T{"", "alias a='b'\n"},
+			tFunc:   replaceManagedBlock,
+			tOutput: header + "alias a='b'\n" + footer,
+		},
+		{
+			tName:   "Test managed block appended to existing aliases",
+			tInput:  []T{"alias ll='ls -l'", "alias a='b'\n"},
+			tFunc:   replaceManagedBlock,
+			tOutput: "alias ll='ls -l'\n\n" + header + "alias a='b'\n" + footer,
+		},
+		{
+			tName:   "Test managed block replaced",
+			tInput:  []T{"alias ll='ls -l'\n\n" + header + "alias old='x'\n" + footer + "alias after='y'\n", "alias a='b'\n"},
+			tFunc:   replaceManagedBlock,
+			tOutput: "alias ll='ls -l'\n\n" + header + "alias a='b'\n" + footer + "alias after='y'\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestExportToAlias(t *testing.T) {
+	keyFile = "./test/.dial_keys_tagged"
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "aliases")
+	ioutil.WriteFile(file, []byte("alias ll='ls -l'\n"), 0644)
+	tt := []ttFStruct{
+		{
+			tName:       "Test export to alias of unknown shell",
+			tInput:      []T{"csh", file},
+			tFunc:       exportToAlias,
+			tOutput:     1,
+			tPipeOutput: "cannot execute command: export, unknown shell \"csh\", expected one of: bash, zsh, fish, sh\n",
+		},
+		{
+			tName:       "Test export to alias",
+			tInput:      []T{BASH, file},
+			tFunc:       exportToAlias,
+			tOutput:     0,
+			tPipeOutput: "Wrote speed-dial content to " + file + " as bash aliases\n",
+		},
+		{
+			tName:       "Test export to alias again",
+			tInput:      []T{BASH, file},
+			tFunc:       exportToAlias,
+			tOutput:     0,
+			tPipeOutput: "Wrote speed-dial content to " + file + " as bash aliases\n",
+		},
+	}
+	testPackageMethod(tt, t)
+	content, _ := ioutil.ReadFile(file)
+	tt = []ttFStruct{
+		{
+			tName:  "Test export to alias keeps existing aliases",
+			tInput: []T{string(content)},
+			tFunc:  func(content string) string { return content },
+			tOutput: "alias ll='ls -l'\n\n# >>> sd managed block >>>\n# Generated by \"sd export -to-alias\", changes are overwritten on the next export.\n" +
+				"alias hello='echo world'\n" +
+				"logs() {\n    kubectl logs -f ${1} \"${@:2}\"\n}\n" +
+				"pods() {\n    kubectl get pods -n ${1:-default} \"${@:2}\"\n}\n" +
+				"# <<< sd managed block <<<\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
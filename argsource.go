@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	SOURCECHOICES = "choices"
+	SOURCEFILES   = "files"
+	SOURCEDIRS    = "dirs"
+	SOURCEHOSTS   = "hosts"
+	SOURCECMD     = "cmd"
+)
+
+// cmdSourcesEnv opts in to the cmd: sources. They run a command on every
+// completion, and keys pulled from others may carry them, so they are off
+// unless set to 1.
+var cmdSourcesEnv = "SD_COMPLETE_CMD"
+
+var sshConfigFile = getHomeDir() + string(os.PathSeparator) + ".ssh" + string(os.PathSeparator) + "config"
+
+// parseArgSources parses completion sources given as N=SOURCE, N being the
+// index of a placeholder of cmd.
+func parseArgSources(specs []string, cmd string) (map[int]string, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	known := map[int]bool{}
+	for _, p := range placeholders(cmd) {
+		known[p.idx] = true
+	}
+	sources := map[int]string{}
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, "=", 2)
+		idx, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("invalid argument source \"%s\", expected N=SOURCE", spec)
+		}
+		if !known[idx] {
+			return nil, fmt.Errorf("invalid argument source \"%s\", the command has no placeholder {%d}", spec, idx)
+		}
+		kind := strings.SplitN(parts[1], ":", 2)[0]
+		switch kind {
+		case SOURCEFILES, SOURCEDIRS, SOURCEHOSTS:
+			if kind != parts[1] {
+				return nil, fmt.Errorf("invalid argument source \"%s\", %s takes no value", spec, kind)
+			}
+		case SOURCECHOICES, SOURCECMD:
+			if !strings.HasPrefix(parts[1], kind+":") || len(parts[1]) == len(kind)+1 {
+				return nil, fmt.Errorf("invalid argument source \"%s\", %s requires a value as %s:VALUE", spec, kind, kind)
+			}
+		default:
+			return nil, fmt.Errorf("invalid argument source \"%s\", unknown source \"%s\"", spec, kind)
+		}
+		sources[idx] = parts[1]
+	}
+	return sources, nil
+}
+
+func completePath(cur string, dirsOnly bool) []string {
+	dir, prefix := filepath.Split(cur)
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+	files, err := ioutil.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+	var candidates []string
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), prefix) || (strings.HasPrefix(f.Name(), ".") && !strings.HasPrefix(prefix, ".")) {
+			continue
+		}
+		if f.IsDir() {
+			candidates = append(candidates, dir+f.Name()+"/")
+		} else if !dirsOnly {
+			candidates = append(candidates, dir+f.Name())
+		}
+	}
+	return candidates
+}
+
+func sshHosts(configFile string) []string {
+	f, err := os.Open(configFile)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var hosts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(strings.Replace(scanner.Text(), "=", " ", 1))
+		if len(fields) < 2 || strings.ToLower(fields[0]) != "host" {
+			continue
+		}
+		for _, host := range fields[1:] {
+			if !strings.ContainsAny(host, "*?!") {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
+
+var commandOutput = func(cmd string) []string {
+	out, err := exec.Command("bash", "-c", cmd).Output()
+	if err != nil {
+		return nil
+	}
+	var lines []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func completeArgSource(source, cur string) []string {
+	parts := strings.SplitN(source, ":", 2)
+	switch parts[0] {
+	case SOURCECHOICES:
+		return strings.Split(parts[1], ",")
+	case SOURCEFILES:
+		return completePath(cur, false)
+	case SOURCEDIRS:
+		return completePath(cur, true)
+	case SOURCEHOSTS:
+		return sshHosts(sshConfigFile)
+	case SOURCECMD:
+		if os.Getenv(cmdSourcesEnv) != "1" {
+			return nil
+		}
+		return commandOutput(parts[1])
+	}
+	return nil
+}
+
+// completeArg completes the argument at position idx of the command saved
+// as value, using the completion source declared for the placeholder or,
+// lacking one, the default value of the placeholder.
+func completeArg(value entry, idx int, cur string) []string {
+	if source, ok := value.Args[idx]; ok {
+		return completeArgSource(source, cur)
+	}
+	for _, p := range placeholders(value.Cmd) {
+		if p.idx == idx && p.hasDef {
+			return []string{p.def + "\tdefault"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestParseArgSources(t *testing.T) {
+	parse := func(specs []string, cmd string) string {
+		sources, err := parseArgSources(specs, cmd)
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("%v", sources)
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test parse without sources",
+			tInput:  []T{[]string{}, "echo {1}"},
+			tFunc:   parse,
+			tOutput: "map[]",
+		},
+		{
+			tName:   "Test parse valid sources",
+			tInput:  []T{[]string{"1=choices:dev,prod", "2=files", "3=cmd:kubectl get pods -o name"}, "echo {1} {2} {3|x}"},
+			tFunc:   parse,
+			tOutput: "map[1:choices:dev,prod 2:files 3:cmd:kubectl get pods -o name]",
+		},
+		{
+			tName:   "Test parse source without index",
+			tInput:  []T{[]string{"files"}, "echo {1}"},
+			tFunc:   parse,
+			tOutput: "invalid argument source \"files\", expected N=SOURCE",
+		},
+		{
+			tName:   "Test parse source for unknown placeholder",
+			tInput:  []T{[]string{"2=files"}, "echo {1}"},
+			tFunc:   parse,
+			tOutput: "invalid argument source \"2=files\", the command has no placeholder {2}",
+		},
+		{
+			tName:   "Test parse unknown source",
+			tInput:  []T{[]string{"1=pods"}, "echo {1}"},
+			tFunc:   parse,
+			tOutput: "invalid argument source \"1=pods\", unknown source \"pods\"",
+		},
+		{
+			tName:   "Test parse source missing its value",
+			tInput:  []T{[]string{"1=choices"}, "echo {1}"},
+			tFunc:   parse,
+			tOutput: "invalid argument source \"1=choices\", choices requires a value as choices:VALUE",
+		},
+		{
+			tName:   "Test parse source with superfluous value",
+			tInput:  []T{[]string{"1=dirs:/tmp"}, "echo {1}"},
+			tFunc:   parse,
+			tOutput: "invalid argument source \"1=dirs:/tmp\", dirs takes no value",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestSSHHosts(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName:   "Test ssh hosts skip patterns",
+			tInput:  []T{"./test/ssh_config"},
+			tFunc:   sshHosts,
+			tOutput: []string{"bastion", "web-1", "web-2", "db"},
+		},
+		{
+			tName:   "Test ssh hosts without config",
+			tInput:  []T{"./test/does_not_exist"},
+			tFunc:   sshHosts,
+			tOutput: []string{},
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestCompletePath(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName:   "Test complete files",
+			tInput:  []T{"./test/tree/", false},
+			tFunc:   completePath,
+			tOutput: []string{"./test/tree/file.txt", "./test/tree/sub/"},
+		},
+		{
+			tName:   "Test complete directories",
+			tInput:  []T{"./test/tree/", true},
+			tFunc:   completePath,
+			tOutput: []string{"./test/tree/sub/"},
+		},
+		{
+			tName:   "Test complete hidden files",
+			tInput:  []T{"./test/tree/.", false},
+			tFunc:   completePath,
+			tOutput: []string{"./test/tree/.hidden"},
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestCompleteArg(t *testing.T) {
+	defer os.Setenv(cmdSourcesEnv, os.Getenv(cmdSourcesEnv))
+	os.Setenv(cmdSourcesEnv, "1")
+	commandOutput = func(cmd string) []string {
+		return []string{"pod/web", "pod/db"}
+	}
+	value := entry{
+		Cmd:  "kubectl logs {1} -n {2|default} -c {3|app}",
+		Args: map[int]string{1: "cmd:kubectl get pods -o name", 3: "choices:app,sidecar"},
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test complete argument from command",
+			tInput:  []T{value, 1, ""},
+			tFunc:   completeArg,
+			tOutput: []string{"pod/web", "pod/db"},
+		},
+		{
+			tName:  "Test complete argument from command without opting in",
+			tInput: []T{value, 1, ""},
+			tFunc: func(value entry, idx int, cur string) []string {
+				os.Unsetenv(cmdSourcesEnv)
+				defer os.Setenv(cmdSourcesEnv, "1")
+				return completeArg(value, idx, cur)
+			},
+			tOutput: []string{},
+		},
+		{
+			tName:   "Test complete argument with default",
+			tInput:  []T{value, 2, ""},
+			tFunc:   completeArg,
+			tOutput: []string{"default\tdefault"},
+		},
+		{
+			tName:   "Test complete argument from choices",
+			tInput:  []T{value, 3, ""},
+			tFunc:   completeArg,
+			tOutput: []string{"app", "sidecar"},
+		},
+		{
+			tName:   "Test complete argument without placeholder",
+			tInput:  []T{value, 4, ""},
+			tFunc:   completeArg,
+			tOutput: []string{},
+		},
+	}
+	testPackageMethod(tt, t)
+}
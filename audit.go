@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+var AUDIT = "audit"
+var (
+	SECRETSREFUSE = "refuse"
+	SECRETSWARN   = "warn"
+	SECRETSMOVE   = "move"
+)
+
+// secretPatterns detect likely credentials, the first group of a pattern
+// being the credential itself when it has one.
+var secretPatterns = []struct {
+	kind string
+	re   *regexp.Regexp
+}{
+	{"private key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----(?s:.*?)(?:-----END [A-Z ]*PRIVATE KEY-----|$)`)},
+	{"AWS access key", regexp.MustCompile(`\b((?:AKIA|ASIA)[0-9A-Z]{16})\b`)},
+	{"AWS secret key", regexp.MustCompile(`(?i)aws_secret_access_key\s*[=:]\s*['"]?([A-Za-z0-9/+=]{40})`)},
+	{"GitHub token", regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,})\b`)},
+	{"bearer token", regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/=-]{8,})`)},
+	// Only the MySQL and MariaDB clients and sshpass take the password glued
+	// to -p, elsewhere -p starts flags such as -print or -prune.
+	{"password", regexp.MustCompile(`(?:^|[\s;&|(/])(?:mysql|mariadb|sshpass)[\w-]*\s(?:[^;&|\n]*?\s)?-p([^\s'"{}]{3,})`)},
+	{"password", regexp.MustCompile(`(?:^|\s)--password[= ]['"]?([^\s'"{}]+)`)},
+	{"URL credentials", regexp.MustCompile(`://[^/\s:@{}]+:([^/\s@{}]+)@`)},
+}
+
+var (
+	rEntropyCandidate = regexp.MustCompile(`[A-Za-z0-9+_=-]{20,}`)
+	// rNotPassword matches ports, port mappings and paths following -p.
+	rNotPassword   = regexp.MustCompile(`^[0-9:./-]+$`)
+	rNotSecretName = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+)
+
+// A random token has a high Shannon entropy in bits per character, and
+// unlike CamelCase words or identifiers often switches between upper case,
+// lower case and digits.
+var (
+	minEntropy     = 3.5
+	minClassSwitch = 0.4
+)
+
+type finding struct {
+	kind  string
+	value string
+}
+
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, r := range s {
+		counts[r]++
+	}
+	var e float64
+	for _, count := range counts {
+		p := float64(count) / float64(len(s))
+		e -= p * math.Log2(p)
+	}
+	return e
+}
+
+func charClass(b byte) int {
+	switch {
+	case b >= 'A' && b <= 'Z':
+		return 1
+	case b >= 'a' && b <= 'z':
+		return 2
+	case b >= '0' && b <= '9':
+		return 3
+	}
+	return 0
+}
+
+func isRandomToken(s string) bool {
+	seen := map[int]bool{}
+	switches := 0
+	for i := 0; i < len(s); i++ {
+		seen[charClass(s[i])] = true
+		if i > 0 && charClass(s[i]) != charClass(s[i-1]) {
+			switches++
+		}
+	}
+	return seen[1] && seen[2] && seen[3] && entropy(s) >= minEntropy &&
+		float64(switches)/float64(len(s)-1) >= minClassSwitch
+}
+
+// scanSecrets returns the likely credentials in cmd, {secret:NAME}
+// placeholders being left alone.
+func scanSecrets(cmd string) []finding {
+	var findings []finding
+	var spans [][]int
+	overlaps := func(start, end int) bool {
+		for _, span := range spans {
+			if start < span[1] && end > span[0] {
+				return true
+			}
+		}
+		return false
+	}
+	for _, pattern := range secretPatterns {
+		for _, match := range pattern.re.FindAllStringSubmatchIndex(cmd, -1) {
+			start, end := match[0], match[1]
+			if len(match) > 2 {
+				start, end = match[2], match[3]
+			}
+			value := cmd[start:end]
+			if overlaps(start, end) || (pattern.kind == "password" && rNotPassword.MatchString(value)) {
+				continue
+			}
+			spans = append(spans, []int{start, end})
+			findings = append(findings, finding{kind: pattern.kind, value: value})
+		}
+	}
+	for _, span := range rEntropyCandidate.FindAllStringIndex(cmd, -1) {
+		if !overlaps(span[0], span[1]) && isRandomToken(cmd[span[0]:span[1]]) {
+			spans = append(spans, span)
+			findings = append(findings, finding{kind: "high-entropy string", value: cmd[span[0]:span[1]]})
+		}
+	}
+	return findings
+}
+
+// maskSecret keeps the start of a credential, enough to recognize it.
+func maskSecret(value string) string {
+	value = strings.SplitN(value, "\n", 2)[0]
+	if len(value) <= 8 {
+		return strings.Repeat("*", len(value))
+	}
+	return value[:4] + strings.Repeat("*", 8)
+}
+
+// scanKeys scans the commands of the keys, and their previous values with
+// history.
+func scanKeys(sdMap map[string]entry, history bool) map[string][]finding {
+	found := map[string][]finding{}
+	for key, e := range sdMap {
+		seen := map[string]bool{}
+		scanned := []revision{revisionOf(e)}
+		if history {
+			scanned = revisions(e)
+		}
+		for _, r := range scanned {
+			for _, f := range scanSecrets(r.Cmd) {
+				if !seen[f.value] {
+					seen[f.value] = true
+					found[key] = append(found[key], f)
+				}
+			}
+		}
+	}
+	return found
+}
+
+func formatFindings(found map[string][]finding) string {
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var out bytes.Buffer
+	w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tKIND\tVALUE")
+	for _, key := range keys {
+		for _, f := range found[key] {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", key, f.kind, maskSecret(f.value))
+		}
+	}
+	w.Flush()
+	return out.String()
+}
+
+func secretName(key, kind string, taken map[string]bool) string {
+	slug := func(s string) string {
+		return strings.Trim(rNotSecretName.ReplaceAllString(s, "_"), "_")
+	}
+	name := slug(key) + "_" + slug(strings.ToLower(kind))
+	for i := 2; taken[name]; i++ {
+		name = fmt.Sprintf("%s_%s_%d", slug(key), slug(strings.ToLower(kind)), i)
+	}
+	taken[name] = true
+	return name
+}
+
+// moveSecrets encrypts the credentials found in the commands of sdMap into
+// secrets, replacing them with {secret:NAME} placeholders. Once saved, the
+// credentials are scrubbed from the files keeping previous or deleted keys.
+func moveSecrets(sdMap map[string]entry, found map[string][]finding) error {
+	store, err := readSecrets()
+	if err != nil {
+		return err
+	}
+	aead, err := store.unlock()
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var moved []replacement
+	for _, key := range keys {
+		e := sdMap[key]
+		e.History = append([]revision{}, e.History...)
+		taken := map[string]bool{}
+		for _, f := range found[key] {
+			name := secretName(key, f.kind, taken)
+			store.Secrets[name] = seal(aead, name, f.value)
+			moved = append(moved, replacement{f.value, "{secret:" + name + "}"})
+			e.Cmd = strings.Replace(e.Cmd, f.value, "{secret:"+name+"}", -1)
+			for i := range e.History {
+				e.History[i].Cmd = strings.Replace(e.History[i].Cmd, f.value, "{secret:"+name+"}", -1)
+			}
+			print("moved the %s of key %s into the secret %s\n", f.kind, key, name)
+		}
+		e.Updated, e.By = timestamp(), author()
+		sdMap[key] = e
+	}
+	if err := writeSecrets(store); err != nil {
+		return err
+	}
+	return scrubSecrets(moved)
+}
+
+func isValidSecretPolicy(policy string) bool {
+	if isValidFormat(policy, SECRETSREFUSE, SECRETSWARN, SECRETSMOVE) {
+		return true
+	}
+	print("unknown secrets policy \"%s\", expected one of: refuse, warn, move\n", policy)
+	return false
+}
+
+// guardSecrets applies the policy to the likely credentials in the commands
+// of sdMap, moving them into secrets in place with the move policy. It
+// reports whether the command may go on.
+func guardSecrets(command string, sdMap map[string]entry, policy string) bool {
+	found := scanKeys(sdMap, false)
+	if len(found) == 0 {
+		return true
+	}
+	switch policy {
+	case SECRETSWARN:
+		print("warning: likely secrets in the commands\n%s", formatFindings(found))
+		return true
+	case SECRETSMOVE:
+		if err := moveSecrets(sdMap, found); err != nil {
+			print("cannot execute command: %s, %v\n", command, err)
+			return false
+		}
+		return true
+	}
+	print("likely secrets in the commands\n%s", formatFindings(found))
+	print("cannot execute command: %s, use -secrets move to move them into secrets, or -secrets warn to go on anyway\n", command)
+	return false
+}
+
+// incomingKeys returns the keys of merged which ours lacks or has with
+// another value, the keys received by import, pull, sync and serve.
+func incomingKeys(ours, merged map[string]entry) map[string]entry {
+	added, _, changed := diffKeys(ours, merged)
+	incoming := map[string]entry{}
+	for _, key := range append(added, changed...) {
+		incoming[key] = merged[key]
+	}
+	return incoming
+}
+
+// guardIncoming applies the policy to the incoming keys of merged, the keys
+// moved into secrets being updated in merged.
+func guardIncoming(command string, ours, merged map[string]entry, policy string) bool {
+	incoming := incomingKeys(ours, merged)
+	if !guardSecrets(command, incoming, policy) {
+		return false
+	}
+	for key, e := range incoming {
+		merged[key] = e
+	}
+	return true
+}
+
+func audit(move bool) int {
+	if !fileExists() {
+		return 1
+	}
+	sdMap := readFile()
+	found := scanKeys(sdMap, true)
+	if len(found) == 0 {
+		print("no likely secrets in %d key(s)\n", len(sdMap))
+		return 0
+	}
+	if move {
+		if err := moveSecrets(sdMap, found); err != nil {
+			print("cannot execute command: %s, %v\n", AUDIT, err)
+			return 1
+		}
+		writeFile(sdMap)
+		commitKeys("Move secrets out of the keys")
+		return 0
+	}
+	count := 0
+	for _, findings := range found {
+		count += len(findings)
+	}
+	print("%s", formatFindings(found))
+	print("%d likely secret(s) in %d key(s), move them into secrets with sd %s -move\n", count, len(found), AUDIT)
+	return 1
+}
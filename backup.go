@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	UNDO    = "undo"
+	TRASH   = "trash"
+	BACKUP  = "backup"
+	RESTORE = "restore"
+)
+var (
+	TRASHLIST    = "list"
+	TRASHRESTORE = "restore"
+	TRASHEMPTY   = "empty"
+)
+
+// Every change of the key file first keeps the previous file as a numbered
+// generation in backupDir, the highest number being the latest.
+var backupDir = getHomeDir() + string(os.PathSeparator) + ".dial_keys.backups"
+var trashFile = getHomeDir() + string(os.PathSeparator) + ".dial_keys.trash"
+
+var defaultBackups = 10
+
+// backupCount is the number of generations to keep, from $SD_BACKUPS.
+func backupCount() int {
+	if n, err := strconv.Atoi(os.Getenv("SD_BACKUPS")); err == nil && n >= 0 {
+		return n
+	}
+	return defaultBackups
+}
+
+// backupGenerations returns the paths of the generations, oldest first.
+func backupGenerations() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(backupDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	generation := func(path string) int {
+		n, _ := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), ".json"))
+		return n
+	}
+	sort.Slice(paths, func(i, j int) bool { return generation(paths[i]) < generation(paths[j]) })
+	return paths, nil
+}
+
+// backupKeyFile keeps the key file as a new generation before it is
+// replaced by sdMap, unless nothing changes.
+func backupKeyFile(sdMap map[string]entry) error {
+	count := backupCount()
+	current, err := ioutil.ReadFile(keyFile)
+	if count == 0 || os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if next, err := json.Marshal(sdMap); err == nil && bytes.Equal(next, current) {
+		return nil
+	}
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return err
+	}
+	generations, err := backupGenerations()
+	if err != nil {
+		return err
+	}
+	last := 0
+	if len(generations) > 0 {
+		last, _ = strconv.Atoi(strings.TrimSuffix(filepath.Base(generations[len(generations)-1]), ".json"))
+	}
+	path := filepath.Join(backupDir, fmt.Sprintf("%06d.json", last+1))
+	if err := ioutil.WriteFile(path, current, 0600); err != nil {
+		return err
+	}
+	generations = append(generations, path)
+	for len(generations) > count {
+		os.Remove(generations[0])
+		generations = generations[1:]
+	}
+	return nil
+}
+
+// replacement replaces the credential value by a placeholder.
+type replacement struct {
+	value, placeholder string
+}
+
+// scrubEntry replaces the moved credentials in the command and the history
+// of e, telling whether there were any.
+func scrubEntry(e entry, moved []replacement) (entry, bool) {
+	changed := false
+	scrub := func(cmd string) string {
+		for _, r := range moved {
+			if strings.Contains(cmd, r.value) {
+				cmd, changed = strings.Replace(cmd, r.value, r.placeholder, -1), true
+			}
+		}
+		return cmd
+	}
+	e.Cmd = scrub(e.Cmd)
+	e.History = append([]revision(nil), e.History...)
+	for i := range e.History {
+		e.History[i].Cmd = scrub(e.History[i].Cmd)
+	}
+	return e, changed
+}
+
+// scrubSecrets replaces the credentials moved into secrets by their
+// placeholders in the key file, its generations and the trash, so that
+// neither undo nor restoring a deleted key brings them back, and in the
+// copies kept for syncing: the bases of the remotes and the key file of the
+// git sync repository. The key file is rewritten in place, its next
+// generation would hold them otherwise. The git history still holds them,
+// which only rewriting it removes.
+func scrubSecrets(moved []replacement) error {
+	if len(moved) == 0 {
+		return nil
+	}
+	files, err := backupGenerations()
+	if err != nil {
+		return err
+	}
+	if fileExists() {
+		files = append(files, keyFile)
+	}
+	for _, file := range files {
+		sdMap, err := readKeyFile(file)
+		if err != nil {
+			return err
+		}
+		changed := false
+		for key, e := range sdMap {
+			if scrubbed, ok := scrubEntry(e, moved); ok {
+				sdMap[key], changed = scrubbed, true
+			}
+		}
+		if changed {
+			if err := writeKeyFile(file, sdMap); err != nil {
+				return err
+			}
+		}
+	}
+	items, err := readTrash()
+	if err != nil {
+		return err
+	}
+	changed := false
+	for key, item := range items {
+		if scrubbed, ok := scrubEntry(item.Entry, moved); ok {
+			item.Entry, changed = scrubbed, true
+			items[key] = item
+		}
+	}
+	if changed {
+		if err := writeTrash(items); err != nil {
+			return err
+		}
+	}
+	if err := scrubRemotes(moved); err != nil {
+		return err
+	}
+	return scrubSyncFile(moved)
+}
+
+// scrubRemotes replaces the moved credentials in the bases of the remotes,
+// the next sync would take them for local changes otherwise.
+func scrubRemotes(moved []replacement) error {
+	remotes, err := readRemotes()
+	if err != nil {
+		return err
+	}
+	changed := false
+	for name, r := range remotes {
+		for key, e := range r.Base {
+			if scrubbed, ok := scrubEntry(e, moved); ok {
+				r.Base[key], changed = scrubbed, true
+			}
+		}
+		remotes[name] = r
+	}
+	if changed {
+		return writeRemotes(remotes)
+	}
+	return nil
+}
+
+// scrubSyncFile commits the key file of the git sync repository without the
+// moved credentials, warning that its history still holds them.
+func scrubSyncFile(moved []replacement) error {
+	if !isSynced() {
+		return nil
+	}
+	file := filepath.Join(syncDir, syncFile)
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil
+	}
+	sdMap, err := readKeyFile(file)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for key, e := range sdMap {
+		if scrubbed, ok := scrubEntry(e, moved); ok {
+			sdMap[key], changed = scrubbed, true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if err := commitSyncFile(sdMap, "Move secrets out of the keys"); err != nil {
+		return err
+	}
+	print("warning: the git history of %s still holds the moved secrets, rotate them or rewrite the history\n", syncDir)
+	return nil
+}
+
+// checkMovedSecrets fails when the commands of sdMap hold the value of a
+// secret, a credential once moved out of the keys. The passphrase is only
+// asked for when they hold likely credentials.
+func checkMovedSecrets(sdMap map[string]entry) error {
+	found := scanKeys(sdMap, true)
+	if len(found) == 0 {
+		return nil
+	}
+	store, err := readSecrets()
+	if err != nil || len(store.Secrets) == 0 {
+		return err
+	}
+	aead, err := store.unlock()
+	if err != nil {
+		return fmt.Errorf("cannot check the likely secrets of the keys, %v", err)
+	}
+	names := map[string]string{}
+	for name, sealed := range store.Secrets {
+		if value, err := unseal(aead, name, sealed); err == nil {
+			names[value] = name
+		}
+	}
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, f := range found[key] {
+			if name, ok := names[f.value]; ok {
+				return fmt.Errorf("the key %s would get back the %s moved into the secret %s", key, f.kind, name)
+			}
+		}
+	}
+	return nil
+}
+
+func undo() int {
+	generations, err := backupGenerations()
+	if err != nil {
+		print("cannot execute command: %s, %v\n", UNDO, err)
+		return 1
+	}
+	if len(generations) == 0 {
+		print("cannot execute command: %s, there is no change to undo\n", UNDO)
+		return 1
+	}
+	latest := generations[len(generations)-1]
+	previous, err := readKeyFile(latest)
+	if err == nil {
+		err = checkMovedSecrets(previous)
+	}
+	if err != nil {
+		print("cannot execute command: %s, %v\n", UNDO, err)
+		return 1
+	}
+	current := map[string]entry{}
+	if fileExists() {
+		current = readFile()
+	}
+	// Write around writeFile, undoing must not keep the undone change as a
+	// generation to undo next.
+	if err := writeKeyFile(keyFile, previous); err != nil {
+		print("cannot execute command: %s, %v\n", UNDO, err)
+		return 1
+	}
+	os.Remove(latest)
+	commitKeys("Undo the last change")
+	printMergeSummary("restored", keyFile, current, previous, nil)
+	return 0
+}
+
+// backup writes a snapshot of the keys to file, named after the current
+// time when empty.
+func backup(file string) int {
+	if !fileExists() {
+		return 1
+	}
+	if file == "" {
+		file = "dial_keys-" + now().Format("20060102-150405") + ".json"
+	}
+	if err := writeKeyFile(file, readFile()); err != nil {
+		print("cannot execute command: %s, %v\n", BACKUP, err)
+		return 1
+	}
+	print("saved a backup of %s to %s\n", keyFile, file)
+	return 0
+}
+
+// restore replaces the keys with a snapshot, the replaced keys being kept
+// as a generation for undo.
+func restore(command *flag.FlagSet, file string) int {
+	if file == "" {
+		print("cannot execute command: %s, usage: %s FILE\n", RESTORE, RESTORE)
+		command.PrintDefaults()
+		return 1
+	}
+	restored, err := readKeyFile(file)
+	if err != nil {
+		print("cannot execute command: %s, %v\n", RESTORE, err)
+		return 1
+	}
+	current := map[string]entry{}
+	if fileExists() {
+		current = readFile()
+	}
+	writeFile(restored)
+	commitKeys("Restore " + filepath.Base(file))
+	printMergeSummary("restored", keyFile, current, restored, nil)
+	return 0
+}
+
+type trashItem struct {
+	Entry   entry  `json:"entry"`
+	Deleted string `json:"deleted"`
+}
+
+func readTrash() (map[string]trashItem, error) {
+	items := map[string]trashItem{}
+	content, err := ioutil.ReadFile(trashFile)
+	if os.IsNotExist(err) {
+		return items, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &items)
+	}
+	return items, err
+}
+
+func writeTrash(items map[string]trashItem) error {
+	content, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(trashFile, content, 0600)
+}
+
+// trashKey keeps a deleted key in the trash, replacing a previously
+// deleted key of the same name.
+func trashKey(key string, e entry) error {
+	items, err := readTrash()
+	if err != nil {
+		return err
+	}
+	items[key] = trashItem{Entry: e, Deleted: timestamp()}
+	return writeTrash(items)
+}
+
+func trash(command *flag.FlagSet, args []string) int {
+	usage := func(format string, a ...interface{}) int {
+		print("cannot execute command: %s, %s\n", TRASH, fmt.Sprintf(format, a...))
+		command.PrintDefaults()
+		return 1
+	}
+	fail := func(err error) int {
+		print("cannot execute command: %s, %v\n", TRASH, err)
+		return 1
+	}
+	if len(args) == 0 {
+		args = []string{TRASHLIST}
+	}
+	items, err := readTrash()
+	if err != nil {
+		return fail(err)
+	}
+	switch args[0] {
+	case TRASHLIST:
+		if len(items) == 0 {
+			print("the trash is empty\n")
+			return 0
+		}
+		keys := make([]string, 0, len(items))
+		for key := range items {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var out bytes.Buffer
+		w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tDELETED\tCOMMAND")
+		for _, key := range keys {
+			deleted := items[key].Deleted
+			if t, err := time.Parse(time.RFC3339, deleted); err == nil {
+				deleted = t.Local().Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", key, deleted, items[key].Entry.Cmd)
+		}
+		w.Flush()
+		print("%s", out.String())
+		return 0
+	case TRASHRESTORE:
+		if len(args) != 2 {
+			return usage("usage: %s %s KEY", TRASH, TRASHRESTORE)
+		}
+		key := args[1]
+		item, ok := items[key]
+		if !ok {
+			return usage("the key %s is not in the trash", key)
+		}
+		sdMap := map[string]entry{}
+		if fileExists() {
+			sdMap = readFile()
+		}
+		if _, exists := sdMap[key]; exists {
+			return fail(fmt.Errorf("the key %s exists, delete it before restoring it", key))
+		}
+		sdMap[key] = item.Entry
+		writeFile(sdMap)
+		commitKeys("Restore " + key)
+		delete(items, key)
+		if err := writeTrash(items); err != nil {
+			return fail(err)
+		}
+		print("restored the key: %s as value: %s\n", key, item.Entry.Cmd)
+		return 0
+	case TRASHEMPTY:
+		if err := writeTrash(map[string]trashItem{}); err != nil {
+			return fail(err)
+		}
+		print("emptied the trash of %d key(s)\n", len(items))
+		return 0
+	}
+	return usage("unknown action \"%s\", expected %s, %s or %s", args[0], TRASHLIST, TRASHRESTORE, TRASHEMPTY)
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain moves every file sd keeps in the home directory to a temporary
+// one, so that the tests never change the keys, backups, sync repository,
+// secrets, shell or SSH files of whoever runs them, nor use their agent.
+func TestMain(m *testing.M) {
+	home := getHomeDir()
+	dir, _ := ioutil.TempDir("", "sd")
+	inDir := func(path string) string {
+		return filepath.Join(dir, strings.TrimPrefix(path, home))
+	}
+	for _, path := range []*string{&keyFile, &backupDir, &trashFile, &syncDir, &secretsFile, &remotesFile, &lastCommandFile, &sshConfigFile, &knownHostsFile} {
+		*path = inDir(*path)
+	}
+	for _, files := range []map[string]string{aliasFiles, rcFiles} {
+		for name, path := range files {
+			files[name] = inDir(path)
+		}
+	}
+	os.Setenv("HOME", dir)
+	os.Unsetenv("HISTFILE")
+	os.Unsetenv("SSH_AUTH_SOCK")
+	author = func() string { return "" }
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func TestBackups(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	defer func(file, backups, trash, sync, remotes string) {
+		keyFile, backupDir, trashFile, syncDir, remotesFile = file, backups, trash, sync, remotes
+	}(keyFile, backupDir, trashFile, syncDir, remotesFile)
+	keyFile = filepath.Join(dir, ".dial_keys")
+	backupDir = filepath.Join(dir, ".dial_keys.backups")
+	trashFile = filepath.Join(dir, ".dial_keys.trash")
+	syncDir = filepath.Join(dir, ".dial_keys.sync")
+	remotesFile = filepath.Join(dir, ".dial_remotes")
+	defer os.Setenv("SD_BACKUPS", os.Getenv("SD_BACKUPS"))
+	os.Setenv("SD_BACKUPS", "2")
+	defer func(write func(map[string]entry)) { writeFile = write }(writeFile)
+	writeFile = func(sdMap map[string]entry) {
+		backupKeyFile(sdMap)
+		writeKeyFile(keyFile, sdMap)
+	}
+	defer func(n func() time.Time) { now = n }(now)
+	now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+	defer func(secrets string, iterations int) { secretsFile, secretIterations = secrets, iterations }(secretsFile, secretIterations)
+	secretsFile = filepath.Join(dir, ".dial_secrets")
+	secretIterations = 1000
+	defer func(r func(string, bool) (string, error)) { readPassphrase = r }(readPassphrase)
+	readPassphrase = func(string, bool) (string, error) { return "passphrase", nil }
+	run := func(f func() int) string {
+		return capturePrint(f)
+	}
+	saved := func(key, val string) func() int {
+		return func() int {
+			return save(flag.NewFlagSet(SAVE, flag.ContinueOnError), key, val, "", nil, nil, SECRETSREFUSE)
+		}
+	}
+	warned := func(key, val string) func() int {
+		return func() int {
+			return save(flag.NewFlagSet(SAVE, flag.ContinueOnError), key, val, "", nil, nil, SECRETSWARN)
+		}
+	}
+	trashed := func(args ...string) func() int {
+		return func() int { return trash(flag.NewFlagSet(TRASH, flag.ContinueOnError), args) }
+	}
+	read := func(file string) string {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err.Error()
+		}
+		return string(content)
+	}
+	generations := func() string {
+		paths, _ := backupGenerations()
+		var out string
+		for _, path := range paths {
+			out += filepath.Base(path) + " " + read(path) + "\n"
+		}
+		return out
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test undo without changes",
+			tInput:  []T{undo},
+			tFunc:   run,
+			tOutput: "1\ncannot execute command: undo, there is no change to undo\n",
+		},
+		{
+			tName:   "Test first save",
+			tInput:  []T{saved("a", "echo a")},
+			tFunc:   run,
+			tOutput: "0\nSaved key a as value: echo a",
+		},
+		{
+			tName:   "Test second save",
+			tInput:  []T{saved("a", "echo A")},
+			tFunc:   run,
+			tOutput: "0\nSaved key a as value: echo A",
+		},
+		{
+			tName:   "Test third save",
+			tInput:  []T{saved("b", "echo b")},
+			tFunc:   run,
+			tOutput: "0\nSaved key b as value: echo b",
+		},
+		{
+			tName:  "Test only the configured number of generations are kept",
+			tInput: []T{},
+			tFunc:  generations,
+			tOutput: `000002.json {"a":{"cmd":"echo a","updated":"2020-01-02T03:04:05Z"}}` + "\n" +
+				`000003.json {"a":{"cmd":"echo A","updated":"2020-01-02T03:04:05Z","history":[{"cmd":"echo a","updated":"2020-01-02T03:04:05Z"}]}}` + "\n",
+		},
+		{
+			tName:   "Test undo",
+			tInput:  []T{undo},
+			tFunc:   run,
+			tOutput: "0\nremoved b\nrestored 1 key(s), 0 added and 0 updated in " + keyFile + "\n",
+		},
+		{
+			tName:   "Test undo again",
+			tInput:  []T{undo},
+			tFunc:   run,
+			tOutput: "0\nupdated a: echo a\nrestored 1 key(s), 0 added and 1 updated in " + keyFile + "\n",
+		},
+		{
+			tName:   "Test delete",
+			tInput:  []T{func() int { return deleted(flag.NewFlagSet(DELETE, flag.ContinueOnError), "a") }},
+			tFunc:   run,
+			tOutput: "0\ndeleted the key: a from speed dial keys",
+		},
+		{
+			tName:   "Test trash list",
+			tInput:  []T{trashed("list")},
+			tFunc:   run,
+			tOutput: "0\nKEY  DELETED           COMMAND\na    " + now().Local().Format("2006-01-02 15:04") + "  echo a\n",
+		},
+		{
+			tName:   "Test trash restore of a key not in the trash",
+			tInput:  []T{trashed("restore", "b")},
+			tFunc:   run,
+			tOutput: "1\ncannot execute command: trash, the key b is not in the trash\n",
+		},
+		{
+			tName:   "Test trash restore",
+			tInput:  []T{trashed("restore", "a")},
+			tFunc:   run,
+			tOutput: "0\nrestored the key: a as value: echo a\n",
+		},
+		{
+			tName:   "Test keys after trash restore",
+			tInput:  []T{keyFile},
+			tFunc:   read,
+			tOutput: `{"a":{"cmd":"echo a","updated":"2020-01-02T03:04:05Z"}}`,
+		},
+		{
+			tName:   "Test trash list when empty",
+			tInput:  []T{trashed()},
+			tFunc:   run,
+			tOutput: "0\nthe trash is empty\n",
+		},
+		{
+			tName:   "Test backup",
+			tInput:  []T{func() int { return backup(filepath.Join(dir, "snapshot.json")) }},
+			tFunc:   run,
+			tOutput: "0\nsaved a backup of " + keyFile + " to " + filepath.Join(dir, "snapshot.json") + "\n",
+		},
+		{
+			tName:   "Test save after backup",
+			tInput:  []T{saved("c", "echo c")},
+			tFunc:   run,
+			tOutput: "0\nSaved key c as value: echo c",
+		},
+		{
+			tName: "Test restore",
+			tInput: []T{func() int {
+				return restore(flag.NewFlagSet(RESTORE, flag.ContinueOnError), filepath.Join(dir, "snapshot.json"))
+			}},
+			tFunc:   run,
+			tOutput: "0\nremoved c\nrestored 1 key(s), 0 added and 0 updated in " + keyFile + "\n",
+		},
+		{
+			tName:   "Test undo restore",
+			tInput:  []T{undo},
+			tFunc:   run,
+			tOutput: "0\nadded c: echo c\nrestored 2 key(s), 1 added and 0 updated in " + keyFile + "\n",
+		},
+		{
+			tName: "Test restore a missing file",
+			tInput: []T{func() int {
+				return restore(flag.NewFlagSet(RESTORE, flag.ContinueOnError), filepath.Join(dir, "missing.json"))
+			}},
+			tFunc:   run,
+			tOutput: "1\ncannot execute command: restore, open " + filepath.Join(dir, "missing.json") + ": no such file or directory\n",
+		},
+		{
+			tName:   "Test save a password",
+			tInput:  []T{warned("db", "mysql -u root -phunter22 db")},
+			tFunc:   run,
+			tOutput: "0\nwarning: likely secrets in the commands\nKEY  KIND      VALUE\ndb   password  ********\nSaved key db as value: mysql -u root -phunter22 db",
+		},
+		{
+			tName:   "Test save another key with the same password",
+			tInput:  []T{warned("db2", "mysql -u root -phunter22 db2")},
+			tFunc:   run,
+			tOutput: "0\nwarning: likely secrets in the commands\nKEY  KIND      VALUE\ndb2  password  ********\nSaved key db2 as value: mysql -u root -phunter22 db2",
+		},
+		{
+			tName:   "Test delete the other key",
+			tInput:  []T{func() int { return deleted(flag.NewFlagSet(DELETE, flag.ContinueOnError), "db2") }},
+			tFunc:   run,
+			tOutput: "0\ndeleted the key: db2 from speed dial keys",
+		},
+		{
+			tName: "Test move the password into a secret",
+			tInput: []T{func() int {
+				writeRemotes(map[string]httpRemote{"team": {URL: "http://team", Base: map[string]entry{"db": {Cmd: "mysql -u root -phunter22 db"}}}})
+				return audit(true)
+			}},
+			tFunc:   run,
+			tOutput: "0\nmoved the password of key db into the secret db_password\n",
+		},
+		{
+			tName:  "Test the password is scrubbed from the generations, the trash and the remotes",
+			tInput: []T{},
+			tFunc: func() string {
+				paths, _ := backupGenerations()
+				var leaked []string
+				for _, path := range append(paths, keyFile, trashFile, remotesFile) {
+					if strings.Contains(read(path), "hunter22") {
+						leaked = append(leaked, filepath.Base(path))
+					}
+				}
+				return fmt.Sprint(leaked, len(paths))
+			},
+			tOutput: "[] 2",
+		},
+		{
+			tName:   "Test undo after moving the password",
+			tInput:  []T{undo},
+			tFunc:   run,
+			tOutput: "0\nadded db2: mysql -u root -p{secret:db_password} db2\nrestored 4 key(s), 1 added and 0 updated in " + keyFile + "\n",
+		},
+		{
+			tName: "Test undo refusing to bring back a moved password",
+			tInput: []T{func() int {
+				writeKeyFile(filepath.Join(backupDir, "999999.json"), map[string]entry{"db": {Cmd: "mysql -u root -phunter22 db"}})
+				return undo()
+			}},
+			tFunc:   run,
+			tOutput: "1\ncannot execute command: undo, the key db would get back the password moved into the secret db_password\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"strings"
+)
+
+var bashCompletion = `#!/bin/bash
+#
+# sd Bash Completion
+# =======================
+#
+# Generated by "sd completion bash", do not edit. Candidates are computed by
+# "sd __complete" from the commands and flags of the installed sd binary and
+# from the saved keys, so this script never needs to be kept in sync.
+#
+# Installation
+# ------------
+#
+#   sd completion bash > /etc/bash_completion.d/sd
+#
+# or add 'eval "$(sd completion bash)"' to your ~/.bashrc.
+#
+
+_sd() {
+  local cur candidates line IFS=$'\n'
+
+  COMP_WORDBREAKS=${COMP_WORDBREAKS//[:=]}
+
+  cur=${COMP_WORDS[COMP_CWORD]}
+  candidates=( $( sd __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null ) )
+
+  COMPREPLY=()
+  for line in "${candidates[@]}"; do
+    if [[ ${#candidates[@]} -gt 1 && $line == *$'\t'?* ]]; then
+      COMPREPLY+=( "${line%%$'\t'*}  -- ${line#*$'\t'}" )
+    else
+      COMPREPLY+=( "${line%%$'\t'*}" )
+    fi
+  done
+
+  if [[ ${#COMPREPLY[@]} -eq 1 && ${COMPREPLY[0]} == */ ]]; then
+    compopt -o nospace
+  fi
+
+  return 0
+}
+
+complete -o default -F _sd sd
+`
+
+var zshCompletion = `#compdef sd
+#
+# Generated by "sd completion zsh", do not edit. Add
+# 'source <(sd completion zsh)' to your ~/.zshrc, or save the output as _sd
+# in a directory of your $fpath.
+
+_sd() {
+  local line value desc
+  local -a lines candidates namespaces
+
+  lines=( "${(@f)$(sd __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}" )
+  for line in "${lines[@]}"; do
+    [[ -n $line ]] || continue
+    value=${line%%$'\t'*}
+    desc=
+    [[ $line == *$'\t'* ]] && desc=${line#*$'\t'}
+    if [[ $value == */ ]]; then
+      namespaces+=( "${value//:/\\:}:${desc}" )
+    else
+      candidates+=( "${value//:/\\:}:${desc}" )
+    fi
+  done
+
+  if (( ${#candidates} + ${#namespaces} == 0 )); then
+    _files
+    return
+  fi
+  (( ${#namespaces} )) && _describe -t namespaces 'namespace' namespaces -S ''
+  (( ${#candidates} )) && _describe -t sd 'sd' candidates
+}
+
+compdef _sd sd
+`
+
+var fishCompletion = `# Generated by "sd completion fish", do not edit. Save the output as
+# ~/.config/fish/completions/sd.fish
+
+function __sd_complete
+    set -l tokens (commandline -opc) (commandline -ct)
+    sd __complete $tokens[2..-1] 2>/dev/null
+end
+
+complete -c sd -f -a '(__sd_complete)'
+`
+
+var completionScripts = map[string]string{
+	BASH: bashCompletion,
+	ZSH:  zshCompletion,
+	FISH: fishCompletion,
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func findCommand(commands []*flag.FlagSet, name string) *flag.FlagSet {
+	for _, command := range commands {
+		if command.Name() == name {
+			return command
+		}
+	}
+	return nil
+}
+
+// completeKeys completes key names. Keys are grouped by namespace, the part
+// of the key up to a "/", so that "k8s/pods" and "k8s/logs" are first
+// offered as "k8s/".
+func completeKeys(sdMap map[string]entry, cur string) []string {
+	seen := map[string]bool{}
+	var candidates []string
+	for key, value := range sdMap {
+		if !strings.HasPrefix(key, cur) {
+			continue
+		}
+		if idx := strings.Index(key[len(cur):], "/"); idx >= 0 {
+			namespace := key[:len(cur)+idx+1]
+			if !seen[namespace] {
+				seen[namespace] = true
+				candidates = append(candidates, namespace+"\tnamespace")
+			}
+			continue
+		}
+		if value.Desc == "" {
+			candidates = append(candidates, key)
+			continue
+		}
+		candidates = append(candidates, key+"\t"+value.Desc)
+	}
+	return candidates
+}
+
+func completeTags(sdMap map[string]entry) []string {
+	var candidates []string
+	for _, row := range entityRows(sdMap, TAGS) {
+		candidates = append(candidates, row[0])
+	}
+	return candidates
+}
+
+func completeFlagValue(sdMap map[string]entry, name, cur string) []string {
+	switch name {
+	case "key":
+		return completeKeys(sdMap, cur)
+	case "tag":
+		return completeTags(sdMap)
+	case "o":
+		return []string{OUTTABLE, OUTJSON, OUTYAML, OUTCSV, OUTTSV, OUTPLAIN}
+	case "color":
+		return []string{COLORAUTO, COLORALWAYS, COLORNEVER}
+	case "shell":
+		return []string{BASH, ZSH, FISH, SH}
+	case "secrets":
+		return []string{SECRETSREFUSE, SECRETSWARN, SECRETSMOVE}
+	case "merge":
+		return []string{MERGEOURS, MERGETHEIRS, MERGENEWEST, MERGEINTERACTIVE}
+	case "format":
+		return []string{FORMATSCRIPT, FORMATMAKE, FORMATJUST}
+	case "ssh", "jump":
+		return sshHosts(sshConfigFile)
+	case "docker":
+		return commandOutput("docker ps --format '{{.Names}}'")
+	case "kube":
+		return commandOutput("kubectl get pods -o name | cut -d/ -f2")
+	case "file", "path", "alias-file", "id", "hosts", "git":
+		return completePath(cur, false)
+	case "from":
+		return []string{FROMBASHALIASES, FROMBASHRC, FROMZSHRC, FROMFISH}
+	}
+	return nil
+}
+
+func completePositional(sdMap map[string]entry, command string, cur string) []string {
+	switch command {
+	case SHOW, EXPAND, EDIT, LOG, REVERT:
+		return completeKeys(sdMap, cur)
+	case INIT, COMPLETION:
+		return []string{BASH, ZSH, FISH}
+	case DIFF, BACKUP, RESTORE:
+		return completePath(cur, false)
+	case TRASH:
+		return []string{TRASHLIST, TRASHRESTORE, TRASHEMPTY}
+	case SYNC:
+		all, _ := readRemotes()
+		names := []string{}
+		for name := range all {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	case REMOTE:
+		return []string{REMOTEADD, REMOTEREMOVE, REMOTELIST}
+	case SECRET:
+		return []string{SECRETSET, SECRETDELETE, SECRETLIST}
+	}
+	return nil
+}
+
+// completeWords returns the completion candidates for the last of words,
+// words being the command line without the leading "sd". Candidates may be
+// followed by a tab and a description.
+func completeWords(commands []*flag.FlagSet, sdMap map[string]entry, words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	if words[0] == ON && len(words) > 1 {
+		return completeOn(sdMap, words[1:])
+	}
+	if words[0] == "-d" {
+		words = words[1:]
+		if len(words) <= 1 {
+			return filterPrefix(completeKeys(sdMap, strings.Join(words, "")), strings.Join(words, ""))
+		}
+	}
+	cur := words[len(words)-1]
+	var candidates []string
+	if len(words) == 1 {
+		if strings.HasPrefix(cur, "-") {
+			return filterPrefix([]string{HELPSHORT, HELPSHORTER, "-d\tPrint the command before executing it", ON + "\tExecute a key on other hosts over SSH"}, cur)
+		}
+		for _, command := range commands {
+			candidates = append(candidates, helpText[command.Name()])
+		}
+		candidates = append(candidates, helpText[HELP])
+		return filterPrefix(append(candidates, completeKeys(sdMap, cur)...), cur)
+	}
+
+	command := findCommand(commands, words[0])
+	if command == nil {
+		if value, ok := sdMap[words[0]]; ok {
+			return filterPrefix(completeArg(value, len(words)-1, cur), cur)
+		}
+		return nil
+	}
+	if prev := strings.TrimLeft(words[len(words)-2], "-"); strings.HasPrefix(words[len(words)-2], "-") {
+		if f := command.Lookup(prev); f != nil && !isBoolFlag(f) {
+			return filterPrefix(completeFlagValue(sdMap, prev, cur), cur)
+		}
+	}
+	if strings.HasPrefix(cur, "-") {
+		command.VisitAll(func(f *flag.Flag) {
+			usage := strings.SplitN(f.Usage, "\n", 2)[0]
+			candidates = append(candidates, "-"+f.Name+"\t"+usage)
+		})
+		return filterPrefix(candidates, cur)
+	}
+	return filterPrefix(completePositional(sdMap, command.Name(), cur), cur)
+}
+
+// completeOn completes sd -on HOSTS [FLAGS] KEY [ARGS], words starting at
+// the hosts.
+func completeOn(sdMap map[string]entry, words []string) []string {
+	cur := words[len(words)-1]
+	if len(words) == 1 {
+		// Complete the last of the comma separated hosts.
+		prefix := cur[:strings.LastIndex(cur, ",")+1]
+		var candidates []string
+		for _, host := range sshHosts(sshConfigFile) {
+			candidates = append(candidates, prefix+host)
+		}
+		return filterPrefix(candidates, cur)
+	}
+	command := flag.NewFlagSet(ON, flag.ContinueOnError)
+	onFlags(command)
+	i := 1
+	for i < len(words)-1 && strings.HasPrefix(words[i], "-") {
+		f := command.Lookup(strings.TrimLeft(words[i], "-"))
+		i++
+		if f != nil && !isBoolFlag(f) {
+			i++
+		}
+	}
+	if i >= len(words) {
+		return nil
+	}
+	rest := words[i:]
+	if len(rest) == 1 {
+		if strings.HasPrefix(cur, "-") {
+			var candidates []string
+			command.VisitAll(func(f *flag.Flag) {
+				if f.Name != "on" {
+					candidates = append(candidates, "-"+f.Name+"\t"+f.Usage)
+				}
+			})
+			return filterPrefix(candidates, cur)
+		}
+		return filterPrefix(completeKeys(sdMap, cur), cur)
+	}
+	if value, ok := sdMap[rest[0]]; ok {
+		return filterPrefix(completeArg(value, len(rest)-1, cur), cur)
+	}
+	return nil
+}
+
+func filterPrefix(candidates []string, cur string) []string {
+	filtered := []string{}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, cur) {
+			filtered = append(filtered, candidate)
+		}
+	}
+	sort.Strings(filtered)
+	return filtered
+}
+
+func complete(commands []*flag.FlagSet, words []string) int {
+	sdMap := map[string]entry{}
+	if fileExists() {
+		sdMap = readFile()
+	}
+	candidates := completeWords(commands, sdMap, words)
+	if len(candidates) > 0 {
+		print("%s\n", strings.Join(candidates, "\n"))
+	}
+	return 0
+}
+
+func completion(command *flag.FlagSet, shell string) int {
+	if shell == "" {
+		command.PrintDefaults()
+		return 1
+	}
+	script, ok := completionScripts[shell]
+	if !ok {
+		print("cannot execute command: %s, unknown shell \"%s\", expected one of: bash, zsh, fish\n", COMPLETION, shell)
+		return 1
+	}
+	print("%s", script)
+	return 0
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+)
+
+func testCommands() []*flag.FlagSet {
+	saveCommand := flag.NewFlagSet(SAVE, flag.ExitOnError)
+	saveCommand.String("key", "", "Key to save. (Required)")
+	saveCommand.Var(&stringList{}, "tag", "Tag to group the key under. Can be repeated")
+	listCommand := flag.NewFlagSet(LIST, flag.ExitOnError)
+	listCommand.Bool("l", false, "List in full")
+	listCommand.String("o", OUTTABLE, "Output format")
+	return []*flag.FlagSet{saveCommand, listCommand, flag.NewFlagSet(SHOW, flag.ExitOnError)}
+}
+
+func TestCompleteWords(t *testing.T) {
+	defer func(file string) { sshConfigFile = file }(sshConfigFile)
+	sshConfigFile = "./test/ssh_config"
+	sdMap := map[string]entry{
+		"k8s/pods": {Cmd: "kubectl get pods", Tags: []string{"k8s"}},
+		"k8s/logs": {Cmd: "kubectl logs -f {1}"},
+		"serve":    {Cmd: "python -m http.server", Desc: "Serve the current directory"},
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test complete subcommands and keys",
+			tInput:  []T{testCommands(), sdMap, []string{"s"}},
+			tFunc:   completeWords,
+			tOutput: []string{"save\tSave/update a command as a speed dial key", "serve\tServe the current directory", "show\tShow a single speed dial key in full"},
+		},
+		{
+			tName:   "Test complete namespaces",
+			tInput:  []T{testCommands(), sdMap, []string{"k"}},
+			tFunc:   completeWords,
+			tOutput: []string{"k8s/\tnamespace"},
+		},
+		{
+			tName:   "Test complete keys in namespace",
+			tInput:  []T{testCommands(), sdMap, []string{"k8s/"}},
+			tFunc:   completeWords,
+			tOutput: []string{"k8s/logs", "k8s/pods"},
+		},
+		{
+			tName:   "Test complete flags",
+			tInput:  []T{testCommands(), sdMap, []string{"list", "-"}},
+			tFunc:   completeWords,
+			tOutput: []string{"-l\tList in full", "-o\tOutput format"},
+		},
+		{
+			tName:   "Test complete after bool flag",
+			tInput:  []T{testCommands(), sdMap, []string{"list", "-l", ""}},
+			tFunc:   completeWords,
+			tOutput: []string{},
+		},
+		{
+			tName:   "Test complete flag value",
+			tInput:  []T{testCommands(), sdMap, []string{"list", "-o", "y"}},
+			tFunc:   completeWords,
+			tOutput: []string{"yaml"},
+		},
+		{
+			tName:   "Test complete tags",
+			tInput:  []T{testCommands(), sdMap, []string{"save", "-tag", ""}},
+			tFunc:   completeWords,
+			tOutput: []string{"k8s"},
+		},
+		{
+			tName:   "Test complete positional key",
+			tInput:  []T{testCommands(), sdMap, []string{"show", "se"}},
+			tFunc:   completeWords,
+			tOutput: []string{"serve\tServe the current directory"},
+		},
+		{
+			tName:   "Test complete placeholder of key",
+			tInput:  []T{testCommands(), map[string]entry{"env": {Cmd: "deploy {1}", Args: map[int]string{1: "choices:dev,prod"}}}, []string{"env", "p"}},
+			tFunc:   completeWords,
+			tOutput: []string{"prod"},
+		},
+		{
+			tName:   "Test complete placeholder of key after debug flag",
+			tInput:  []T{testCommands(), map[string]entry{"env": {Cmd: "deploy {1}", Args: map[int]string{1: "choices:dev,prod"}}}, []string{"-d", "env", ""}},
+			tFunc:   completeWords,
+			tOutput: []string{"dev", "prod"},
+		},
+		{
+			tName:   "Test complete key after debug flag",
+			tInput:  []T{testCommands(), sdMap, []string{"-d", "se"}},
+			tFunc:   completeWords,
+			tOutput: []string{"serve\tServe the current directory"},
+		},
+		{
+			tName:   "Test complete hosts to execute on",
+			tInput:  []T{testCommands(), sdMap, []string{"-on", "bastion,web"}},
+			tFunc:   completeWords,
+			tOutput: []string{"bastion,web-1", "bastion,web-2"},
+		},
+		{
+			tName:   "Test complete key to execute on hosts",
+			tInput:  []T{testCommands(), sdMap, []string{"-on", "web-1", "-workers", "2", "k8s/p"}},
+			tFunc:   completeWords,
+			tOutput: []string{"k8s/pods"},
+		},
+		{
+			tName:   "Test complete flags to execute on hosts",
+			tInput:  []T{testCommands(), sdMap, []string{"-on", "web-1", "-f"}},
+			tFunc:   completeWords,
+			tOutput: []string{"-fail-fast\tStop all the hosts as soon as the command fails on one"},
+		},
+		{
+			tName:   "Test complete placeholder of key to execute on hosts",
+			tInput:  []T{testCommands(), map[string]entry{"env": {Cmd: "deploy {1}", Args: map[int]string{1: "choices:dev,prod"}}}, []string{"-on", "web-1", "-fail-fast", "env", "d"}},
+			tFunc:   completeWords,
+			tOutput: []string{"dev"},
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestBashCompletionFileIsGenerated(t *testing.T) {
+	content, _ := ioutil.ReadFile("./sd.bash-completion")
+	tt := []ttFStruct{
+		{
+			tName:   "Test sd.bash-completion matches \"sd completion bash\"",
+			tInput:  []T{string(content)},
+			tFunc:   func(content string) bool { return content == bashCompletion },
+			tOutput: true,
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestCompletion(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName: "Test completion without shell",
+			tInput: []T{
+				flag.NewFlagSet(COMPLETION, flag.ExitOnError),
+				"",
+			},
+			tFunc:   completion,
+			tOutput: 1,
+		},
+		{
+			tName: "Test completion for unknown shell",
+			tInput: []T{
+				flag.NewFlagSet(COMPLETION, flag.ExitOnError),
+				"tcsh",
+			},
+			tFunc:       completion,
+			tOutput:     1,
+			tPipeOutput: "cannot execute command: completion, unknown shell \"tcsh\", expected one of: bash, zsh, fish\n",
+		},
+		{
+			tName: "Test completion for fish",
+			tInput: []T{
+				flag.NewFlagSet(COMPLETION, flag.ExitOnError),
+				FISH,
+			},
+			tFunc:       completion,
+			tOutput:     0,
+			tPipeOutput: fishCompletion,
+		},
+	}
+	testPackageMethod(tt, t)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+var EDIT = "edit"
+
+// runEditor opens file in $VISUAL or $EDITOR, which may carry arguments
+// such as "code -w", and waits for it to be closed.
+var runEditor = func(file string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	args := strings.Fields(editor)
+	c := exec.Command(args[0], append(args[1:], file)...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return c.Run()
+}
+
+var errEditUnchanged = errors.New("unchanged")
+
+// editHint follows the error shown on top of a file reopened after an error.
+var editHint = "# fix it, or close the editor without changes to cancel\n"
+
+// stripComments removes the # lines at the start of content.
+func stripComments(content string) string {
+	lines := strings.SplitAfter(content, "\n")
+	for len(lines) > 0 && strings.HasPrefix(lines[0], "#") {
+		lines = lines[1:]
+	}
+	return strings.Join(lines, "")
+}
+
+// editContent opens content in the editor as a temporary file until parse
+// accepts it, showing the error on top of the file every time it does not.
+// It returns errEditUnchanged when the file is closed unchanged, or the
+// error when it is closed unchanged after one.
+func editContent(pattern, content string, parse func(edited string) error) error {
+	f, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	original := content
+	var parseErr error
+	for {
+		if err := ioutil.WriteFile(f.Name(), []byte(content), 0600); err != nil {
+			return err
+		}
+		if err := runEditor(f.Name()); err != nil {
+			return fmt.Errorf("the editor failed, %v", err)
+		}
+		edited, err := ioutil.ReadFile(f.Name())
+		if err != nil {
+			return err
+		}
+		if string(edited) == content {
+			if parseErr != nil {
+				return parseErr
+			}
+			return errEditUnchanged
+		}
+		if string(edited) == original {
+			return errEditUnchanged
+		}
+		if parseErr = parse(string(edited)); parseErr == nil {
+			return nil
+		}
+		content = "# error: " + strings.Replace(parseErr.Error(), "\n", "\n# ", -1) + "\n" + editHint + stripEditErrors(string(edited))
+	}
+}
+
+// stripEditErrors removes the error lines added on top of the file by
+// editContent.
+func stripEditErrors(content string) string {
+	if !strings.HasPrefix(content, "# error: ") {
+		return content
+	}
+	if i := strings.Index(content, editHint); i >= 0 {
+		return content[i+len(editHint):]
+	}
+	return content
+}
+
+// formatKeyTemplate lays out a key for editing, the fields on top and the
+// command as is below the first empty line, so nothing has to be quoted.
+func formatKeyTemplate(key string, e entry) string {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "# Editing the key %s, save and close the editor when done.\n", key)
+	fmt.Fprintf(&out, "# Fields are desc: TEXT, tags: TAG... and arg: N=SOURCE (repeated, sources as in sd save -arg).\n")
+	fmt.Fprintf(&out, "# The command follows the first empty line as is, without quoting, and may span lines.\n")
+	fmt.Fprintf(&out, "# Lines starting with # above it are ignored. An empty command cancels.\n")
+	fmt.Fprintf(&out, "# Environment variables are set in the command (NAME=VALUE command), options such as -d or -on are given when executing.\n")
+	fmt.Fprintf(&out, "desc: %s\n", e.Desc)
+	fmt.Fprintf(&out, "tags: %s\n", strings.Join(e.Tags, " "))
+	idxs := make([]int, 0, len(e.Args))
+	for idx := range e.Args {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	for _, idx := range idxs {
+		fmt.Fprintf(&out, "arg: %d=%s\n", idx, e.Args[idx])
+	}
+	fmt.Fprintf(&out, "\n%s\n", e.Cmd)
+	return out.String()
+}
+
+func parseKeyTemplate(content string) (entry, error) {
+	var e entry
+	lines := strings.Split(strings.Replace(content, "\r\n", "\n", -1), "\n")
+	for i, line := range lines {
+		if line == "" {
+			e.Cmd = strings.TrimSpace(strings.Join(lines[i+1:], "\n"))
+			lines = lines[:i]
+			break
+		}
+	}
+	var argSpecs []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		field := strings.SplitN(line, ":", 2)
+		if len(field) != 2 {
+			return e, fmt.Errorf("invalid line \"%s\", expected FIELD: VALUE or an empty line before the command", line)
+		}
+		value := strings.TrimSpace(field[1])
+		switch strings.TrimSpace(field[0]) {
+		case "desc":
+			e.Desc = value
+		case "tags":
+			e.Tags = strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		case "arg":
+			argSpecs = append(argSpecs, value)
+		default:
+			return e, fmt.Errorf("unknown field \"%s\", expected desc, tags or arg", strings.TrimSpace(field[0]))
+		}
+	}
+	if e.Cmd == "" {
+		return e, nil
+	}
+	if !isValidSave(e.Cmd) {
+		return e, errors.New("the command contains a default argument preceeding a regular argument")
+	}
+	args, err := parseArgSources(argSpecs, e.Cmd)
+	e.Args = args
+	return e, err
+}
+
+// edit opens a key, or all the keys when key is empty, in the editor.
+func edit(key, secretPolicy string) int {
+	if !isValidSecretPolicy(secretPolicy) {
+		return 1
+	}
+	if key == "" {
+		return editKeys(secretPolicy)
+	}
+	if err := validKey(key); err != nil {
+		print("cannot execute command: %s, invalid key \"%s\", it %v\n", EDIT, key, err)
+		return 1
+	}
+	sdMap := map[string]entry{}
+	if fileExists() {
+		sdMap = readFile()
+	}
+	previous := sdMap[key]
+	var edited entry
+	err := editContent("sd-edit-*.txt", formatKeyTemplate(key, previous), func(content string) error {
+		var err error
+		edited, err = parseKeyTemplate(content)
+		return err
+	})
+	if err == errEditUnchanged {
+		print("no changes to key %s\n", key)
+		return 0
+	}
+	if err != nil {
+		print("cannot execute command: %s, %v\n", EDIT, err)
+		return 1
+	}
+	if edited.Cmd == "" {
+		print("the command is empty, key %s was not saved\n", key)
+		return 0
+	}
+	e := previous
+	e.History = nil
+	e.Cmd, e.Desc, e.Tags, e.Args = edited.Cmd, edited.Desc, edited.Tags, edited.Args
+	e.Updated = timestamp()
+	saved := map[string]entry{key: e}
+	if !guardSecrets(EDIT, saved, secretPolicy) {
+		return 1
+	}
+	sdMap[key] = recordRevision(previous, saved[key])
+	writeFile(sdMap)
+	commitKeys("Edit " + key)
+	print("Saved key %s as value: %s\n", key, saved[key].Cmd)
+	return 0
+}
+
+// editKeys opens all the keys in the editor as JSON, without the times,
+// authors and history of the keys which are kept as they are.
+func editKeys(secretPolicy string) int {
+	sdMap := map[string]entry{}
+	if fileExists() {
+		sdMap = readFile()
+	}
+	view := map[string]interface{}{}
+	for key, e := range sdMap {
+		e.Updated, e.By, e.History = "", "", nil
+		if e.hasMetadata() {
+			view[key] = entryFields(e)
+		} else {
+			view[key] = e.Cmd
+		}
+	}
+	var out bytes.Buffer
+	out.WriteString("# Editing all the keys, save and close the editor when done. Removed keys are moved to the trash.\n")
+	out.WriteString("# A key is a command, or an object with cmd, desc, tags and args. Lines starting with # on top are ignored.\n")
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(view)
+
+	var edited map[string]entry
+	err := editContent("sd-edit-*.json", out.String(), func(content string) error {
+		edited = nil
+		if err := json.Unmarshal([]byte(stripComments(content)), &edited); err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(edited))
+		for key := range edited {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := validEntry(key, edited[key]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == errEditUnchanged {
+		print("no changes to the keys\n")
+		return 0
+	}
+	if err != nil {
+		print("cannot execute command: %s, %v\n", EDIT, err)
+		return 1
+	}
+	changed := map[string]entry{}
+	for key, e := range edited {
+		if previous, exists := sdMap[key]; !exists || !sameEntry(previous, e) {
+			e.Updated = timestamp()
+			changed[key] = e
+		}
+	}
+	if !guardSecrets(EDIT, changed, secretPolicy) {
+		return 1
+	}
+	updated := map[string]entry{}
+	for key := range edited {
+		updated[key] = sdMap[key]
+		if c, ok := changed[key]; ok {
+			updated[key] = recordRevision(sdMap[key], c)
+		}
+	}
+	for key, e := range sdMap {
+		if _, exists := updated[key]; !exists {
+			if err := trashKey(key, e); err != nil {
+				print("cannot execute command: %s, %v\n", EDIT, err)
+				return 1
+			}
+		}
+	}
+	writeFile(updated)
+	commitKeys("Edit the keys")
+	printMergeSummary("edited", keyFile, sdMap, updated, nil)
+	return 0
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKeyTemplate(t *testing.T) {
+	parse := func(content string) string {
+		e, err := parseKeyTemplate(content)
+		return fmt.Sprintf("%q %q %v %v %v", e.Cmd, e.Desc, e.Tags, e.Args, err)
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test template round trip",
+			tInput:  []T{formatKeyTemplate("logs", entry{Cmd: "kubectl logs {1}\n| grep \"$2\"", Desc: "Pod logs", Tags: []string{"k8s", "logs"}, Args: map[int]string{1: "cmd:kubectl get pods -o name"}})},
+			tFunc:   parse,
+			tOutput: `"kubectl logs {1}\n| grep \"$2\"" "Pod logs" [k8s logs] map[1:cmd:kubectl get pods -o name] <nil>`,
+		},
+		{
+			tName:   "Test comma separated tags",
+			tInput:  []T{"tags: a,b, c\n\necho a"},
+			tFunc:   parse,
+			tOutput: `"echo a" "" [a b c] map[] <nil>`,
+		},
+		{
+			tName:   "Test unknown field",
+			tInput:  []T{"env: A=1\n\necho a"},
+			tFunc:   parse,
+			tOutput: `"echo a" "" [] map[] unknown field "env", expected desc, tags or arg`,
+		},
+		{
+			tName:   "Test missing empty line",
+			tInput:  []T{"desc: a\necho a"},
+			tFunc:   parse,
+			tOutput: `"" "a" [] map[] invalid line "echo a", expected FIELD: VALUE or an empty line before the command`,
+		},
+		{
+			tName:   "Test default argument preceeding a regular argument",
+			tInput:  []T{"\necho {1|a} {2}"},
+			tFunc:   parse,
+			tOutput: `"echo {1|a} {2}" "" [] map[] the command contains a default argument preceeding a regular argument`,
+		},
+		{
+			tName:   "Test argument source without placeholder",
+			tInput:  []T{"arg: 2=files\n\necho {1}"},
+			tFunc:   parse,
+			tOutput: `"echo {1}" "" [] map[] invalid argument source "2=files", the command has no placeholder {2}`,
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestEdit(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	defer func(file, sync string) { keyFile, syncDir = file, sync }(keyFile, syncDir)
+	keyFile = filepath.Join(dir, ".dial_keys")
+	syncDir = filepath.Join(dir, ".dial_keys.sync")
+	defer func(write func(map[string]entry)) { writeFile = write }(writeFile)
+	writeFile = func(sdMap map[string]entry) { writeKeyFile(keyFile, sdMap) }
+	defer func(n func() time.Time) { now = n }(now)
+	now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+	// Every editing replaces the file by the next function of edits applied
+	// to its content, the files being kept in opened.
+	var edits []func(string) string
+	var opened []string
+	defer func(r func(string) error) { runEditor = r }(runEditor)
+	runEditor = func(file string) error {
+		content, _ := ioutil.ReadFile(file)
+		opened = append(opened, string(content))
+		if len(edits) == 0 {
+			return fmt.Errorf("exit status 1")
+		}
+		edited := edits[0](string(content))
+		edits = edits[1:]
+		return ioutil.WriteFile(file, []byte(edited), 0600)
+	}
+	edited := func(key string, e ...func(string) string) string {
+		edits, opened = e, nil
+		return capturePrint(func() int { return edit(key, SECRETSREFUSE) })
+	}
+	unchanged := func(content string) string { return content }
+	replace := func(old, new string) func(string) string {
+		return func(content string) string { return strings.Replace(content, old, new, 1) }
+	}
+	read := func(file string) string {
+		content, _ := ioutil.ReadFile(file)
+		return string(content)
+	}
+	tt := []ttFStruct{
+		{
+			tName: "Test new key without quoting",
+			tInput: []T{"greet", func(content string) string {
+				return strings.Replace(content, "desc: \n", "desc: Greet\n", 1) + "echo \"I'm $USER\" && date > /dev/null"
+			}},
+			tFunc:   edited,
+			tOutput: "0\nSaved key greet as value: echo \"I'm $USER\" && date > /dev/null\n",
+		},
+		{
+			tName:   "Test editing an unknown field reopens the file with the error",
+			tInput:  []T{"greet", replace("\ntags: ", "\nenv: A=1\ntags: "), replace("env: A=1\n", "")},
+			tFunc:   func(key string, e ...func(string) string) string { return edited(key, e...) + opened[1] },
+			tOutput: "0\nSaved key greet as value: echo \"I'm $USER\" && date > /dev/null\n" + "# error: unknown field \"env\", expected desc, tags or arg\n" + editHint + strings.Replace(formatKeyTemplate("greet", entry{Cmd: "echo \"I'm $USER\" && date > /dev/null", Desc: "Greet"}), "\ntags: ", "\nenv: A=1\ntags: ", 1),
+		},
+		{
+			tName:   "Test closing the reopened file unchanged cancels",
+			tInput:  []T{"greet", replace("Greet", "Hi\nx"), unchanged},
+			tFunc:   edited,
+			tOutput: "1\ncannot execute command: edit, invalid line \"x\", expected FIELD: VALUE or an empty line before the command\n",
+		},
+		{
+			tName:   "Test unchanged key",
+			tInput:  []T{"greet", unchanged},
+			tFunc:   edited,
+			tOutput: "0\nno changes to key greet\n",
+		},
+		{
+			tName:   "Test failing editor",
+			tInput:  []T{"greet"},
+			tFunc:   edited,
+			tOutput: "1\ncannot execute command: edit, the editor failed, exit status 1\n",
+		},
+		{
+			tName:  "Test all keys",
+			tInput: []T{"", replace(`"greet": {`, `"ls": "ls -l",`+"\n"+`  "greet": {`)},
+			tFunc:  func(key string, e ...func(string) string) string { return edited(key, e...) + opened[0] },
+			tOutput: "0\nadded ls: ls -l\nedited 2 key(s), 1 added and 0 updated in " + keyFile + "\n" +
+				"# Editing all the keys, save and close the editor when done. Removed keys are moved to the trash.\n" +
+				"# A key is a command, or an object with cmd, desc, tags and args. Lines starting with # on top are ignored.\n" +
+				"{\n  \"greet\": {\n    \"cmd\": \"echo \\\"I'm $USER\\\" && date > /dev/null\",\n    \"desc\": \"Greet\"\n  }\n}\n",
+		},
+		{
+			tName:   "Test all keys with an invalid key",
+			tInput:  []T{"", replace(`"ls": "ls -l"`, `"ls": ""`), unchanged},
+			tFunc:   edited,
+			tOutput: "1\ncannot execute command: edit, the command of key \"ls\" is empty\n",
+		},
+		{
+			tName:  "Test all keys with a reserved key reopens the file with the error",
+			tInput: []T{"", replace(`"ls": "ls -l"`, `"list": "ls -l"`), unchanged},
+			tFunc: func(key string, e ...func(string) string) string {
+				return edited(key, e...) + strings.SplitN(opened[1], "\n", 2)[0]
+			},
+			tOutput: "1\ncannot execute command: edit, invalid key \"list\", it is a reserved subcommand name\n# error: invalid key \"list\", it is a reserved subcommand name",
+		},
+		{
+			tName:   "Test all keys with a flag-like key",
+			tInput:  []T{"", replace(`"ls": "ls -l"`, `"-x": "ls -l"`), unchanged},
+			tFunc:   edited,
+			tOutput: "1\ncannot execute command: edit, invalid key \"-x\", it starts with -\n",
+		},
+		{
+			tName:  "Test all keys with an invalid argument source reopens the file with the error",
+			tInput: []T{"", replace(`"ls": "ls -l"`, `"ls": {"cmd": "ls {1}", "args": {"2": "files"}}`), replace(`"2": "files"`, `"1": "dirs"`)},
+			tFunc: func(key string, e ...func(string) string) string {
+				return edited(key, e...) + strings.SplitN(opened[1], "\n", 2)[0]
+			},
+			tOutput: "0\nupdated ls: ls {1}\nedited 2 key(s), 0 added and 1 updated in " + keyFile + "\n# error: key \"ls\": invalid argument source \"2=files\", the command has no placeholder {2}",
+		},
+		{
+			tName:   "Test all keys restoring a key",
+			tInput:  []T{"", replace(`"ls": {`+"\n"+`    "cmd": "ls {1}",`+"\n"+`    "args": {`+"\n"+`      "1": "dirs"`+"\n"+`    }`+"\n"+`  }`, `"ls": "ls -l"`)},
+			tFunc:   edited,
+			tOutput: "0\nupdated ls: ls -l\nedited 2 key(s), 0 added and 1 updated in " + keyFile + "\n",
+		},
+		{
+			tName: "Test all keys removing one",
+			tInput: []T{"", func(content string) string {
+				return stripComments(strings.Replace(content, ",\n  \"ls\": \"ls -l\"", "", 1))
+			}},
+			tFunc:   edited,
+			tOutput: "0\nremoved ls\nedited 1 key(s), 0 added and 0 updated in " + keyFile + "\n",
+		},
+		{
+			tName:   "Test keys after editing",
+			tInput:  []T{keyFile},
+			tFunc:   read,
+			tOutput: `{"greet":{"cmd":"echo \"I'm $USER\" \u0026\u0026 date \u003e /dev/null","desc":"Greet","updated":"2020-01-02T03:04:05Z"}}`,
+		},
+		{
+			tName:   "Test removed key is in the trash",
+			tInput:  []T{},
+			tFunc:   func() string { items, _ := readTrash(); return items["ls"].Entry.Cmd },
+			tOutput: "ls -l",
+		},
+	}
+	testPackageMethod(tt, t)
+}
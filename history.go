@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	LOG    = "log"
+	REVERT = "revert"
+)
+
+// revision is a previous value of a key.
+type revision struct {
+	Cmd     string `json:"cmd"`
+	Desc    string `json:"desc,omitempty"`
+	Updated string `json:"updated,omitempty"`
+	By      string `json:"by,omitempty"`
+}
+
+// maxRevisions is the number of previous values a key keeps.
+var maxRevisions = 50
+
+// author is the user@host recorded with the changes made here.
+var author = func() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	return name + "@" + host
+}
+
+func revisionOf(e entry) revision {
+	return revision{Cmd: e.Cmd, Desc: e.Desc, Updated: e.Updated, By: e.By}
+}
+
+// revisions returns the previous values of e followed by its current value,
+// revision N of a key being revisions(e)[N-1].
+func revisions(e entry) []revision {
+	return append(append([]revision{}, e.History...), revisionOf(e))
+}
+
+func trimHistory(history []revision) []revision {
+	if len(history) == 0 {
+		return nil
+	}
+	if len(history) > maxRevisions {
+		history = history[len(history)-maxRevisions:]
+	}
+	return history
+}
+
+// recordRevision returns updated, a new value of previous, keeping the
+// value of previous in its history when the command or description changes.
+func recordRevision(previous, updated entry) entry {
+	updated.History = previous.History
+	if previous.Cmd != "" && (previous.Cmd != updated.Cmd || previous.Desc != updated.Desc) {
+		updated.History = trimHistory(revisions(previous))
+	}
+	updated.By = author()
+	return updated
+}
+
+// mergeHistory returns kept with the revisions of other it lacks, other
+// itself becoming a revision when it lost the merge, so the history of a
+// merged key tells the changes made on both sides.
+func mergeHistory(kept, other entry) entry {
+	candidates := append(append([]revision{}, kept.History...), other.History...)
+	if other.Cmd != kept.Cmd || other.Desc != kept.Desc {
+		candidates = append(candidates, revisionOf(other))
+	}
+	seen := map[revision]bool{revisionOf(kept): true}
+	var history []revision
+	for _, r := range candidates {
+		if !seen[r] {
+			seen[r] = true
+			history = append(history, r)
+		}
+	}
+	// RFC 3339 times in UTC sort as strings.
+	sort.SliceStable(history, func(i, j int) bool { return history[i].Updated < history[j].Updated })
+	kept.History = trimHistory(history)
+	return kept
+}
+
+// diffWords marks the words of a removed with [-...-] and the words of b
+// added with {+...+}, like git diff --word-diff.
+func diffWords(a, b string) string {
+	x, y := strings.Fields(a), strings.Fields(b)
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var words, removed, added []string
+	flush := func() {
+		if len(removed) > 0 {
+			words = append(words, "[-"+strings.Join(removed, " ")+"-]")
+		}
+		if len(added) > 0 {
+			words = append(words, "{+"+strings.Join(added, " ")+"+}")
+		}
+		removed, added = nil, nil
+	}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			flush()
+			words = append(words, x[i])
+			i, j = i+1, j+1
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			removed = append(removed, x[i])
+			i++
+		default:
+			added = append(added, y[j])
+			j++
+		}
+	}
+	flush()
+	return strings.Join(words, " ")
+}
+
+func formatUpdated(updated string) string {
+	if t, err := time.Parse(time.RFC3339, updated); err == nil {
+		return t.Local().Format("2006-01-02 15:04")
+	}
+	return "-"
+}
+
+func formatBy(by string) string {
+	if by == "" {
+		return "-"
+	}
+	return by
+}
+
+// parseRevisionRange parses N or N:M into revision numbers, M defaulting to
+// the latest revision.
+func parseRevisionRange(spec string, latest int) (int, int, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	numbers := []int{0, latest}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 1 || n > latest {
+			return 0, 0, fmt.Errorf("invalid revision \"%s\", expected a number from 1 to %d", part, latest)
+		}
+		numbers[i] = n
+	}
+	return numbers[0], numbers[1], nil
+}
+
+// keyLog prints the revisions of a key, oldest first, or the changes between
+// two of them.
+func keyLog(command *flag.FlagSet, key, diffSpec string) int {
+	if key == "" {
+		print("cannot execute command: %s, usage: %s [-diff N[:M]] KEY\n", LOG, LOG)
+		command.PrintDefaults()
+		return 1
+	}
+	if !fileExists() {
+		return 1
+	}
+	e, exists := readFile()[key]
+	if !exists {
+		print("cannot execute command: %s, unknown key %s\n", LOG, key)
+		return 1
+	}
+	all := revisions(e)
+	if diffSpec != "" {
+		from, to, err := parseRevisionRange(diffSpec, len(all))
+		if err != nil {
+			print("cannot execute command: %s, %v\n", LOG, err)
+			return 1
+		}
+		a, b := all[from-1], all[to-1]
+		print("revision %d (%s, %s) -> %d (%s, %s)\n", from, formatUpdated(a.Updated), formatBy(a.By), to, formatUpdated(b.Updated), formatBy(b.By))
+		print("cmd:  %s\n", diffWords(a.Cmd, b.Cmd))
+		if a.Desc != b.Desc {
+			print("desc: %s\n", diffWords(a.Desc, b.Desc))
+		}
+		return 0
+	}
+	var out bytes.Buffer
+	w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REV\tUPDATED\tBY\tCOMMAND\tDESCRIPTION")
+	for i, r := range all {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", i+1, formatUpdated(r.Updated), formatBy(r.By), r.Cmd, r.Desc)
+	}
+	w.Flush()
+	print("%s", out.String())
+	return 0
+}
+
+// revert makes revision n the current value of a key, recording the
+// replaced value as a revision so reverting can be reverted as well.
+func revert(command *flag.FlagSet, key string, n int) int {
+	if key == "" || n == 0 {
+		print("cannot execute command: %s, usage: %s -to N KEY\n", REVERT, REVERT)
+		command.PrintDefaults()
+		return 1
+	}
+	if !fileExists() {
+		return 1
+	}
+	sdMap := readFile()
+	e, exists := sdMap[key]
+	if !exists {
+		print("cannot execute command: %s, unknown key %s\n", REVERT, key)
+		return 1
+	}
+	all := revisions(e)
+	if n < 1 || n > len(all) {
+		print("cannot execute command: %s, invalid revision %d, expected a number from 1 to %d\n", REVERT, n, len(all))
+		return 1
+	}
+	if n == len(all) {
+		print("revision %d is the current value of key %s\n", n, key)
+		return 0
+	}
+	reverted := e
+	reverted.Cmd, reverted.Desc = all[n-1].Cmd, all[n-1].Desc
+	reverted.Updated = timestamp()
+	sdMap[key] = recordRevision(e, reverted)
+	writeFile(sdMap)
+	commitKeys(fmt.Sprintf("Revert %s to revision %d", key, n))
+	print("reverted key %s to revision %d: %s\n", key, n, reverted.Cmd)
+	return 0
+}
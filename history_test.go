@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiffWords(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName:   "Test changed word",
+			tInput:  []T{"kubectl get pods -n dev", "kubectl get pods -n prod"},
+			tFunc:   diffWords,
+			tOutput: "kubectl get pods -n [-dev-] {+prod+}",
+		},
+		{
+			tName:   "Test added and removed words",
+			tInput:  []T{"ls -l /tmp", "ls -la --color /tmp /var"},
+			tFunc:   diffWords,
+			tOutput: "ls [--l-] {+-la --color+} /tmp {+/var+}",
+		},
+		{
+			tName:   "Test same commands",
+			tInput:  []T{"echo a", "echo a"},
+			tFunc:   diffWords,
+			tOutput: "echo a",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestMergeHistory(t *testing.T) {
+	merged := func(kept, other entry) string {
+		b, _ := mergeHistory(kept, other).MarshalJSON()
+		return string(b)
+	}
+	a := revision{Cmd: "echo a", Updated: "2020-01-01T00:00:00Z", By: "alice@one"}
+	b := revision{Cmd: "echo b", Updated: "2020-01-02T00:00:00Z", By: "bob@two"}
+	tt := []ttFStruct{
+		{
+			tName:   "Test same keys without history",
+			tInput:  []T{entry{Cmd: "echo a"}, entry{Cmd: "echo a"}},
+			tFunc:   merged,
+			tOutput: `"echo a"`,
+		},
+		{
+			tName: "Test losing value becomes a revision",
+			tInput: []T{
+				entry{Cmd: "echo c", Updated: "2020-01-03T00:00:00Z", By: "carol@three", History: []revision{a}},
+				entry{Cmd: "echo b", Updated: b.Updated, By: b.By, History: []revision{a}},
+			},
+			tFunc: merged,
+			tOutput: `{"cmd":"echo c","updated":"2020-01-03T00:00:00Z","by":"carol@three","history":[` +
+				`{"cmd":"echo a","updated":"2020-01-01T00:00:00Z","by":"alice@one"},{"cmd":"echo b","updated":"2020-01-02T00:00:00Z","by":"bob@two"}]}`,
+		},
+		{
+			tName: "Test histories of both sides are merged in time order",
+			tInput: []T{
+				entry{Cmd: "echo c", History: []revision{b}},
+				entry{Cmd: "echo c", History: []revision{a, b}},
+			},
+			tFunc: merged,
+			tOutput: `{"cmd":"echo c","history":[` +
+				`{"cmd":"echo a","updated":"2020-01-01T00:00:00Z","by":"alice@one"},{"cmd":"echo b","updated":"2020-01-02T00:00:00Z","by":"bob@two"}]}`,
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestHistory(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	defer func(file, sync string) { keyFile, syncDir = file, sync }(keyFile, syncDir)
+	keyFile = filepath.Join(dir, ".dial_keys")
+	syncDir = filepath.Join(dir, ".dial_keys.sync")
+	defer func(write func(map[string]entry)) { writeFile = write }(writeFile)
+	writeFile = func(sdMap map[string]entry) { writeKeyFile(keyFile, sdMap) }
+	defer func(n func() time.Time) { now = n }(now)
+	day := 1
+	now = func() time.Time { return time.Date(2020, 1, day, 3, 4, 5, 0, time.UTC) }
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.UTC
+	defer func(a func() string) { author = a }(author)
+	by := "alice@one"
+	author = func() string { return by }
+	defer func(max int) { maxRevisions = max }(maxRevisions)
+	maxRevisions = 2
+	saved := func(key, val, who string) string {
+		day++
+		by = who
+		return capturePrint(func() int {
+			return save(flag.NewFlagSet(SAVE, flag.ContinueOnError), key, val, "", nil, nil, SECRETSREFUSE)
+		})
+	}
+	logged := func(key, diffSpec string) string {
+		return capturePrint(func() int { return keyLog(flag.NewFlagSet(LOG, flag.ContinueOnError), key, diffSpec) })
+	}
+	reverted := func(key string, n int) string {
+		day++
+		return capturePrint(func() int { return revert(flag.NewFlagSet(REVERT, flag.ContinueOnError), key, n) })
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test first save",
+			tInput:  []T{"k", "kubectl get pods -n dev", "alice@one"},
+			tFunc:   saved,
+			tOutput: "0\nSaved key k as value: kubectl get pods -n dev",
+		},
+		{
+			tName:   "Test save by someone else",
+			tInput:  []T{"k", "kubectl get pods -n prod", "bob@two"},
+			tFunc:   saved,
+			tOutput: "0\nSaved key k as value: kubectl get pods -n prod",
+		},
+		{
+			tName:   "Test replaced plain value keeps when and by whom it was saved",
+			tInput:  []T{keyFile},
+			tFunc:   func(file string) string { b, _ := ioutil.ReadFile(file); return string(b) },
+			tOutput: `{"k":{"cmd":"kubectl get pods -n prod","updated":"2020-01-03T03:04:05Z","by":"bob@two","history":[{"cmd":"kubectl get pods -n dev","updated":"2020-01-02T03:04:05Z","by":"alice@one"}]}}`,
+		},
+		{
+			tName:   "Test log",
+			tInput:  []T{"k", ""},
+			tFunc:   logged,
+			tOutput: "0\nREV  UPDATED           BY         COMMAND                   DESCRIPTION\n1    2020-01-02 03:04  alice@one  kubectl get pods -n dev   \n2    2020-01-03 03:04  bob@two    kubectl get pods -n prod  \n",
+		},
+		{
+			tName:   "Test log diff",
+			tInput:  []T{"k", "1:2"},
+			tFunc:   logged,
+			tOutput: "0\nrevision 1 (2020-01-02 03:04, alice@one) -> 2 (2020-01-03 03:04, bob@two)\ncmd:  kubectl get pods -n [-dev-] {+prod+}\n",
+		},
+		{
+			tName:   "Test log diff with an invalid revision",
+			tInput:  []T{"k", "0"},
+			tFunc:   logged,
+			tOutput: "1\ncannot execute command: log, invalid revision \"0\", expected a number from 1 to 2\n",
+		},
+		{
+			tName:   "Test log of an unknown key",
+			tInput:  []T{"other", ""},
+			tFunc:   logged,
+			tOutput: "1\ncannot execute command: log, unknown key other\n",
+		},
+		{
+			tName:   "Test revert",
+			tInput:  []T{"k", 1},
+			tFunc:   reverted,
+			tOutput: "0\nreverted key k to revision 1: kubectl get pods -n dev\n",
+		},
+		{
+			tName:   "Test revert to the current value",
+			tInput:  []T{"k", 3},
+			tFunc:   reverted,
+			tOutput: "0\nrevision 3 is the current value of key k\n",
+		},
+		{
+			tName:   "Test revert to an unknown revision",
+			tInput:  []T{"k", 4},
+			tFunc:   reverted,
+			tOutput: "1\ncannot execute command: revert, invalid revision 4, expected a number from 1 to 3\n",
+		},
+		{
+			tName:   "Test saving the same value keeps the history",
+			tInput:  []T{"k", "kubectl get pods -n dev", "bob@two"},
+			tFunc:   saved,
+			tOutput: "0\nSaved key k as value: kubectl get pods -n dev",
+		},
+		{
+			tName:   "Test only the configured number of revisions are kept",
+			tInput:  []T{"k", "kubectl get pods -n test", "alice@one"},
+			tFunc:   func(key, val, who string) string { saved(key, val, who); return logged(key, "") },
+			tOutput: "0\nREV  UPDATED           BY         COMMAND                   DESCRIPTION\n1    2020-01-03 03:04  bob@two    kubectl get pods -n prod  \n2    2020-01-07 03:04  bob@two    kubectl get pods -n dev   \n3    2020-01-08 03:04  alice@one  kubectl get pods -n test  \n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	FROMBASHALIASES = "bash-aliases"
+	FROMBASHRC      = "bashrc"
+	FROMZSHRC       = "zshrc"
+	FROMFISH        = "fish"
+)
+
+var rcFiles = map[string]string{
+	FROMBASHALIASES: filepath.Join(getHomeDir(), ".bash_aliases"),
+	FROMBASHRC:      filepath.Join(getHomeDir(), ".bashrc"),
+	FROMZSHRC:       filepath.Join(getHomeDir(), ".zshrc"),
+	FROMFISH:        fishConfigFile(),
+}
+
+var (
+	rFuncStart     = regexp.MustCompile(`^(?:function\s+)?([A-Za-z0-9_.:-]+)\s*(?:\(\s*\))?\s*\{\s*(.*)$`)
+	rFishFuncStart = regexp.MustCompile(`^function\s+([A-Za-z0-9_.:-]+)(\s.*)?$`)
+	rPositional    = regexp.MustCompile(`\$\{([1-9]):-([^}]*)\}|\$\{?([1-9])\}?`)
+	rFishArgv      = regexp.MustCompile(`\$argv\[([1-9])\]`)
+	rAllArgs       = regexp.MustCompile(`\s*"?\$(?:@|\*|\{@\}|argv)"?\s*$`)
+	rUnsupported   = regexp.MustCompile(`\$(?:@|\*|\{@\}|#|argv|[0-9])`)
+)
+
+type importedKey struct {
+	key string
+	cmd string
+}
+
+// parseShellWords splits line into words the way a POSIX shell would,
+// handling single quotes, double quotes and backslash escapes. Parsing stops
+// at an unquoted comment. ok is false on unterminated quotes.
+func parseShellWords(line string) ([]string, bool) {
+	var words []string
+	var word strings.Builder
+	inWord, quote := false, rune(0)
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else if r == '\\' && i+1 < len(runes) && strings.ContainsRune("\"\\$`", runes[i+1]) {
+				i++
+				word.WriteRune(runes[i])
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == '\\' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case r == '#' && !inWord:
+			return words, true
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, quote == 0
+}
+
+// convertPositional converts the positional parameters of a function body
+// into placeholders: $1 and ${1} become {1}, ${1:-default} becomes
+// {1|default} and a trailing "$@" is dropped as sd appends any extra
+// arguments to the command itself.
+func convertPositional(body string, fish bool) (string, error) {
+	body = rAllArgs.ReplaceAllString(body, "")
+	if fish {
+		body = rFishArgv.ReplaceAllString(body, "{$1}")
+	} else {
+		var err error
+		body = rPositional.ReplaceAllStringFunc(body, func(match string) string {
+			sub := rPositional.FindStringSubmatch(match)
+			if sub[1] == "" {
+				return "{" + sub[3] + "}"
+			}
+			if sub[2] == "" || strings.ContainsAny(sub[2], " \t") {
+				err = fmt.Errorf("uses %s whose default value cannot be converted to a placeholder", match)
+			}
+			return "{" + sub[1] + "|" + sub[2] + "}"
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	if param := rUnsupported.FindString(body); param != "" {
+		return "", fmt.Errorf("uses %s which cannot be converted to a placeholder", param)
+	}
+	if !isValidSave(body) {
+		return "", fmt.Errorf("uses a default value before a required argument")
+	}
+	return body, nil
+}
+
+func parseAlias(words []string, fish bool) []importedKey {
+	var keys []importedKey
+	if fish && len(words) == 3 && !strings.Contains(words[1], "=") {
+		return []importedKey{{key: words[1], cmd: words[2]}}
+	}
+	for _, word := range words[1:] {
+		if strings.HasPrefix(word, "-") {
+			continue
+		}
+		parts := strings.SplitN(word, "=", 2)
+		if len(parts) == 2 && parts[0] != "" {
+			keys = append(keys, importedKey{key: parts[0], cmd: parts[1]})
+		}
+	}
+	return keys
+}
+
+// parseRCFile extracts the aliases and the functions of a shell rc file.
+// Functions which cannot be converted are reported as skipped.
+func parseRCFile(content string, fish bool) ([]importedKey, []string) {
+	var keys []importedKey
+	var skipped []string
+	lines := strings.Split(content, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "alias ") {
+			words, ok := parseShellWords(line)
+			if !ok {
+				skipped = append(skipped, fmt.Sprintf("line %d: unterminated quote", i+1))
+				continue
+			}
+			keys = append(keys, parseAlias(words, fish)...)
+			continue
+		}
+
+		var name string
+		var body []string
+		if m := rFishFuncStart.FindStringSubmatch(line); fish && m != nil {
+			name = m[1]
+			for i++; i < len(lines) && strings.TrimSpace(lines[i]) != "end"; i++ {
+				body = append(body, strings.TrimSpace(lines[i]))
+			}
+		} else if m := rFuncStart.FindStringSubmatch(line); !fish && m != nil {
+			name = m[1]
+			rest := strings.TrimSpace(m[2])
+			if strings.HasSuffix(rest, "}") {
+				body = append(body, strings.TrimSpace(strings.TrimSuffix(rest, "}")))
+			} else {
+				if rest != "" {
+					body = append(body, rest)
+				}
+				for i++; i < len(lines) && strings.TrimSpace(lines[i]) != "}"; i++ {
+					body = append(body, strings.TrimSpace(lines[i]))
+				}
+			}
+		} else {
+			continue
+		}
+
+		// The lines are kept as lines, as a line ending with then, do, | or
+		// && continues on the next one and cannot be followed by ;.
+		var commands []string
+		for _, cmd := range body {
+			if cmd = strings.TrimSpace(cmd); !strings.HasSuffix(cmd, ";;") {
+				cmd = strings.TrimSuffix(cmd, ";")
+			}
+			if cmd != "" && !strings.HasPrefix(cmd, "#") {
+				commands = append(commands, cmd)
+			}
+		}
+		cmd, err := convertPositional(strings.Join(commands, "\n"), fish)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("function %s: %v", name, err))
+			continue
+		}
+		if cmd != "" {
+			keys = append(keys, importedKey{key: name, cmd: cmd})
+		}
+	}
+	return keys, skipped
+}
+
+// planImport sorts the imported keys into the keys to save, the ones
+// conflicting with a saved key and the ones which cannot be keys at all.
+// Keys whose command is already saved are left out.
+func planImport(sdMap map[string]entry, keys []importedKey, overwrite bool) ([]importedKey, []string, []string) {
+	var toSave []importedKey
+	var conflicts, rejected []string
+	for _, k := range keys {
+		if err := validKey(k.key); err != nil {
+			rejected = append(rejected, fmt.Sprintf("%s: %v", k.key, err))
+			continue
+		}
+		existing, exists := sdMap[k.key]
+		if exists && existing.Cmd == k.cmd {
+			continue
+		}
+		if exists && !overwrite {
+			conflicts = append(conflicts, fmt.Sprintf("%s: already saved as \"%s\"", k.key, existing.Cmd))
+			continue
+		}
+		toSave = append(toSave, k)
+	}
+	sort.Slice(toSave, func(i, j int) bool { return toSave[i].key < toSave[j].key })
+	return toSave, conflicts, rejected
+}
+
+func importRC(command *flag.FlagSet, from, path string, dryRun, overwrite bool, secretPolicy string) int {
+	if from == "" {
+		command.PrintDefaults()
+		return 1
+	}
+	if !isValidSecretPolicy(secretPolicy) {
+		return 1
+	}
+	if path == "" {
+		path = rcFiles[from]
+	}
+	if _, known := rcFiles[from]; !known {
+		print("cannot execute command: %s, unknown source \"%s\", expected one of: bash-aliases, bashrc, zshrc, fish\n", IMPORT, from)
+		return 1
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		print("cannot execute command: %s, %v\n", IMPORT, err)
+		return 1
+	}
+	keys, skipped := parseRCFile(string(content), from == FROMFISH)
+
+	sdMap := map[string]entry{}
+	if fileExists() {
+		sdMap = readFile()
+	}
+	toSave, conflicts, rejected := planImport(sdMap, keys, overwrite)
+
+	verb := "imported"
+	if dryRun {
+		verb, secretPolicy = "would import", SECRETSWARN
+	}
+	saved := map[string]entry{}
+	for _, k := range toSave {
+		e := sdMap[k.key]
+		e.Cmd = k.cmd
+		e.Updated = timestamp()
+		saved[k.key] = e
+	}
+	if !guardSecrets(IMPORT, saved, secretPolicy) {
+		return 1
+	}
+	for _, k := range toSave {
+		print("%s %s: %s\n", verb, k.key, saved[k.key].Cmd)
+		sdMap[k.key] = recordRevision(sdMap[k.key], saved[k.key])
+	}
+	for _, conflict := range conflicts {
+		print("conflict, not imported: %s\n", conflict)
+	}
+	for _, reject := range rejected {
+		print("skipped %s\n", reject)
+	}
+	for _, skip := range skipped {
+		print("skipped %s\n", skip)
+	}
+	if len(conflicts) > 0 {
+		print("use -overwrite to replace the conflicting keys\n")
+	}
+	if !dryRun && len(toSave) > 0 {
+		writeFile(sdMap)
+		commitKeys(fmt.Sprintf("Import %d key(s) from %s", len(toSave), filepath.Base(path)))
+	}
+	print("%s %d key(s) from %s\n", verb, len(toSave), path)
+	return 0
+}
+
+func fishConfigFile() string {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(getHomeDir(), ".config")
+	}
+	return filepath.Join(configHome, "fish", "config.fish")
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParseShellWords(t *testing.T) {
+	words := func(line string) string {
+		w, ok := parseShellWords(line)
+		return fmt.Sprintf("%q %v", w, ok)
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test parse single quoted words",
+			tInput:  []T{"alias ll='ls -alF'"},
+			tFunc:   words,
+			tOutput: "[\"alias\" \"ll=ls -alF\"] true",
+		},
+		{
+			tName:   "Test parse escaped quotes",
+			tInput:  []T{"alias say='it'\\''s' q=\"a \\\"b\\\" \\$c\""},
+			tFunc:   words,
+			tOutput: "[\"alias\" \"say=it's\" \"q=a \\\"b\\\" $c\"] true",
+		},
+		{
+			tName:   "Test parse stops at comment",
+			tInput:  []T{"alias gs=\"git status\" # comment"},
+			tFunc:   words,
+			tOutput: "[\"alias\" \"gs=git status\"] true",
+		},
+		{
+			tName:   "Test parse unterminated quote",
+			tInput:  []T{"alias x='oops"},
+			tFunc:   words,
+			tOutput: "[\"alias\" \"x=oops\"] false",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestConvertPositional(t *testing.T) {
+	convert := func(body string, fish bool) string {
+		cmd, err := convertPositional(body, fish)
+		if err != nil {
+			return err.Error()
+		}
+		return cmd
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test convert positional parameters",
+			tInput:  []T{"ssh $1@${2}", false},
+			tFunc:   convert,
+			tOutput: "ssh {1}@{2}",
+		},
+		{
+			tName:   "Test convert default value",
+			tInput:  []T{"kubectl logs $1 -n ${2:-default}", false},
+			tFunc:   convert,
+			tOutput: "kubectl logs {1} -n {2|default}",
+		},
+		{
+			tName:   "Test convert drops trailing arguments",
+			tInput:  []T{"git commit \"$@\"", false},
+			tFunc:   convert,
+			tOutput: "git commit",
+		},
+		{
+			tName:   "Test convert fish argv",
+			tInput:  []T{"kubectl logs $argv[1] $argv", true},
+			tFunc:   convert,
+			tOutput: "kubectl logs {1}",
+		},
+		{
+			tName:   "Test convert unsupported parameter",
+			tInput:  []T{"echo $#", false},
+			tFunc:   convert,
+			tOutput: "uses $# which cannot be converted to a placeholder",
+		},
+		{
+			tName:   "Test convert default before required argument",
+			tInput:  []T{"echo ${1:-a} $2", false},
+			tFunc:   convert,
+			tOutput: "uses a default value before a required argument",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestParseRCFile(t *testing.T) {
+	bashrc, _ := ioutil.ReadFile("./test/bashrc")
+	fishConfig, _ := ioutil.ReadFile("./test/config.fish")
+	parse := func(content string, fish bool) string {
+		keys, skipped := parseRCFile(content, fish)
+		return fmt.Sprintf("%q %q", keys, skipped)
+	}
+	tt := []ttFStruct{
+		{
+			tName:  "Test parse bashrc",
+			tInput: []T{string(bashrc), false},
+			tFunc:  parse,
+			tOutput: fmt.Sprintf("%q %q", []importedKey{
+				{"ll", "ls -alF"},
+				{"gs", "git status"},
+				{"say", "echo \"it's \\\"quoted\\\"\""},
+				{"dq", "echo \"double\" $HOME"},
+				{"a1", "one"},
+				{"a2", "two"},
+				{"list", "ls"},
+				{"mkcd", "mkdir -p \"{1}\"\ncd \"{1}\""},
+				{"kl", "kubectl logs -f {1} -n {2|default}"},
+				{"greet", "echo hello {1}"},
+				{"pods", "if [ -z \"{1}\" ]; then\necho usage\nfi\nkubectl get pods |\ngrep \"{1}\" &&\necho found"},
+			}, []string{"function broken: uses $# which cannot be converted to a placeholder"}),
+		},
+		{
+			tName:  "Test parse fish config",
+			tInput: []T{string(fishConfig), true},
+			tFunc:  parse,
+			tOutput: fmt.Sprintf("%q %q", []importedKey{
+				{"ll", "ls -alF"},
+				{"gs", "git status"},
+				{"kl", "kubectl logs -f {1}"},
+			}, []string{}),
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestPlanImport(t *testing.T) {
+	sdMap := map[string]entry{
+		"ll": {Cmd: "ls -alF"},
+		"gs": {Cmd: "git status -s"},
+	}
+	keys := []importedKey{{"ll", "ls -alF"}, {"gs", "git status"}, {"list", "ls"}, {"mkcd", "mkdir -p {1}"}, {"-la", "ls -la"}}
+	plan := func(overwrite bool) string {
+		toSave, conflicts, rejected := planImport(sdMap, keys, overwrite)
+		return fmt.Sprintf("%q %q %q", toSave, conflicts, rejected)
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test plan import with conflicts",
+			tInput:  []T{false},
+			tFunc:   plan,
+			tOutput: fmt.Sprintf("%q %q %q", []importedKey{{"mkcd", "mkdir -p {1}"}}, []string{"gs: already saved as \"git status -s\""}, []string{"list: is a reserved subcommand name", "-la: starts with -"}),
+		},
+		{
+			tName:   "Test plan import overwriting conflicts",
+			tInput:  []T{true},
+			tFunc:   plan,
+			tOutput: fmt.Sprintf("%q %q %q", []importedKey{{"gs", "git status"}, {"mkcd", "mkdir -p {1}"}}, []string{}, []string{"list: is a reserved subcommand name", "-la: starts with -"}),
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestImportRC(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName: "Test import without source",
+			tInput: []T{
+				flag.NewFlagSet(IMPORT, flag.ExitOnError),
+				"",
+				"",
+				false,
+				false,
+				SECRETSREFUSE,
+			},
+			tFunc:   importRC,
+			tOutput: 1,
+		},
+		{
+			tName: "Test import from unknown source",
+			tInput: []T{
+				flag.NewFlagSet(IMPORT, flag.ExitOnError),
+				"cshrc",
+				"",
+				false,
+				false,
+				SECRETSREFUSE,
+			},
+			tFunc:       importRC,
+			tOutput:     1,
+			tPipeOutput: "cannot execute command: import, unknown source \"cshrc\", expected one of: bash-aliases, bashrc, zshrc, fish\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
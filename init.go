@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var (
+	BASH = "bash"
+	ZSH  = "zsh"
+	FISH = "fish"
+	SH   = "sh"
+)
+
+// The init scripts also record every command in lastCommandFile once it
+// has run, for sd save -last, after the process id of the shell so that
+// sd only takes the commands of the shell it runs in.
+var bashInit = `__sd_last() {
+  local status=$?
+  (umask 077; { echo $$; fc -ln -1; } >| ~/.dial_last) 2>/dev/null
+  return $status
+}
+[[ $PROMPT_COMMAND == *__sd_last* ]] || PROMPT_COMMAND="__sd_last${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+__sd_insert() {
+  local cmd
+  cmd=$(command sd pick -print) || return
+  READLINE_LINE="${READLINE_LINE:0:$READLINE_POINT}${cmd}${READLINE_LINE:$READLINE_POINT}"
+  READLINE_POINT=$(( READLINE_POINT + ${#cmd} ))
+}
+bind -m emacs-standard -x '"\C-%[1]s": __sd_insert'
+bind -m vi-insert -x '"\C-%[1]s": __sd_insert'
+`
+
+var zshInit = `__sd_last() {
+  (umask 077; { echo $$; fc -ln -1; } >| ~/.dial_last) 2>/dev/null
+}
+autoload -Uz add-zsh-hook
+add-zsh-hook precmd __sd_last
+__sd_insert() {
+  local cmd
+  cmd=$(command sd pick -print </dev/tty)
+  if [[ $? -eq 0 ]]; then
+    LBUFFER+="$cmd"
+  fi
+  zle reset-prompt
+}
+zle -N __sd_insert
+bindkey -M emacs '^%[2]s' __sd_insert
+bindkey -M viins '^%[2]s' __sd_insert
+`
+
+var fishInit = `function __sd_last --on-event fish_postexec
+    set -l mask (umask)
+    umask 077
+    printf '%%s\n' $fish_pid $argv[1] >~/.dial_last
+    umask $mask
+end
+function __sd_insert
+    set -l cmd (command sd pick -print | string collect)
+    and commandline -i -- $cmd
+    commandline -f repaint
+end
+bind \c%[1]s __sd_insert
+bind -M insert \c%[1]s __sd_insert
+`
+
+func initScript(shell, key string) (string, bool) {
+	scripts := map[string]string{BASH: bashInit, ZSH: zshInit, FISH: fishInit}
+	script, ok := scripts[shell]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf(script, strings.ToLower(key), strings.ToUpper(key)), true
+}
+
+func shellInit(command *flag.FlagSet, shell, key string) int {
+	if shell == "" || len(key) != 1 || !strings.Contains("abcdefghijklmnopqrstuvwxyz", strings.ToLower(key)) {
+		command.PrintDefaults()
+		return 1
+	}
+	script, ok := initScript(shell, key)
+	if !ok {
+		print("cannot execute command: %s, unknown shell \"%s\", expected one of: bash, zsh, fish\n", INIT, shell)
+		return 1
+	}
+	print("%s", script)
+	return 0
+}
+
+func expand(command *flag.FlagSet, key string, args []string) int {
+	if key == "" {
+		command.PrintDefaults()
+		return 1
+	}
+	if !fileExists() {
+		return 1
+	}
+	val, exists := readFile()[key]
+	if !exists {
+		print("cannot execute command: %s, unknown key %s\n", EXPAND, key)
+		return 1
+	}
+	cmd := parseCmd(val.Cmd, args)
+	if cmd == "" {
+		return 1
+	}
+	print("%s\n", cmd)
+	return 0
+}
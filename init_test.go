@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestShellInit(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName: "Test init without shell",
+			tInput: []T{
+				flag.NewFlagSet(INIT, flag.ExitOnError),
+				"",
+				"g",
+			},
+			tFunc:   shellInit,
+			tOutput: 1,
+		},
+		{
+			tName: "Test init with invalid key",
+			tInput: []T{
+				flag.NewFlagSet(INIT, flag.ExitOnError),
+				BASH,
+				"gg",
+			},
+			tFunc:   shellInit,
+			tOutput: 1,
+		},
+		{
+			tName: "Test init with unknown shell",
+			tInput: []T{
+				flag.NewFlagSet(INIT, flag.ExitOnError),
+				"tcsh",
+				"g",
+			},
+			tFunc:       shellInit,
+			tOutput:     1,
+			tPipeOutput: "cannot execute command: init, unknown shell \"tcsh\", expected one of: bash, zsh, fish\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestInitScript(t *testing.T) {
+	binding := func(shell, key string) bool {
+		script, ok := initScript(shell, key)
+		return ok && script != ""
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test init script for bash",
+			tInput:  []T{BASH, "g"},
+			tFunc:   binding,
+			tOutput: true,
+		},
+		{
+			tName:   "Test init script for zsh",
+			tInput:  []T{ZSH, "g"},
+			tFunc:   binding,
+			tOutput: true,
+		},
+		{
+			tName:   "Test init script for fish",
+			tInput:  []T{FISH, "g"},
+			tFunc:   binding,
+			tOutput: true,
+		},
+		{
+			tName:  "Test init scripts record the last command",
+			tInput: []T{"g"},
+			tFunc: func(key string) bool {
+				for _, shell := range []string{BASH, ZSH, FISH} {
+					if script, _ := initScript(shell, key); !strings.Contains(script, "__sd_last") || !strings.Contains(script, "~/.dial_last") {
+						return false
+					}
+				}
+				return true
+			},
+			tOutput: true,
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestExpand(t *testing.T) {
+	keyFile = "./test/.dial_keys_tagged"
+	tt := []ttFStruct{
+		{
+			tName: "Test expand without key",
+			tInput: []T{
+				flag.NewFlagSet(EXPAND, flag.ExitOnError),
+				"",
+				[]string{},
+			},
+			tFunc:   expand,
+			tOutput: 1,
+		},
+		{
+			tName: "Test expand unknown key",
+			tInput: []T{
+				flag.NewFlagSet(EXPAND, flag.ExitOnError),
+				"nope",
+				[]string{},
+			},
+			tFunc:       expand,
+			tOutput:     1,
+			tPipeOutput: "cannot execute command: expand, unknown key nope\n",
+		},
+		{
+			tName: "Test expand key with default",
+			tInput: []T{
+				flag.NewFlagSet(EXPAND, flag.ExitOnError),
+				"pods",
+				[]string{},
+			},
+			tFunc:       expand,
+			tOutput:     0,
+			tPipeOutput: "kubectl get pods -n default\n",
+		},
+		{
+			tName: "Test expand key with arguments",
+			tInput: []T{
+				flag.NewFlagSet(EXPAND, flag.ExitOnError),
+				"logs",
+				[]string{"pod-1", "-c", "app"},
+			},
+			tFunc:       expand,
+			tOutput:     0,
+			tPipeOutput: "kubectl logs -f pod-1 -c app\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// lastCommandFile holds the previous command of the shell, written after
+// every command by the hook of sd init.
+var lastCommandFile = getHomeDir() + string(os.PathSeparator) + ".dial_last"
+
+var rSafeWord = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// valuePatterns detect the values of a command worth a placeholder, in the
+// order they are tried on every word.
+var valuePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^[a-z][a-z0-9+.-]*://[^\s{}|]+$`),
+	regexp.MustCompile(`^[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)+$`),
+	regexp.MustCompile(`^\d{1,3}(\.\d{1,3}){3}(:\d+)?(/\d+)?$`),
+	regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`),
+	regexp.MustCompile(`^\d+$`),
+}
+
+var (
+	rHexID = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+	rWord  = regexp.MustCompile(`\S+`)
+)
+
+// rFlagValue splits a word such as --name=value, or a quoted value, into
+// the part to keep and the value.
+var rFlagValue = regexp.MustCompile(`^(--?[A-Za-z0-9-]+=|['"]?)(.*?)(['"]?)$`)
+
+// quoteWords joins words into a command, quoting the words the shell
+// would otherwise split or expand.
+func quoteWords(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = word
+		if !rSafeWord.MatchString(word) {
+			quoted[i] = quoteSh(word)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+func isValue(value string) bool {
+	for _, pattern := range valuePatterns {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+	// Hexadecimal ids, such as commit hashes, mix digits and letters.
+	return rHexID.MatchString(value) && strings.ContainsAny(value, "0123456789") && strings.ContainsAny(value, "abcdef")
+}
+
+// detectPlaceholders turns the values of cmd, such as URLs, addresses, ids
+// and numbers, into placeholders defaulting to them, so the command runs
+// as before without arguments. Repeated values share a placeholder.
+func detectPlaceholders(cmd string) string {
+	next := 1
+	for _, p := range placeholders(cmd) {
+		if p.idx >= next {
+			next = p.idx + 1
+		}
+	}
+	found := map[string]int{}
+	return rWord.ReplaceAllStringFunc(cmd, func(word string) string {
+		m := rFlagValue.FindStringSubmatch(word)
+		quoted := m[1] == `"` || m[1] == "'"
+		if (quoted && m[3] != m[1]) || (!quoted && m[3] != "") || !isValue(m[2]) {
+			return word
+		}
+		idx, ok := found[m[2]]
+		if !ok {
+			idx = next
+			found[m[2]] = idx
+			next++
+		}
+		return fmt.Sprintf("%s{%d|%s}%s", m[1], idx, m[2], m[3])
+	})
+}
+
+// isSaveCommand reports whether cmd saves a key, so it is not mistaken for
+// the command to save.
+func isSaveCommand(cmd string) bool {
+	words := strings.Fields(cmd)
+	if len(words) < 2 || words[1] != SAVE {
+		return false
+	}
+	name := filepath.Base(words[0])
+	return name == "sd" || name == filepath.Base(os.Args[0])
+}
+
+// hookCommand returns the command recorded in lastCommandFile by the hook
+// of sd init, when it was recorded by the shell sd runs in. A file left by
+// another shell, or by a hook of an older sd without the process id of
+// the shell, is ignored.
+func hookCommand() (string, bool) {
+	content, err := ioutil.ReadFile(lastCommandFile)
+	if err != nil {
+		return "", false
+	}
+	lines := strings.SplitN(string(content), "\n", 2)
+	if len(lines) < 2 || strings.TrimSpace(lines[0]) != strconv.Itoa(os.Getppid()) {
+		return "", false
+	}
+	cmd := strings.TrimSpace(lines[1])
+	return cmd, cmd != "" && !isSaveCommand(cmd)
+}
+
+// lastCommand returns the previous command of the shell, as recorded by the
+// hook of sd init, or else the last command of the history file of the
+// shell, warning that it may be from an earlier session since bash, for
+// one, only writes its history file when the shell exits.
+func lastCommand() (string, error) {
+	if cmd, ok := hookCommand(); ok {
+		return cmd, nil
+	}
+	shell := currentShell()
+	file := historyFile(shell)
+	commands, err := readShellHistory(file, shell)
+	if err != nil {
+		return "", fmt.Errorf("cannot read the history of %s, %v", shell, err)
+	}
+	for i := len(commands) - 1; i >= 0; i-- {
+		if cmd := strings.TrimSpace(commands[i]); cmd != "" && !isSaveCommand(cmd) {
+			print("warning: the hook of sd init recorded no command in this shell, taking the last command of %s, which can be from an earlier session\n", file)
+			return cmd, nil
+		}
+	}
+	return "", errors.New("the history is empty")
+}
+
+// saveValue returns the command to save, given by -val, -last or as the
+// words left after the flags, with its values turned into placeholders when
+// detect is set.
+func saveValue(val string, last bool, words []string, detect bool) (string, error) {
+	sources := 0
+	for _, given := range []bool{val != "", last, len(words) > 0} {
+		if given {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return "", errors.New("give the command with only one of -val, -last or -- COMMAND")
+	}
+	switch {
+	case last:
+		cmd, err := lastCommand()
+		if err != nil {
+			return "", err
+		}
+		val = cmd
+	case len(words) > 0:
+		val = quoteWords(words)
+	}
+	if detect {
+		val = detectPlaceholders(val)
+	}
+	return val, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDetectPlaceholders(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName:   "Test URL, address and number",
+			tInput:  []T{"curl -s --retry=3 http://10.0.0.1:8080/api?id=42 -o out.json"},
+			tFunc:   detectPlaceholders,
+			tOutput: "curl -s --retry={1|3} {2|http://10.0.0.1:8080/api?id=42} -o out.json",
+		},
+		{
+			tName:   "Test repeated values share a placeholder",
+			tInput:  []T{"ssh -p 2222 deploy@db.example.com && scp -P 2222 dump.sql deploy@db.example.com:"},
+			tFunc:   detectPlaceholders,
+			tOutput: "ssh -p {1|2222} {2|deploy@db.example.com} && scp -P {1|2222} dump.sql deploy@db.example.com:",
+		},
+		{
+			tName:   "Test ids and quoted values",
+			tInput:  []T{"git show 3f2a9c1 && docker rm 'c0ffee42' && kubectl get job 123e4567-e89b-12d3-a456-426614174000 \"10.1.2.3\""},
+			tFunc:   detectPlaceholders,
+			tOutput: "git show {1|3f2a9c1} && docker rm '{2|c0ffee42}' && kubectl get job {3|123e4567-e89b-12d3-a456-426614174000} \"{4|10.1.2.3}\"",
+		},
+		{
+			tName:   "Test existing placeholders are kept",
+			tInput:  []T{"ssh {1}@host -p 22"},
+			tFunc:   detectPlaceholders,
+			tOutput: "ssh {1}@host -p {2|22}",
+		},
+		{
+			tName:   "Test command without values",
+			tInput:  []T{"git status --short && decade deadbeef"},
+			tFunc:   detectPlaceholders,
+			tOutput: "git status --short && decade deadbeef",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestSaveValue(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	defer func(file string) { lastCommandFile = file }(lastCommandFile)
+	lastCommandFile = filepath.Join(dir, ".dial_last")
+	defer os.Setenv("HISTFILE", os.Getenv("HISTFILE"))
+	os.Setenv("HISTFILE", filepath.Join(dir, "history"))
+	defer os.Setenv("SHELL", os.Getenv("SHELL"))
+	os.Setenv("SHELL", "/bin/bash")
+	ioutil.WriteFile(filepath.Join(dir, "history"), []byte("kubectl get pods -n prod\nsd save -key a -last\n"), 0600)
+	value := func(val string, last bool, words []string, detect bool) string {
+		var cmd string
+		var err error
+		out := capturePrint(func() int {
+			cmd, err = saveValue(val, last, words, detect)
+			return 0
+		})
+		return strings.TrimPrefix(out, "0\n") + fmt.Sprintf("%s %v", cmd, err)
+	}
+	warning := "warning: the hook of sd init recorded no command in this shell, taking the last command of " + filepath.Join(dir, "history") + ", which can be from an earlier session\n"
+	tt := []ttFStruct{
+		{
+			tName:   "Test -val",
+			tInput:  []T{"echo a", false, []string(nil), false},
+			tFunc:   value,
+			tOutput: "echo a <nil>",
+		},
+		{
+			tName:   "Test words are quoted",
+			tInput:  []T{"", false, []string{"echo", "a b", "it's", "$HOME", "a|b", "--x=1", "user@host:/tmp"}, false},
+			tFunc:   value,
+			tOutput: `echo 'a b' 'it'\''s' '$HOME' 'a|b' --x=1 user@host:/tmp <nil>`,
+		},
+		{
+			tName:   "Test more than one command",
+			tInput:  []T{"echo a", true, []string(nil), false},
+			tFunc:   value,
+			tOutput: " give the command with only one of -val, -last or -- COMMAND",
+		},
+		{
+			tName:   "Test -last from the history file skips sd save",
+			tInput:  []T{"", true, []string(nil), true},
+			tFunc:   value,
+			tOutput: warning + "kubectl get pods -n prod <nil>",
+		},
+		{
+			tName:  "Test -last from the shell hook",
+			tInput: []T{"", true, []string(nil), true},
+			tFunc: func(val string, last bool, words []string, detect bool) string {
+				ioutil.WriteFile(lastCommandFile, []byte(fmt.Sprintf("%d\n\t git log -n 5\n", os.Getppid())), 0600)
+				return value(val, last, words, detect)
+			},
+			tOutput: "git log -n {1|5} <nil>",
+		},
+		{
+			tName:  "Test -last recorded by another shell falls back to the history file",
+			tInput: []T{"", true, []string(nil), false},
+			tFunc: func(val string, last bool, words []string, detect bool) string {
+				ioutil.WriteFile(lastCommandFile, []byte(fmt.Sprintf("%d\ngit log -n 5\n", os.Getppid()+1)), 0600)
+				return value(val, last, words, detect)
+			},
+			tOutput: warning + "kubectl get pods -n prod <nil>",
+		},
+		{
+			tName:  "Test -last recorded by an older hook falls back to the history file",
+			tInput: []T{"", true, []string(nil), false},
+			tFunc: func(val string, last bool, words []string, detect bool) string {
+				ioutil.WriteFile(lastCommandFile, []byte("git log -n 5\n"), 0600)
+				return value(val, last, words, detect)
+			},
+			tOutput: warning + "kubectl get pods -n prod <nil>",
+		},
+		{
+			tName:  "Test -last after sd save falls back to the history file",
+			tInput: []T{"", true, []string(nil), false},
+			tFunc: func(val string, last bool, words []string, detect bool) string {
+				ioutil.WriteFile(lastCommandFile, []byte(fmt.Sprintf("%d\n/usr/local/bin/sd save -key b -last\n", os.Getppid())), 0600)
+				return value(val, last, words, detect)
+			},
+			tOutput: warning + "kubectl get pods -n prod <nil>",
+		},
+	}
+	testPackageMethod(tt, t)
+}
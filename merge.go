@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	MERGEOURS        = "ours"
+	MERGETHEIRS      = "theirs"
+	MERGENEWEST      = "newest"
+	MERGEINTERACTIVE = "interactive"
+)
+
+var DIFF = "diff"
+
+var now = time.Now
+
+func timestamp() string {
+	return now().UTC().Format(time.RFC3339)
+}
+
+func updatedAt(e entry) time.Time {
+	t, err := time.Parse(time.RFC3339, e.Updated)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// sameEntry compares two entries ignoring when and by whom they were last
+// updated, and their history.
+func sameEntry(a, b entry) bool {
+	a.Updated, b.Updated = "", ""
+	a.By, b.By = "", ""
+	a.History, b.History = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+func diffKeys(ours, theirs map[string]entry) ([]string, []string, []string) {
+	var added, removed, changed []string
+	for key, e := range theirs {
+		existing, exists := ours[key]
+		if !exists {
+			added = append(added, key)
+		} else if !sameEntry(existing, e) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range ours {
+		if _, exists := theirs[key]; !exists {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
+
+var chooseTheirs = func(key string, ours, theirs entry) (bool, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer tty.Close()
+	return promptConflict(bufio.NewReader(tty), tty, key, ours, theirs)
+}
+
+func promptConflict(in *bufio.Reader, out io.Writer, key string, ours, theirs entry) (bool, error) {
+	fmt.Fprintf(out, "key %s differs:\n  ours:   %s\n  theirs: %s\n", key, ours.Cmd, theirs.Cmd)
+	for {
+		fmt.Fprint(out, "keep [o]urs or take [t]heirs? ")
+		answer, err := in.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "o", "ours":
+			return false, nil
+		case "t", "theirs":
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// mergeKeys returns the union of both stores. Keys present in both with a
+// different command are resolved by the strategy, ours being the local store.
+func mergeKeys(ours, theirs map[string]entry, strategy string) (map[string]entry, []string, error) {
+	merged := map[string]entry{}
+	for key, e := range ours {
+		merged[key] = e
+	}
+	_, _, conflicts := diffKeys(ours, theirs)
+	for key, e := range theirs {
+		if o, exists := ours[key]; !exists {
+			merged[key] = e
+		} else {
+			merged[key] = mergeHistory(o, e)
+		}
+	}
+	for _, key := range conflicts {
+		takeTheirs := false
+		switch strategy {
+		case MERGETHEIRS:
+			takeTheirs = true
+		case MERGENEWEST:
+			takeTheirs = updatedAt(theirs[key]).After(updatedAt(ours[key]))
+		case MERGEINTERACTIVE:
+			var err error
+			if takeTheirs, err = chooseTheirs(key, ours[key], theirs[key]); err != nil {
+				return nil, nil, err
+			}
+		}
+		if takeTheirs {
+			merged[key] = mergeHistory(theirs[key], ours[key])
+		}
+	}
+	return merged, conflicts, nil
+}
+
+func isValidStrategy(strategy string) bool {
+	if isValidFormat(strategy, MERGEOURS, MERGETHEIRS, MERGENEWEST, MERGEINTERACTIVE) {
+		return true
+	}
+	print("unknown merge strategy \"%s\", expected one of: ours, theirs, newest, interactive\n", strategy)
+	return false
+}
+
+// printMergeSummary reports what merging changed in the destination store,
+// and which conflicting keys it kept.
+func printMergeSummary(verb, dest string, before, after map[string]entry, conflicts []string) {
+	added, removed, changed := diffKeys(before, after)
+	for _, key := range added {
+		print("added %s: %s\n", key, after[key].Cmd)
+	}
+	for _, key := range removed {
+		print("removed %s\n", key)
+	}
+	for _, key := range changed {
+		print("updated %s: %s\n", key, after[key].Cmd)
+	}
+	var kept []string
+	for _, key := range conflicts {
+		if sameEntry(before[key], after[key]) {
+			kept = append(kept, key)
+		}
+	}
+	if len(kept) > 0 {
+		print("kept the conflicting keys already in %s: %s\n", dest, strings.Join(kept, ", "))
+	}
+	print("%s %d key(s), %d added and %d updated in %s\n", verb, len(after), len(added), len(changed), dest)
+}
+
+func exportToFile(file, strategy string) int {
+	if !isValidStrategy(strategy) || !fileExists() {
+		return 1
+	}
+	theirs := map[string]entry{}
+	if _, err := os.Stat(file); err == nil {
+		if theirs, err = readKeyFile(file); err != nil {
+			print("cannot execute command: %s, %v\n", EXPORT, err)
+			return 1
+		}
+	}
+	merged, conflicts, err := mergeKeys(readFile(), theirs, strategy)
+	if err != nil {
+		print("cannot execute command: %s, %v\n", EXPORT, err)
+		return 1
+	}
+	if err := writeKeyFile(file, merged); err != nil {
+		print("cannot execute command: %s, %v\n", EXPORT, err)
+		return 1
+	}
+	printMergeSummary("exported", file, theirs, merged, conflicts)
+	return 0
+}
+
+func importFile(file, strategy string, dryRun bool, secretPolicy string) int {
+	if !isValidStrategy(strategy) || !isValidSecretPolicy(secretPolicy) {
+		return 1
+	}
+	theirs, err := readKeyFile(file)
+	if err != nil {
+		print("cannot execute command: %s, %v\n", IMPORT, err)
+		return 1
+	}
+	return mergeLocal(IMPORT, theirs, strategy, dryRun, secretPolicy, "imported", "would import", "Import the keys of "+filepath.Base(file))
+}
+
+// mergeLocal merges theirs into the local keys, committing them with message
+// to the sync repository, and only reports what would change on a dry run.
+// The likely credentials of the keys received are handled by secretPolicy,
+// a dry run only warning about them.
+func mergeLocal(command string, theirs map[string]entry, strategy string, dryRun bool, secretPolicy, verb, dryRunVerb, message string) int {
+	ours := map[string]entry{}
+	if fileExists() {
+		ours = readFile()
+	}
+	merged, conflicts, err := mergeKeys(ours, theirs, strategy)
+	if err != nil {
+		print("cannot execute command: %s, %v\n", command, err)
+		return 1
+	}
+	if dryRun {
+		secretPolicy = SECRETSWARN
+	}
+	if !guardIncoming(command, ours, merged, secretPolicy) {
+		return 1
+	}
+	if dryRun {
+		verb = dryRunVerb
+	} else {
+		writeFile(merged)
+		commitKeys(message)
+	}
+	printMergeSummary(verb, keyFile, ours, merged, conflicts)
+	return 0
+}
+
+func diff(command *flag.FlagSet, file string) int {
+	if file == "" {
+		command.PrintDefaults()
+		return 1
+	}
+	theirs, err := readKeyFile(file)
+	if err != nil {
+		print("cannot execute command: %s, %v\n", DIFF, err)
+		return 1
+	}
+	ours := map[string]entry{}
+	if fileExists() {
+		ours = readFile()
+	}
+	added, removed, changed := diffKeys(ours, theirs)
+	for _, key := range added {
+		print("+ %s\t%s\n", key, theirs[key].Cmd)
+	}
+	for _, key := range removed {
+		print("- %s\t%s\n", key, ours[key].Cmd)
+	}
+	for _, key := range changed {
+		print("~ %s\t%s -> %s\n", key, ours[key].Cmd, theirs[key].Cmd)
+	}
+	if len(added)+len(removed)+len(changed) == 0 {
+		print("no differences with %s\n", file)
+	}
+	return 0
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func capturePrint(f func() int) string {
+	var out bytes.Buffer
+	print = func(format string, a ...interface{}) (int, error) {
+		return fmt.Fprintf(&out, format, a...)
+	}
+	code := f()
+	return fmt.Sprintf("%d\n%s", code, out.String())
+}
+
+func TestEntryUpdated(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName:   "Test entry with update time is stored as object",
+			tInput:  []T{entry{Cmd: "ls", Updated: "2020-01-02T03:04:05Z"}},
+			tFunc:   func(e entry) string { b, _ := e.MarshalJSON(); return string(b) },
+			tOutput: `{"cmd":"ls","updated":"2020-01-02T03:04:05Z"}`,
+		},
+		{
+			tName:   "Test entries differing in update time only are the same",
+			tInput:  []T{entry{Cmd: "ls", Updated: "2020-01-02T03:04:05Z"}, entry{Cmd: "ls"}},
+			tFunc:   sameEntry,
+			tOutput: true,
+		},
+		{
+			tName:   "Test entries differing in description are not the same",
+			tInput:  []T{entry{Cmd: "ls", Desc: "list"}, entry{Cmd: "ls"}},
+			tFunc:   sameEntry,
+			tOutput: false,
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestDiffKeys(t *testing.T) {
+	ours := map[string]entry{"a": {Cmd: "echo a"}, "b": {Cmd: "echo b"}, "c": {Cmd: "echo c"}}
+	theirs := map[string]entry{"b": {Cmd: "echo B"}, "c": {Cmd: "echo c", Updated: "2020-01-02T03:04:05Z"}, "d": {Cmd: "echo d"}}
+	tt := []ttFStruct{
+		{
+			tName:  "Test diff keys",
+			tInput: []T{ours, theirs},
+			tFunc: func(ours, theirs map[string]entry) string {
+				added, removed, changed := diffKeys(ours, theirs)
+				return fmt.Sprintf("%v %v %v", added, removed, changed)
+			},
+			tOutput: "[d] [a] [b]",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestMergeKeys(t *testing.T) {
+	defer func(choose func(string, entry, entry) (bool, error)) { chooseTheirs = choose }(chooseTheirs)
+	chooseTheirs = func(key string, ours, theirs entry) (bool, error) {
+		return key == "b", nil
+	}
+	ours := map[string]entry{
+		"a": {Cmd: "echo a", Updated: "2020-01-01T00:00:00Z"},
+		"b": {Cmd: "echo b", Updated: "2020-01-01T00:00:00Z"},
+		"c": {Cmd: "echo c"},
+	}
+	theirs := map[string]entry{
+		"a": {Cmd: "echo A", Updated: "2019-01-01T00:00:00Z"},
+		"b": {Cmd: "echo B", Updated: "2021-01-01T00:00:00Z"},
+		"d": {Cmd: "echo d"},
+	}
+	merge := func(strategy string) string {
+		merged, conflicts, err := mergeKeys(ours, theirs, strategy)
+		return fmt.Sprintf("%s %s %s %s %v %v", merged["a"].Cmd, merged["b"].Cmd, merged["c"].Cmd, merged["d"].Cmd, conflicts, err)
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test merge keeping ours",
+			tInput:  []T{MERGEOURS},
+			tFunc:   merge,
+			tOutput: "echo a echo b echo c echo d [a b] <nil>",
+		},
+		{
+			tName:   "Test merge taking theirs",
+			tInput:  []T{MERGETHEIRS},
+			tFunc:   merge,
+			tOutput: "echo A echo B echo c echo d [a b] <nil>",
+		},
+		{
+			tName:   "Test merge taking the newest",
+			tInput:  []T{MERGENEWEST},
+			tFunc:   merge,
+			tOutput: "echo a echo B echo c echo d [a b] <nil>",
+		},
+		{
+			tName:   "Test merge interactively",
+			tInput:  []T{MERGEINTERACTIVE},
+			tFunc:   merge,
+			tOutput: "echo a echo B echo c echo d [a b] <nil>",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestMergeNewestAfterRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	roundTrip := func(name string, keys map[string]entry) map[string]entry {
+		file := filepath.Join(dir, name)
+		if err := writeKeyFile(file, keys); err != nil {
+			t.Fatal(err)
+		}
+		read, err := readKeyFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return read
+	}
+	merge := func(ours, theirs map[string]entry) string {
+		merged, conflicts, err := mergeKeys(roundTrip("ours.json", ours), roundTrip("theirs.json", theirs), MERGENEWEST)
+		return fmt.Sprintf("%s %s %v %v", merged["k"].Cmd, merged["k"].By, conflicts, err)
+	}
+	older := map[string]entry{"k": {Cmd: "echo old", Updated: "2020-01-01T00:00:00Z", By: "alice@one"}}
+	newer := map[string]entry{"k": {Cmd: "echo new", Updated: "2021-01-01T00:00:00Z", By: "bob@two"}}
+	tt := []ttFStruct{
+		{
+			tName:   "Test newest merge takes a newer key read from a file",
+			tInput:  []T{older, newer},
+			tFunc:   merge,
+			tOutput: "echo new bob@two [k] <nil>",
+		},
+		{
+			tName:   "Test newest merge keeps our newer key read from a file",
+			tInput:  []T{newer, older},
+			tFunc:   merge,
+			tOutput: "echo new bob@two [k] <nil>",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestPromptConflict(t *testing.T) {
+	prompt := func(input string) string {
+		var out bytes.Buffer
+		takeTheirs, err := promptConflict(bufio.NewReader(strings.NewReader(input)), &out, "k", entry{Cmd: "echo a"}, entry{Cmd: "echo b"})
+		return fmt.Sprintf("%v %v %q", takeTheirs, err, out.String())
+	}
+	question := "key k differs:\n  ours:   echo a\n  theirs: echo b\nkeep [o]urs or take [t]heirs? "
+	tt := []ttFStruct{
+		{
+			tName:   "Test prompt conflict taking theirs after a wrong answer",
+			tInput:  []T{"x\nt\n"},
+			tFunc:   prompt,
+			tOutput: fmt.Sprintf("true <nil> %q", question+"keep [o]urs or take [t]heirs? "),
+		},
+		{
+			tName:   "Test prompt conflict keeping ours",
+			tInput:  []T{"ours\n"},
+			tFunc:   prompt,
+			tOutput: fmt.Sprintf("false <nil> %q", question),
+		},
+		{
+			tName:   "Test prompt conflict without answer",
+			tInput:  []T{""},
+			tFunc:   prompt,
+			tOutput: fmt.Sprintf("false EOF %q", question),
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestImportAndExportFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	keyFile = filepath.Join(dir, ".dial_keys")
+	other := filepath.Join(dir, "other.json")
+	ioutil.WriteFile(keyFile, []byte(`{"a":"echo a","b":"echo b"}`), 0644)
+	ioutil.WriteFile(other, []byte(`{"b":"echo B","c":"echo c"}`), 0644)
+	secrets := filepath.Join(dir, "secrets.json")
+	ioutil.WriteFile(secrets, []byte(`{"db":"mysql -u root -phunter22 db"}`), 0644)
+	defer func(write func(map[string]entry)) { writeFile = write }(writeFile)
+	writeFile = func(sdMap map[string]entry) { writeKeyFile(keyFile, sdMap) }
+	read := func(file string) string {
+		content, _ := ioutil.ReadFile(file)
+		return string(content)
+	}
+	tt := []ttFStruct{
+		{
+			tName:       "Test import of a missing file",
+			tInput:      []T{filepath.Join(dir, "missing.json"), MERGEOURS, false, SECRETSREFUSE},
+			tFunc:       importFile,
+			tOutput:     1,
+			tPipeOutput: "cannot execute command: import, open " + filepath.Join(dir, "missing.json") + ": no such file or directory\n",
+		},
+		{
+			tName:       "Test import with unknown strategy",
+			tInput:      []T{other, "mine", false, SECRETSREFUSE},
+			tFunc:       importFile,
+			tOutput:     1,
+			tPipeOutput: "unknown merge strategy \"mine\", expected one of: ours, theirs, newest, interactive\n",
+		},
+		{
+			tName:  "Test diff",
+			tInput: []T{other},
+			tFunc: func(file string) string {
+				return capturePrint(func() int { return diff(flag.NewFlagSet(DIFF, flag.ExitOnError), file) })
+			},
+			tOutput: "0\n+ c\techo c\n- a\techo a\n~ b\techo b -> echo B\n",
+		},
+		{
+			tName:  "Test dry run import keeping ours",
+			tInput: []T{other},
+			tFunc: func(file string) string {
+				return capturePrint(func() int { return importFile(file, MERGEOURS, true, SECRETSREFUSE) }) + read(keyFile)
+			},
+			tOutput: "0\nadded c: echo c\nkept the conflicting keys already in " + keyFile + ": b\n" +
+				"would import 3 key(s), 1 added and 0 updated in " + keyFile + "\n" +
+				`{"a":"echo a","b":"echo b"}`,
+		},
+		{
+			tName:  "Test import taking theirs",
+			tInput: []T{other},
+			tFunc: func(file string) string {
+				return capturePrint(func() int { return importFile(file, MERGETHEIRS, false, SECRETSREFUSE) }) + read(keyFile)
+			},
+			tOutput: "0\nadded c: echo c\nupdated b: echo B\nimported 3 key(s), 1 added and 1 updated in " + keyFile + "\n" +
+				`{"a":"echo a","b":{"cmd":"echo B","history":[{"cmd":"echo b"}]},"c":"echo c"}`,
+		},
+		{
+			tName:  "Test import refusing likely secrets",
+			tInput: []T{secrets, SECRETSREFUSE},
+			tFunc: func(file, policy string) string {
+				return capturePrint(func() int { return importFile(file, MERGEOURS, false, policy) }) + read(keyFile)
+			},
+			tOutput: "1\nlikely secrets in the commands\nKEY  KIND      VALUE\ndb   password  ********\n" +
+				"cannot execute command: import, use -secrets move to move them into secrets, or -secrets warn to go on anyway\n" +
+				`{"a":"echo a","b":{"cmd":"echo B","history":[{"cmd":"echo b"}]},"c":"echo c"}`,
+		},
+		{
+			tName:  "Test import of keys without secrets next to a key holding one",
+			tInput: []T{other, SECRETSREFUSE},
+			tFunc: func(file, policy string) string {
+				writeKeyFile(keyFile, map[string]entry{"db": {Cmd: "mysql -u root -phunter22 db"}})
+				defer writeKeyFile(keyFile, map[string]entry{"a": {Cmd: "echo a"}, "b": {Cmd: "echo B", History: []revision{{Cmd: "echo b"}}}, "c": {Cmd: "echo c"}})
+				return capturePrint(func() int { return importFile(file, MERGEOURS, true, policy) })
+			},
+			tOutput: "0\nadded b: echo B\nadded c: echo c\nwould import 3 key(s), 2 added and 0 updated in " + keyFile + "\n",
+		},
+		{
+			tName:  "Test import warning about likely secrets",
+			tInput: []T{secrets, SECRETSWARN},
+			tFunc: func(file, policy string) string {
+				defer writeKeyFile(keyFile, map[string]entry{"a": {Cmd: "echo a"}, "b": {Cmd: "echo B", History: []revision{{Cmd: "echo b"}}}, "c": {Cmd: "echo c"}})
+				return capturePrint(func() int { return importFile(file, MERGEOURS, false, policy) })
+			},
+			tOutput: "0\nwarning: likely secrets in the commands\nKEY  KIND      VALUE\ndb   password  ********\n" +
+				"added db: mysql -u root -phunter22 db\nimported 4 key(s), 1 added and 0 updated in " + keyFile + "\n",
+		},
+		{
+			tName:       "Test diff without differences",
+			tInput:      []T{flag.NewFlagSet(DIFF, flag.ExitOnError), keyFile},
+			tFunc:       diff,
+			tOutput:     0,
+			tPipeOutput: "no differences with " + keyFile + "\n",
+		},
+		{
+			tName:  "Test export to a new file",
+			tInput: []T{filepath.Join(dir, "new.json")},
+			tFunc: func(file string) string {
+				return capturePrint(func() int { return exportToFile(file, MERGEOURS) }) + read(file)
+			},
+			tOutput: "0\nadded a: echo a\nadded b: echo B\nadded c: echo c\nexported 3 key(s), 3 added and 0 updated in " + filepath.Join(dir, "new.json") + "\n" +
+				`{"a":"echo a","b":{"cmd":"echo B","history":[{"cmd":"echo b"}]},"c":"echo c"}`,
+		},
+	}
+	testPackageMethod(tt, t)
+}
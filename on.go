@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var ON = "-on"
+
+// onOptions are the flags of sd -on HOSTS KEY [ARGS].
+type onOptions struct {
+	hosts    string
+	workers  int
+	timeout  time.Duration
+	failFast bool
+	debug    bool
+	port     string
+	jump     string
+	identity string
+}
+
+func onFlags(command *flag.FlagSet) *onOptions {
+	o := &onOptions{}
+	command.StringVar(&o.hosts, "on", "", "Comma separated SSH aliases or [user@]hosts to execute the key on")
+	command.IntVar(&o.workers, "workers", 8, "Number of hosts to execute the key on at the same time")
+	command.DurationVar(&o.timeout, "timeout", 0, "Time allowed for the command on every host, 0 for no limit")
+	command.BoolVar(&o.failFast, "fail-fast", false, "Stop all the hosts as soon as the command fails on one")
+	command.BoolVar(&o.debug, "d", false, "Print the command before executing it")
+	command.StringVar(&o.port, "port", "", "SSH port of the hosts, instead of 22 or the configured one")
+	command.StringVar(&o.jump, "jump", "", "Jump host(s) to connect through, as [user@]host[:port] separated by commas")
+	command.StringVar(&o.identity, "id", "", "Specific private key file to use, instead of the identities of the SSH configuration or agent")
+	return o
+}
+
+var printErr = func(format string, a ...interface{}) (int, error) {
+	return fmt.Fprintf(os.Stderr, format, a...)
+}
+
+// sshRun runs cmd on r without a terminal, connecting like export and pull
+// do. Cancelling ctx closes the connection.
+var sshRun = func(ctx context.Context, r remote, cmd string, stdin io.Reader, stdout, stderr io.Writer) error {
+	client, closeSSH, err := dialSSH(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer closeSSH()
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdin, session.Stdout, session.Stderr = stdin, stdout, stderr
+	err = session.Run(cmd)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// exitStatus is the error of a command which ran on a host and failed.
+type exitStatus interface {
+	ExitStatus() int
+}
+
+var outputMu sync.Mutex
+
+// prefixWriter prints every complete line written to it behind a prefix, so
+// the lines of several hosts can be told apart.
+type prefixWriter struct {
+	prefix string
+	print  func(format string, a ...interface{}) (int, error)
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.printLine(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+}
+
+func (w *prefixWriter) printLine(line []byte) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	w.print("%s%s\n", w.prefix, line)
+}
+
+func (w *prefixWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.printLine(w.buf)
+		w.buf = nil
+	}
+}
+
+type onResult struct {
+	host    string
+	status  string
+	code    int
+	elapsed time.Duration
+	err     error
+}
+
+func (r onResult) failed() bool {
+	return r.status == "failed" || r.status == "timeout"
+}
+
+func runOn(ctx context.Context, r remote, cmd, input, prefix string, timeout time.Duration) onResult {
+	result := onResult{host: r.host()}
+	if ctx.Err() != nil {
+		result.status = "skipped"
+		return result
+	}
+	hostCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		hostCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	stdout := &prefixWriter{prefix: prefix, print: print}
+	stderr := &prefixWriter{prefix: prefix, print: printErr}
+	start := time.Now()
+	err := sshRun(hostCtx, r, cmd, strings.NewReader(input), stdout, stderr)
+	result.elapsed = since(start)
+	stdout.Flush()
+	stderr.Flush()
+	var exitErr exitStatus
+	switch {
+	case ctx.Err() != nil:
+		result.status, result.err = "cancelled", errors.New("stopped after a failure on another host")
+	case hostCtx.Err() != nil:
+		result.status, result.code, result.err = "timeout", 124, fmt.Errorf("timed out after %s", timeout)
+	case errors.As(err, &exitErr):
+		result.status, result.code = "failed", exitErr.ExitStatus()
+	case err != nil:
+		result.status, result.code, result.err = "failed", 255, err
+	default:
+		result.status = "ok"
+	}
+	return result
+}
+
+// readSecretsFirst makes cmd read the secrets of env from its input, one
+// per line, as the environment does not reach the other hosts and command
+// lines are seen by everyone on them. The reads and the quoting of
+// the secrets need a POSIX shell, so the command is run by sh whatever
+// the login shell of the remote user.
+func readSecretsFirst(cmd string, env []string) (string, error) {
+	if len(env) == 0 {
+		return cmd, nil
+	}
+	reads := ""
+	for _, variable := range env {
+		parts := strings.SplitN(variable, "=", 2)
+		if strings.Contains(parts[1], "\n") {
+			return "", errors.New("a secret of the command spans several lines, which cannot be sent to other hosts")
+		}
+		reads += "IFS= read -r " + parts[0] + "; "
+	}
+	return "sh -c " + quoteAnyShell(reads+cmd), nil
+}
+
+// quoteAnyShell quotes val as one word for POSIX shells, csh and fish
+// alike, leaving backslashes, which fish unescapes in single quotes, out of
+// the quotes.
+func quoteAnyShell(val string) string {
+	return "'" + strings.NewReplacer("'", `'\''`, `\`, `'\\'`).Replace(val) + "'"
+}
+
+// secretsInput is the input of the command made by readSecretsFirst.
+func secretsInput(env []string) string {
+	input := ""
+	for _, variable := range env {
+		input += strings.SplitN(variable, "=", 2)[1] + "\n"
+	}
+	return input
+}
+
+func formatOnResults(results []onResult) string {
+	var out bytes.Buffer
+	w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSTATUS\tEXIT\tTIME\tDETAILS")
+	for _, r := range results {
+		code, details := "-", ""
+		if r.status == "ok" || r.status == "failed" || r.status == "timeout" {
+			code = fmt.Sprint(r.code)
+		}
+		if r.err != nil {
+			details = r.err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.host, r.status, code, r.elapsed.Round(100*time.Millisecond), details)
+	}
+	w.Flush()
+	return out.String()
+}
+
+func splitHosts(hosts string) []string {
+	var split []string
+	for _, host := range strings.Split(hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			split = append(split, host)
+		}
+	}
+	return split
+}
+
+// executeOn expands a key locally and runs it on every host over SSH,
+// returning the highest exit code of the hosts.
+func executeOn(command *flag.FlagSet, o onOptions) int {
+	hosts := splitHosts(o.hosts)
+	args := command.Args()
+	if len(hosts) == 0 || len(args) == 0 {
+		print("cannot execute command: %s, usage: sd -on HOST[,HOST...] KEY [ARGS]\n", ON)
+		command.PrintDefaults()
+		return 1
+	}
+	if o.workers < 1 {
+		print("cannot execute command: %s, -workers must be at least 1\n", ON)
+		return 1
+	}
+	targets := make([]remote, len(hosts))
+	for i, host := range hosts {
+		// Prompts would mix up when several hosts connect at once.
+		targets[i] = remote{sshAlias: host, port: o.port, jump: o.jump, identity: o.identity, batch: len(hosts) > 1}
+		if err := targets[i].validate(); err != nil {
+			print("cannot execute command: %s, %v\n", ON, err)
+			return 1
+		}
+	}
+	if !fileExists() {
+		return 1
+	}
+	val, exists := readFile()[args[0]]
+	if !exists {
+		print("cannot execute command: unknown key \"%s\"\n", args[0])
+		return 1
+	}
+	cmd := parseCmd(val.Cmd, args[1:])
+	if cmd == "" {
+		return 1
+	}
+	if o.debug {
+		print("Executed CMD: %s\n", cmd)
+	}
+	cmd, env, err := resolveSecrets(cmd)
+	if err == nil {
+		cmd, err = readSecretsFirst(cmd, env)
+	}
+	if err != nil {
+		print("cannot execute command: %s, %v\n", args[0], err)
+		return 1
+	}
+	input := secretsInput(env)
+	width := 0
+	for _, host := range hosts {
+		if len(host) > width {
+			width = len(host)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := make([]onResult, len(hosts))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < o.workers && w < len(hosts); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = runOn(ctx, targets[i], cmd, input, fmt.Sprintf("%-*s | ", width, hosts[i]), o.timeout)
+				if o.failFast && results[i].failed() {
+					cancel()
+				}
+			}
+		}()
+	}
+	for i := range hosts {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	exitCode := 0
+	for _, r := range results {
+		if r.failed() && r.code > exitCode {
+			exitCode = r.code
+		}
+	}
+	if len(hosts) > 1 || exitCode != 0 {
+		printErr("%s", formatOnResults(results))
+	}
+	return exitCode
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testExitStatus struct {
+	*exec.ExitError
+}
+
+func (e testExitStatus) ExitStatus() int {
+	return e.ExitCode()
+}
+
+func TestExecuteOn(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	defer func(file string) { keyFile = file }(keyFile)
+	keyFile = filepath.Join(dir, ".dial_keys")
+	ioutil.WriteFile(keyFile, []byte(`{
+		"hello": "echo hello {1} from $HOST; echo warning >&2",
+		"fail": "test $HOST != b || exit 3; echo done",
+		"slow": "test $HOST != a || sleep 5; echo done",
+		"stuck": "test $HOST != a || sleep 5; test $HOST != b || exit 3"
+	}`), 0644)
+	defer func(run func(context.Context, remote, string, io.Reader, io.Writer, io.Writer) error) { sshRun = run }(sshRun)
+	sshRun = func(ctx context.Context, r remote, cmd string, stdin io.Reader, stdout, stderr io.Writer) error {
+		c := exec.CommandContext(ctx, "sh", "-c", cmd)
+		c.Env = append(os.Environ(), "HOST="+r.host())
+		c.Stdin, c.Stdout, c.Stderr = stdin, stdout, stderr
+		c.WaitDelay = time.Second
+		err := c.Run()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return testExitStatus{exitErr}
+		}
+		return err
+	}
+	defer func(s func(time.Time) time.Duration) { since = s }(since)
+	since = func(time.Time) time.Duration { return 0 }
+	defer func(p, e func(string, ...interface{}) (int, error)) { print, printErr = p, e }(print, printErr)
+	on := func(args ...string) string {
+		var stderr bytes.Buffer
+		printErr = func(format string, a ...interface{}) (int, error) {
+			return fmt.Fprintf(&stderr, format, a...)
+		}
+		command := flag.NewFlagSet(ON, flag.ContinueOnError)
+		o := onFlags(command)
+		command.Parse(args)
+		out := capturePrint(func() int { return executeOn(command, *o) })
+		return out + "stderr:\n" + stderr.String()
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test without hosts",
+			tInput:  []T{"-on", ",", "hello"},
+			tFunc:   on,
+			tOutput: "1\ncannot execute command: -on, usage: sd -on HOST[,HOST...] KEY [ARGS]\nstderr:\n",
+		},
+		{
+			tName:   "Test invalid port",
+			tInput:  []T{"-on", "a", "-port", "x", "hello"},
+			tFunc:   on,
+			tOutput: "1\ncannot execute command: -on, invalid port \"x\"\nstderr:\n",
+		},
+		{
+			tName:   "Test unknown key",
+			tInput:  []T{"-on", "a", "other"},
+			tFunc:   on,
+			tOutput: "1\ncannot execute command: unknown key \"other\"\nstderr:\n",
+		},
+		{
+			tName:   "Test missing argument",
+			tInput:  []T{"-on", "a,b", "hello"},
+			tFunc:   on,
+			tOutput: "1\nCannot parse cmd: echo hello {1} from $HOST; echo warning >&2, not enough arguments: []stderr:\n",
+		},
+		{
+			tName:   "Test single host",
+			tInput:  []T{"-on", "a", "-d", "hello", "world"},
+			tFunc:   on,
+			tOutput: "0\nExecuted CMD: echo hello world from $HOST; echo warning >&2\na | hello world from a\nstderr:\na | warning\n",
+		},
+		{
+			tName:  "Test several hosts",
+			tInput: []T{"-on", "a,bb", "-workers", "1", "hello", "world"},
+			tFunc:  on,
+			tOutput: "0\na  | hello world from a\nbb | hello world from bb\nstderr:\na  | warning\nbb | warning\n" +
+				"HOST  STATUS  EXIT  TIME  DETAILS\n" +
+				"a     ok      0     0s    \n" +
+				"bb    ok      0     0s    \n",
+		},
+		{
+			tName:  "Test failing host",
+			tInput: []T{"-on", "a,b,c", "-workers", "1", "fail"},
+			tFunc:  on,
+			tOutput: "3\na | done\nc | done\nstderr:\n" +
+				"HOST  STATUS  EXIT  TIME  DETAILS\n" +
+				"a     ok      0     0s    \n" +
+				"b     failed  3     0s    \n" +
+				"c     ok      0     0s    \n",
+		},
+		{
+			tName:  "Test failing host with fail fast",
+			tInput: []T{"-on", "a,b,c", "-workers", "1", "-fail-fast", "fail"},
+			tFunc:  on,
+			tOutput: "3\na | done\nstderr:\n" +
+				"HOST  STATUS   EXIT  TIME  DETAILS\n" +
+				"a     ok       0     0s    \n" +
+				"b     failed   3     0s    \n" +
+				"c     skipped  -     0s    \n",
+		},
+		{
+			tName:  "Test timeout",
+			tInput: []T{"-on", "a,b", "-timeout", "100ms", "slow"},
+			tFunc:  on,
+			tOutput: "124\nb | done\nstderr:\n" +
+				"HOST  STATUS   EXIT  TIME  DETAILS\n" +
+				"a     timeout  124   0s    timed out after 100ms\n" +
+				"b     ok       0     0s    \n",
+		},
+		{
+			tName:  "Test fail fast stopping the running hosts",
+			tInput: []T{"-on", "a,b", "-fail-fast", "stuck"},
+			tFunc:  on,
+			tOutput: "3\nstderr:\n" +
+				"HOST  STATUS     EXIT  TIME  DETAILS\n" +
+				"a     cancelled  -     0s    stopped after a failure on another host\n" +
+				"b     failed     3     0s    \n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestPrefixWriter(t *testing.T) {
+	write := func(chunks ...string) string {
+		var out bytes.Buffer
+		w := &prefixWriter{prefix: "h | ", print: func(format string, a ...interface{}) (int, error) {
+			return fmt.Fprintf(&out, format, a...)
+		}}
+		for _, chunk := range chunks {
+			w.Write([]byte(chunk))
+		}
+		w.Flush()
+		return out.String()
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test lines split across writes",
+			tInput:  []T{"one\ntw", "o\nthr", "ee"},
+			tFunc:   write,
+			tOutput: "h | one\nh | two\nh | three\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestReadSecretsFirst(t *testing.T) {
+	run := func(cmd string, env ...string) string {
+		cmd, err := readSecretsFirst(cmd, env)
+		if err != nil {
+			return err.Error()
+		}
+		c := exec.Command("sh", "-c", cmd)
+		c.Stdin = strings.NewReader(secretsInput(env))
+		out, err := c.CombinedOutput()
+		return fmt.Sprint(string(out), err)
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test secrets read from the input",
+			tInput:  []T{`printf '%s|' "$SD_SECRET_1" "$SD_SECRET_2"; cat`, "SD_SECRET_1= a$$ 'b' \\", "SD_SECRET_2=c=d"},
+			tFunc:   run,
+			tOutput: " a$$ 'b' \\|c=d|<nil>",
+		},
+		{
+			tName:   "Test command quoted for the login shell",
+			tInput:  []T{`printf '%s\n' "$SD_SECRET_1" a\\b`, "SD_SECRET_1=x"},
+			tFunc:   run,
+			tOutput: "x\na\\b\n<nil>",
+		},
+		{
+			tName:   "Test command run by sh",
+			tInput:  []T{`printf '%s\n' "$SD_SECRET_1" a\\b`, "SD_SECRET_1=x"},
+			tFunc:   func(cmd string, env ...string) string { cmd, _ = readSecretsFirst(cmd, env); return cmd },
+			tOutput: `sh -c 'IFS= read -r SD_SECRET_1; printf '\''%s'\\'n'\'' "$SD_SECRET_1" a'\\''\\'b'`,
+		},
+		{
+			tName:   "Test command without secrets left as it is",
+			tInput:  []T{`echo a`},
+			tFunc:   func(cmd string, env ...string) string { cmd, _ = readSecretsFirst(cmd, env); return cmd },
+			tOutput: "echo a",
+		},
+		{
+			tName:   "Test secret spanning several lines",
+			tInput:  []T{`echo "$SD_SECRET_1"`, "SD_SECRET_1=a\nb"},
+			tFunc:   run,
+			tOutput: "a secret of the command spans several lines, which cannot be sent to other hosts",
+		},
+	}
+	testPackageMethod(tt, t)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	OUTTABLE = "table"
+	OUTJSON  = "json"
+	OUTYAML  = "yaml"
+	OUTCSV   = "csv"
+	OUTTSV   = "tsv"
+	OUTPLAIN = "plain"
+)
+
+type record struct {
+	Key  string         `json:"key"`
+	Cmd  string         `json:"cmd"`
+	Desc string         `json:"desc,omitempty"`
+	Tags []string       `json:"tags,omitempty"`
+	Args map[int]string `json:"args,omitempty"`
+}
+
+func newRecord(key string, value entry) record {
+	return record{Key: key, Cmd: value.Cmd, Desc: value.Desc, Tags: value.Tags, Args: value.Args}
+}
+
+func sortedArgs(args map[int]string) []int {
+	idxs := make([]int, 0, len(args))
+	for idx := range args {
+		idxs = append(idxs, idx)
+	}
+	sort.Ints(idxs)
+	return idxs
+}
+
+func isValidFormat(format string, formats ...string) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+func toRecords(sdMap map[string]entry) []record {
+	records := make([]record, 0, len(sdMap))
+	for key, value := range sdMap {
+		records = append(records, newRecord(key, value))
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+	return records
+}
+
+func yamlString(val string) string {
+	return strconv.Quote(val)
+}
+
+func yamlList(vals []string) string {
+	quoted := make([]string, len(vals))
+	for i, val := range vals {
+		quoted[i] = yamlString(val)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func escapeTSV(val string) string {
+	return strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r").Replace(val)
+}
+
+func writeCSV(rows [][]string, comma rune) string {
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Comma = comma
+	w.WriteAll(rows)
+	return buf.String()
+}
+
+func formatRecord(r record, format string) string {
+	switch format {
+	case OUTJSON:
+		out, _ := json.MarshalIndent(r, "", "  ")
+		return string(out) + "\n"
+	case OUTYAML:
+		str := "key: " + yamlString(r.Key) + "\ncmd: " + yamlString(r.Cmd) + "\n"
+		if r.Desc != "" {
+			str += "desc: " + yamlString(r.Desc) + "\n"
+		}
+		if len(r.Tags) > 0 {
+			str += "tags: " + yamlList(r.Tags) + "\n"
+		}
+		if len(r.Args) > 0 {
+			str += "args:\n"
+			for _, idx := range sortedArgs(r.Args) {
+				str += "  " + strconv.Itoa(idx) + ": " + yamlString(r.Args[idx]) + "\n"
+			}
+		}
+		return str
+	case OUTPLAIN:
+		return r.Cmd + "\n"
+	}
+	str := "Key:         " + r.Key + "\n"
+	if r.Desc != "" {
+		str += "Description: " + r.Desc + "\n"
+	}
+	if len(r.Tags) > 0 {
+		str += "Tags:        " + strings.Join(r.Tags, ", ") + "\n"
+	}
+	str += "Command:     " + r.Cmd + "\n"
+	for _, idx := range sortedArgs(r.Args) {
+		str += fmt.Sprintf("{%d}:%s%s\n", idx, strings.Repeat(" ", 12-len(strconv.Itoa(idx))-3), r.Args[idx])
+	}
+	return str
+}
+
+func formatRecords(records []record, format string) string {
+	switch format {
+	case OUTJSON:
+		out, _ := json.MarshalIndent(records, "", "  ")
+		return string(out) + "\n"
+	case OUTYAML:
+		if len(records) == 0 {
+			return "[]\n"
+		}
+		str := ""
+		for _, r := range records {
+			str += "- " + strings.Replace(strings.TrimSuffix(formatRecord(r, OUTYAML), "\n"), "\n", "\n  ", -1) + "\n"
+		}
+		return str
+	case OUTCSV:
+		rows := [][]string{{"key", "cmd", "desc", "tags"}}
+		for _, r := range records {
+			rows = append(rows, []string{r.Key, r.Cmd, r.Desc, strings.Join(r.Tags, ",")})
+		}
+		return writeCSV(rows, ',')
+	case OUTTSV:
+		str := "key\tcmd\tdesc\ttags\n"
+		for _, r := range records {
+			str += escapeTSV(r.Key) + "\t" + escapeTSV(r.Cmd) + "\t" + escapeTSV(r.Desc) + "\t" + escapeTSV(strings.Join(r.Tags, ",")) + "\n"
+		}
+		return str
+	}
+	str := ""
+	for _, r := range records {
+		str += r.Key + "\t" + r.Cmd + "\n"
+	}
+	return str
+}
+
+func entityRows(sdMap map[string]entry, entity string) [][]string {
+	rows := [][]string{}
+	seen := map[string]bool{}
+	for k, v := range sdMap {
+		switch entity {
+		case KEYS:
+			rows = append(rows, []string{k})
+		case DESCS:
+			rows = append(rows, []string{k, v.Desc})
+		case TAGS:
+			for _, tag := range v.Tags {
+				if !seen[tag] {
+					seen[tag] = true
+					rows = append(rows, []string{tag})
+				}
+			}
+		default:
+			rows = append(rows, []string{v.Cmd})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return strings.Join(rows[i], "\t") < strings.Join(rows[j], "\t") })
+	return rows
+}
+
+func formatEntity(rows [][]string, format string, nulDelimited bool) string {
+	if nulDelimited {
+		str := ""
+		for _, row := range rows {
+			str += strings.Join(row, "\t") + "\x00"
+		}
+		return str
+	}
+	paired := len(rows) > 0 && len(rows[0]) > 1
+	switch format {
+	case OUTJSON:
+		var out []byte
+		if paired {
+			pairs := map[string]string{}
+			for _, row := range rows {
+				pairs[row[0]] = row[1]
+			}
+			out, _ = json.MarshalIndent(pairs, "", "  ")
+		} else {
+			vals := make([]string, 0, len(rows))
+			for _, row := range rows {
+				vals = append(vals, row[0])
+			}
+			out, _ = json.MarshalIndent(vals, "", "  ")
+		}
+		return string(out) + "\n"
+	case OUTYAML:
+		if len(rows) == 0 {
+			return "[]\n"
+		}
+		str := ""
+		for _, row := range rows {
+			if paired {
+				str += yamlString(row[0]) + ": " + yamlString(row[1]) + "\n"
+			} else {
+				str += "- " + yamlString(row[0]) + "\n"
+			}
+		}
+		return str
+	case OUTCSV:
+		return writeCSV(rows, ',')
+	case OUTTSV:
+		str := ""
+		for _, row := range rows {
+			escaped := make([]string, len(row))
+			for i, col := range row {
+				escaped[i] = escapeTSV(col)
+			}
+			str += strings.Join(escaped, "\t") + "\n"
+		}
+		return str
+	}
+	str := ""
+	for _, row := range rows {
+		str += strings.Join(row, "\t") + "\n"
+	}
+	return str
+}
+
+func show(command *flag.FlagSet, key, format string) int {
+	if key == "" {
+		command.PrintDefaults()
+		return 1
+	}
+	if !isValidFormat(format, OUTTABLE, OUTJSON, OUTYAML, OUTPLAIN) {
+		print("unknown output format \"%s\", expected one of: table, json, yaml, plain\n", format)
+		return 1
+	}
+	if !fileExists() {
+		return 1
+	}
+	val, exists := readFile()[key]
+	if !exists {
+		print("cannot execute command: %s, unknown key %s\n", SHOW, key)
+		return 1
+	}
+	print("%s", formatRecord(newRecord(key, val), format))
+	return 0
+}
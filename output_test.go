@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestFormatRecords(t *testing.T) {
+	records := []record{
+		{Key: "hello", Cmd: "echo \"hello world\"", Desc: "greet", Tags: []string{"demo", "x"}},
+		{Key: "multi", Cmd: "echo a\techo b"},
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test format records as json",
+			tInput:  []T{records[1:], OUTJSON},
+			tFunc:   formatRecords,
+			tOutput: "[\n  {\n    \"key\": \"multi\",\n    \"cmd\": \"echo a\\techo b\"\n  }\n]\n",
+		},
+		{
+			tName:   "Test format records as yaml",
+			tInput:  []T{records, OUTYAML},
+			tFunc:   formatRecords,
+			tOutput: "- key: \"hello\"\n  cmd: \"echo \\\"hello world\\\"\"\n  desc: \"greet\"\n  tags: [\"demo\", \"x\"]\n- key: \"multi\"\n  cmd: \"echo a\\techo b\"\n",
+		},
+		{
+			tName:   "Test format no records as yaml",
+			tInput:  []T{[]record{}, OUTYAML},
+			tFunc:   formatRecords,
+			tOutput: "[]\n",
+		},
+		{
+			tName:   "Test format records as csv",
+			tInput:  []T{records, OUTCSV},
+			tFunc:   formatRecords,
+			tOutput: "key,cmd,desc,tags\nhello,\"echo \"\"hello world\"\"\",greet,\"demo,x\"\nmulti,echo a\techo b,,\n",
+		},
+		{
+			tName:   "Test format records as tsv",
+			tInput:  []T{records, OUTTSV},
+			tFunc:   formatRecords,
+			tOutput: "key\tcmd\tdesc\ttags\nhello\techo \"hello world\"\tgreet\tdemo,x\nmulti\techo a\\techo b\t\t\n",
+		},
+		{
+			tName:   "Test format records as plain",
+			tInput:  []T{records[:1], OUTPLAIN},
+			tFunc:   formatRecords,
+			tOutput: "hello\techo \"hello world\"\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestFormatEntity(t *testing.T) {
+	sdMap := map[string]entry{
+		"b": {Cmd: "echo b", Desc: "second"},
+		"a": {Cmd: "echo a"},
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test format values nul delimited",
+			tInput:  []T{entityRows(sdMap, VALUES), "", true},
+			tFunc:   formatEntity,
+			tOutput: "echo a\x00echo b\x00",
+		},
+		{
+			tName:   "Test format values one per line",
+			tInput:  []T{entityRows(sdMap, VALUES), OUTPLAIN, false},
+			tFunc:   formatEntity,
+			tOutput: "echo a\necho b\n",
+		},
+		{
+			tName:   "Test format keys as json",
+			tInput:  []T{entityRows(sdMap, KEYS), OUTJSON, false},
+			tFunc:   formatEntity,
+			tOutput: "[\n  \"a\",\n  \"b\"\n]\n",
+		},
+		{
+			tName:   "Test format descriptions as json",
+			tInput:  []T{entityRows(sdMap, DESCS), OUTJSON, false},
+			tFunc:   formatEntity,
+			tOutput: "{\n  \"a\": \"\",\n  \"b\": \"second\"\n}\n",
+		},
+		{
+			tName:   "Test format descriptions as yaml",
+			tInput:  []T{entityRows(sdMap, DESCS), OUTYAML, false},
+			tFunc:   formatEntity,
+			tOutput: "\"a\": \"\"\n\"b\": \"second\"\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestShow(t *testing.T) {
+	keyFile = "./test/.dial_keys_tagged"
+	tt := []ttFStruct{
+		{
+			tName: "Test show without key",
+			tInput: []T{
+				flag.NewFlagSet(SHOW, flag.ExitOnError),
+				"",
+				OUTTABLE,
+			},
+			tFunc:   show,
+			tOutput: 1,
+		},
+		{
+			tName: "Test show with unknown format",
+			tInput: []T{
+				flag.NewFlagSet(SHOW, flag.ExitOnError),
+				"pods",
+				"xml",
+			},
+			tFunc:       show,
+			tOutput:     1,
+			tPipeOutput: "unknown output format \"xml\", expected one of: table, json, yaml, plain\n",
+		},
+		{
+			tName: "Test show unknown key",
+			tInput: []T{
+				flag.NewFlagSet(SHOW, flag.ExitOnError),
+				"nope",
+				OUTTABLE,
+			},
+			tFunc:       show,
+			tOutput:     1,
+			tPipeOutput: "cannot execute command: show, unknown key nope\n",
+		},
+		{
+			tName: "Test show key",
+			tInput: []T{
+				flag.NewFlagSet(SHOW, flag.ExitOnError),
+				"logs",
+				OUTTABLE,
+			},
+			tFunc:       show,
+			tOutput:     0,
+			tPipeOutput: "Key:         logs\nDescription: Follow pod logs\nTags:        k8s, debug\nCommand:     kubectl logs -f {1}\n",
+		},
+		{
+			tName: "Test show key as plain",
+			tInput: []T{
+				flag.NewFlagSet(SHOW, flag.ExitOnError),
+				"logs",
+				OUTPLAIN,
+			},
+			tFunc:       show,
+			tOutput:     0,
+			tPipeOutput: "kubectl logs -f {1}\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var (
+	keyCtrlC     = '\x03'
+	keyCtrlD     = '\x04'
+	keyCtrlG     = '\x07'
+	keyBackspace = '\x7f'
+	keyCtrlH     = '\x08'
+	keyTab       = '\t'
+	keyCtrlN     = '\x0e'
+	keyCtrlP     = '\x10'
+	keyCtrlU     = '\x15'
+	keyEscape    = '\x1b'
+)
+
+type candidate struct {
+	key   string
+	value entry
+	score int
+}
+
+// fuzzyScore reports whether all runes of pattern appear in text in order,
+// and scores the match: consecutive runes and runes at the start of a word
+// score higher.
+func fuzzyScore(pattern, text string) (int, bool) {
+	if pattern == "" {
+		return 0, true
+	}
+	p := []rune(strings.ToLower(pattern))
+	t := []rune(strings.ToLower(text))
+	score, pi, prevMatch := 0, 0, -2
+	for ti := 0; ti < len(t) && pi < len(p); ti++ {
+		if t[ti] != p[pi] {
+			continue
+		}
+		score++
+		if ti == prevMatch+1 {
+			score += 5
+		}
+		if ti == 0 || !(unicode.IsLetter(t[ti-1]) || unicode.IsDigit(t[ti-1])) {
+			score += 3
+		}
+		prevMatch = ti
+		pi++
+	}
+	return score, pi == len(p)
+}
+
+func rankCandidates(sdMap map[string]entry, query string) []candidate {
+	var cands []candidate
+	for key, value := range sdMap {
+		best, matched := -1, false
+		for i, field := range []string{key, value.Desc, value.Cmd} {
+			score, ok := fuzzyScore(query, field)
+			if !ok {
+				continue
+			}
+			if i == 0 {
+				score *= 2
+			}
+			if score > best {
+				best, matched = score, true
+			}
+		}
+		if matched {
+			cands = append(cands, candidate{key: key, value: value, score: best})
+		}
+	}
+	sort.Slice(cands, func(i, j int) bool {
+		if cands[i].score != cands[j].score {
+			return cands[i].score > cands[j].score
+		}
+		return cands[i].key < cands[j].key
+	})
+	return cands
+}
+
+func fitWidth(line string, width int) string {
+	line = strings.Replace(line, "\n", " ", -1)
+	if width <= 0 {
+		return line
+	}
+	head, _ := splitWidth(line, width)
+	return head
+}
+
+func renderPicker(query string, cands []candidate, total, selected, width, height int) string {
+	listHeight := height - 4
+	if listHeight < 1 {
+		listHeight = 1
+	}
+	offset := 0
+	if selected >= listHeight {
+		offset = selected - listHeight + 1
+	}
+	maxKey := 0
+	for _, c := range cands {
+		if w := displayWidth(c.key); w > maxKey {
+			maxKey = w
+		}
+	}
+
+	str := "\x1b[H\x1b[2J" + fitWidth("> "+query, width) + "\n"
+	str += fmt.Sprintf("  %d/%d\n", len(cands), total)
+	for i := offset; i < len(cands) && i < offset+listHeight; i++ {
+		c := cands[i]
+		hint := c.value.Desc
+		if hint == "" {
+			hint = c.value.Cmd
+		}
+		line := fitWidth(c.key+strings.Repeat(" ", maxKey-displayWidth(c.key))+"   "+hint, width-2)
+		if i == selected {
+			str += "\x1b[7m> " + line + "\x1b[0m\n"
+		} else {
+			str += "  " + line + "\n"
+		}
+	}
+	if selected < len(cands) {
+		str += "\n" + fitWidth("$ "+previewCmd(cands[selected].value.Cmd), width)
+	}
+	return str
+}
+
+// runPicker reads key presses from in until a key is selected with Enter or
+// the picker is cancelled with Escape, Ctrl-C, Ctrl-D or Ctrl-G. Every key
+// press redraws the picker on out.
+func runPicker(in *bufio.Reader, out io.Writer, sdMap map[string]entry, query string, width, height int) (string, bool) {
+	selected := 0
+	cands := rankCandidates(sdMap, query)
+	for {
+		io.WriteString(out, renderPicker(query, cands, len(sdMap), selected, width, height))
+		r, _, err := in.ReadRune()
+		if err != nil {
+			return "", false
+		}
+		switch r {
+		case '\r', '\n':
+			if selected < len(cands) {
+				return cands[selected].key, true
+			}
+		case keyCtrlC, keyCtrlD, keyCtrlG:
+			return "", false
+		case keyEscape:
+			if in.Buffered() == 0 {
+				return "", false
+			}
+			if next, _, _ := in.ReadRune(); next == '[' || next == 'O' {
+				switch arrow, _, _ := in.ReadRune(); arrow {
+				case 'A':
+					if selected > 0 {
+						selected--
+					}
+				case 'B':
+					if selected < len(cands)-1 {
+						selected++
+					}
+				}
+			}
+		case keyCtrlP:
+			if selected > 0 {
+				selected--
+			}
+		case keyCtrlN, keyTab:
+			if selected < len(cands)-1 {
+				selected++
+			}
+		case keyBackspace, keyCtrlH:
+			if query != "" {
+				runes := []rune(query)
+				query = string(runes[:len(runes)-1])
+				cands, selected = rankCandidates(sdMap, query), 0
+			}
+		case keyCtrlU:
+			query = ""
+			cands, selected = rankCandidates(sdMap, query), 0
+		default:
+			if unicode.IsPrint(r) {
+				query += string(r)
+				cands, selected = rankCandidates(sdMap, query), 0
+			}
+		}
+	}
+}
+
+// promptArgs asks for a value for every placeholder of cmd. An empty answer
+// selects the default value of the placeholder, placeholders without a
+// default are asked for again until a value is given.
+func promptArgs(in *bufio.Reader, out io.Writer, cmd string) ([]string, bool) {
+	var args []string
+	for _, p := range placeholders(cmd) {
+		for len(args) < p.idx-1 {
+			args = append(args, "")
+		}
+		for {
+			if p.hasDef {
+				fmt.Fprintf(out, "{%d} [%s]: ", p.idx, p.def)
+			} else {
+				fmt.Fprintf(out, "{%d}: ", p.idx)
+			}
+			line, err := in.ReadString('\n')
+			if err != nil && line == "" {
+				return nil, false
+			}
+			line = strings.TrimRight(line, "\n")
+			if line == "" && p.hasDef {
+				line = p.def
+			}
+			if line != "" {
+				args = append(args, line)
+				break
+			}
+		}
+	}
+	return args, true
+}
+
+var pick = func(query string, debug, printOnly bool) int {
+	if !fileExists() {
+		return 1
+	}
+	sdMap := readFile()
+	if len(sdMap) == 0 {
+		print("no keys saved yet, use \"sd save\" to save one\n")
+		return 1
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		print("cannot open the terminal: %v\n", err)
+		return 1
+	}
+	defer tty.Close()
+	width, height, _ := terminalSize(tty.Fd())
+	restore, err := makeRaw(tty.Fd())
+	if err != nil {
+		print("cannot open the terminal: %v\n", err)
+		return 1
+	}
+	in := bufio.NewReader(tty)
+	io.WriteString(tty, "\x1b[?1049h")
+	key, ok := runPicker(in, tty, sdMap, query, width, height)
+	io.WriteString(tty, "\x1b[?1049l")
+	restore()
+	if !ok {
+		return 1
+	}
+	args, ok := promptArgs(in, tty, sdMap[key].Cmd)
+	if !ok {
+		return 1
+	}
+	if printOnly {
+		cmd := parseCmd(sdMap[key].Cmd, args)
+		if cmd == "" {
+			return 1
+		}
+		print("%s", cmd)
+		return 0
+	}
+	return execute(key, args, debug)
+}
+
+func picked(query string, debug, printOnly bool) int {
+	if !printOnly && (!isTerminal(os.Stdin.Fd()) || !isTerminal(os.Stdout.Fd())) {
+		print("cannot execute command: %s, a terminal is required\n", PICK)
+		return 1
+	}
+	return pick(query, debug, printOnly)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	matched := func(pattern, text string) bool {
+		_, ok := fuzzyScore(pattern, text)
+		return ok
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test fuzzy match with empty pattern",
+			tInput:  []T{"", "kubectl logs"},
+			tFunc:   matched,
+			tOutput: true,
+		},
+		{
+			tName:   "Test fuzzy match subsequence",
+			tInput:  []T{"kgp", "kubectl get pods"},
+			tFunc:   matched,
+			tOutput: true,
+		},
+		{
+			tName:   "Test fuzzy match ignores case",
+			tInput:  []T{"KGP", "kubectl get pods"},
+			tFunc:   matched,
+			tOutput: true,
+		},
+		{
+			tName:   "Test fuzzy match out of order",
+			tInput:  []T{"pgk", "kubectl get pods"},
+			tFunc:   matched,
+			tOutput: false,
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestRankCandidates(t *testing.T) {
+	sdMap := map[string]entry{
+		"pods":   {Cmd: "kubectl get pods"},
+		"logs":   {Cmd: "kubectl logs -f {1}", Desc: "Follow pod logs"},
+		"docker": {Cmd: "docker ps"},
+	}
+	keys := func(query string) []string {
+		var found []string
+		for _, c := range rankCandidates(sdMap, query) {
+			found = append(found, c.key)
+		}
+		return found
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test rank without query",
+			tInput:  []T{""},
+			tFunc:   keys,
+			tOutput: []string{"docker", "logs", "pods"},
+		},
+		{
+			tName:   "Test rank key matches first",
+			tInput:  []T{"pod"},
+			tFunc:   keys,
+			tOutput: []string{"pods", "logs"},
+		},
+		{
+			tName:   "Test rank on command",
+			tInput:  []T{"kget"},
+			tFunc:   keys,
+			tOutput: []string{"pods"},
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestRunPicker(t *testing.T) {
+	sdMap := map[string]entry{
+		"pods": {Cmd: "kubectl get pods"},
+		"logs": {Cmd: "kubectl logs -f {1}"},
+	}
+	picker := func(input string) string {
+		key, ok := runPicker(bufio.NewReader(strings.NewReader(input)), ioutil.Discard, sdMap, "", 80, 24)
+		if !ok {
+			return "cancelled"
+		}
+		return key
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test pick first candidate",
+			tInput:  []T{"\r"},
+			tFunc:   picker,
+			tOutput: "logs",
+		},
+		{
+			tName:   "Test pick with query",
+			tInput:  []T{"pod\r"},
+			tFunc:   picker,
+			tOutput: "pods",
+		},
+		{
+			tName:   "Test pick with arrow down",
+			tInput:  []T{"\x1b[B\r"},
+			tFunc:   picker,
+			tOutput: "pods",
+		},
+		{
+			tName:   "Test pick with backspace",
+			tInput:  []T{"podx\x7f\x7f\x7f\x7f\r"},
+			tFunc:   picker,
+			tOutput: "logs",
+		},
+		{
+			tName:   "Test pick without match",
+			tInput:  []T{"xyz\r\x03"},
+			tFunc:   picker,
+			tOutput: "cancelled",
+		},
+		{
+			tName:   "Test pick cancelled",
+			tInput:  []T{"\x03"},
+			tFunc:   picker,
+			tOutput: "cancelled",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestPromptArgs(t *testing.T) {
+	prompt := func(input string, cmd string) []string {
+		args, ok := promptArgs(bufio.NewReader(strings.NewReader(input)), ioutil.Discard, cmd)
+		if !ok {
+			return []string{"cancelled"}
+		}
+		return args
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test prompt without placeholders",
+			tInput:  []T{"", "echo hello"},
+			tFunc:   prompt,
+			tOutput: []string{},
+		},
+		{
+			tName:   "Test prompt with default",
+			tInput:  []T{"pod-1\n\n", "kubectl logs {1} -n {2|default}"},
+			tFunc:   prompt,
+			tOutput: []string{"pod-1", "default"},
+		},
+		{
+			tName:   "Test prompt asks again for a required value",
+			tInput:  []T{"\npod-1\nkube-system\n", "kubectl logs {1} -n {2|default}"},
+			tFunc:   prompt,
+			tOutput: []string{"pod-1", "kube-system"},
+		},
+		{
+			tName:   "Test prompt cancelled",
+			tInput:  []T{"", "kubectl logs {1}"},
+			tFunc:   prompt,
+			tOutput: []string{"cancelled"},
+		},
+	}
+	testPackageMethod(tt, t)
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var PULL = "pull"
+
+var remoteKeyFile = ".dial_keys"
+
+// remote is a host reached over SSH, honoring ~/.ssh/config, the agent and
+// known_hosts, or a Docker container or Kubernetes pod reached with docker
+// and kubectl.
+type remote struct {
+	ip        string
+	user      string
+	identity  string
+	sshAlias  string
+	port      string
+	jump      string
+	docker    string
+	pod       string
+	namespace string
+	container string
+	path      string
+	// binary is where to copy the sd executable to, next to the keys.
+	binary string
+	// aliases, containers, pods and the labels are the flags resolved to a
+	// remote each by targets.
+	aliases     stringList
+	containers  stringList
+	pods        stringList
+	dockerLabel string
+	kubeLabel   string
+	// batch disables the password, passphrase and host key prompts, for
+	// parallel transfers.
+	batch bool
+}
+
+type fanOut struct {
+	hostsFile string
+	workers   int
+	retries   int
+	timeout   time.Duration
+}
+
+var retryDelay = time.Second
+var since = time.Since
+
+func remoteFlags(command *flag.FlagSet, u *user.User) *remote {
+	r := &remote{}
+	command.StringVar(&r.ip, "ip", "", "IP or host name of the remote machine. (Required if no SSH alias)")
+	command.StringVar(&r.identity, "id", "", "Specific private key file to use, instead of the identities of the SSH configuration or agent")
+	command.StringVar(&r.user, "user", u.Username, "User to connect with to remote machine")
+	command.Var(&r.aliases, "ssh", "SSH alias of ~/.ssh/config or [user@]host - useful in case of multi-hop transfers")
+	command.StringVar(&r.port, "port", "", "SSH port of the remote machine, instead of 22 or the configured one")
+	command.StringVar(&r.jump, "jump", "", "Jump host(s) to connect through, as [user@]host[:port] separated by commas")
+	command.Var(&r.containers, "docker", "Name or ID of a running Docker container, instead of a remote machine")
+	command.StringVar(&r.dockerLabel, "docker-label", "", "Label of the running Docker containers to use, as key or key=value")
+	command.Var(&r.pods, "kube", "Name of a Kubernetes pod, instead of a remote machine")
+	command.StringVar(&r.kubeLabel, "kube-label", "", "Label selector of the Kubernetes pods to use, e.g. app=web")
+	command.StringVar(&r.namespace, "n", "", "Kubernetes namespace of the pods, instead of the current one")
+	command.StringVar(&r.container, "c", "", "Container of the Kubernetes pods, instead of the default one")
+	command.StringVar(&r.path, "remote-path", remoteKeyFile, "Path of the key file on the remote machine, relative to the home directory of the user")
+	return r
+}
+
+func fanOutFlags(command *flag.FlagSet) *fanOut {
+	f := &fanOut{}
+	command.StringVar(&f.hostsFile, "hosts", "", "File listing the hosts to export to in parallel, one SSH alias or [user@]host per line. -ssh, -docker and -kube can also be repeated")
+	command.IntVar(&f.workers, "workers", 8, "Number of hosts to export to at the same time")
+	command.IntVar(&f.retries, "retries", 1, "Number of times to retry a failed host")
+	command.DurationVar(&f.timeout, "timeout", 30*time.Second, "Time allowed for every attempt on a host, 0 for no limit")
+	return f
+}
+
+func readLines(content string) []string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// targets resolves the -ip, -ssh, -hosts, -docker and -kube flags and the
+// labels to a remote per host, which all share the other options.
+func (r remote) targets(hostsFile string) ([]remote, error) {
+	var targets []remote
+	if r.ip != "" || r.sshAlias != "" || r.docker != "" || r.pod != "" {
+		targets = append(targets, r)
+	}
+	hosts := append([]string{}, r.aliases...)
+	if hostsFile != "" {
+		content, err := ioutil.ReadFile(hostsFile)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, readLines(string(content))...)
+	}
+	containers := append([]string{}, r.containers...)
+	if r.dockerLabel != "" {
+		out, err := runCommand(context.Background(), "docker", "ps", "--filter", "label="+r.dockerLabel, "--format", "{{.Names}}")
+		if err != nil {
+			return nil, fmt.Errorf("cannot list the containers labeled %s: %v", r.dockerLabel, err)
+		}
+		containers = append(containers, readLines(out)...)
+	}
+	pods := append([]string{}, r.pods...)
+	if r.kubeLabel != "" {
+		out, err := runCommand(context.Background(), "kubectl", r.kubeArgs("get", "pods", "-l", r.kubeLabel, "-o", "name")...)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list the pods labeled %s: %v", r.kubeLabel, err)
+		}
+		for _, pod := range readLines(out) {
+			pods = append(pods, strings.TrimPrefix(pod, "pod/"))
+		}
+	}
+	if r.ip != "" && len(hosts)+len(containers)+len(pods) > 0 {
+		return nil, fmt.Errorf("-ip cannot be combined with -ssh or -hosts")
+	}
+	base := r
+	base.aliases, base.containers, base.pods, base.dockerLabel, base.kubeLabel = nil, nil, nil, "", ""
+	for _, host := range hosts {
+		target := base
+		target.sshAlias = host
+		targets = append(targets, target)
+	}
+	for _, container := range containers {
+		target := base
+		target.docker = container
+		targets = append(targets, target)
+	}
+	for _, pod := range pods {
+		target := base
+		target.pod = pod
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 {
+		if r.dockerLabel != "" || r.kubeLabel != "" {
+			return nil, fmt.Errorf("no running containers or pods match the label")
+		}
+		return nil, fmt.Errorf("either -ip, -ssh, -hosts, -docker or -kube is required")
+	}
+	for _, target := range targets {
+		if err := target.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+func (r remote) host() string {
+	switch {
+	case r.docker != "":
+		return "docker:" + r.docker
+	case r.pod != "" && r.namespace != "":
+		return "kube:" + r.namespace + "/" + r.pod
+	case r.pod != "":
+		return "kube:" + r.pod
+	case r.sshAlias != "":
+		return r.sshAlias
+	case r.user != "":
+		return r.user + "@" + r.ip
+	}
+	return r.ip
+}
+
+func (r remote) keyFile() string {
+	path := r.path
+	if path == "" {
+		path = remoteKeyFile
+	}
+	return r.host() + ":" + path
+}
+
+// validate reports why the remote cannot be connected to, if so.
+func (r remote) validate() error {
+	if r.docker != "" || r.pod != "" {
+		if r.ip != "" || r.sshAlias != "" || (r.docker != "" && r.pod != "") {
+			return fmt.Errorf("-docker and -kube cannot be combined with -ip or -ssh")
+		}
+		return nil
+	}
+	if (r.ip == "" && r.sshAlias == "") || (r.ip != "" && r.sshAlias != "") {
+		return fmt.Errorf("either -ip or -ssh is required")
+	}
+	if r.port != "" {
+		if port, err := strconv.Atoi(r.port); err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port \"%s\"", r.port)
+		}
+	}
+	if r.identity != "" {
+		if _, err := os.Stat(r.identity); err != nil {
+			return fmt.Errorf("cannot read the identity file: %v", err)
+		}
+	}
+	return nil
+}
+
+func (r remote) kubeArgs(args ...string) []string {
+	if r.namespace != "" {
+		args = append([]string{"-n", r.namespace}, args...)
+	}
+	return args
+}
+
+// copyCommand returns the command copying from to to in a container, the
+// path in the container being prefixed by a colon.
+func (r remote) copyCommand(from, to string) (string, []string) {
+	locate := func(path string) string {
+		if !strings.HasPrefix(path, ":") {
+			return path
+		}
+		if r.docker != "" {
+			return r.docker + path
+		}
+		return r.pod + path
+	}
+	from, to = locate(from), locate(to)
+	if r.docker != "" {
+		return "docker", []string{"cp", from, to}
+	}
+	args := r.kubeArgs("cp")
+	if r.container != "" {
+		args = append(args, "-c", r.container)
+	}
+	return "kubectl", append(args, from, to)
+}
+
+// remotePath makes path absolute for containers, whose copy commands do not
+// know about the home directory.
+func (r remote) remotePath(ctx context.Context, path string) (string, error) {
+	if path == "" {
+		path = remoteKeyFile
+	}
+	if (r.docker == "" && r.pod == "") || strings.HasPrefix(path, "/") {
+		return path, nil
+	}
+	name, args := "docker", []string{"exec", r.docker}
+	if r.pod != "" {
+		name, args = "kubectl", r.kubeArgs("exec", r.pod)
+		if r.container != "" {
+			args = append(args, "-c", r.container)
+		}
+		args = append(args, "--")
+	}
+	out, err := runCommand(ctx, name, append(args, "sh", "-c", "echo $HOME")...)
+	home := strings.TrimSpace(out)
+	if err != nil || home == "" {
+		return "", fmt.Errorf("cannot find the home directory of %s: %v", r.host(), err)
+	}
+	return strings.TrimSuffix(home, "/") + "/" + path, nil
+}
+
+func (r remote) copy(ctx context.Context, from, to string) error {
+	if r.docker == "" && r.pod == "" {
+		return sshCopy(ctx, r, from, to)
+	}
+	name, args := r.copyCommand(from, to)
+	_, err := runCommand(ctx, name, args...)
+	return err
+}
+
+// fetchKeys copies the remote key file at path to local and reads it. A
+// remote without a key file, or which cannot be reached, gives no keys and
+// the error of the copy, telling whether the keys were fetched.
+func fetchKeys(ctx context.Context, r remote, path, local string) (map[string]entry, bool, error) {
+	if err := r.copy(ctx, ":"+path, local); err != nil {
+		return map[string]entry{}, false, err
+	}
+	sdMap, err := readKeyFile(local)
+	return sdMap, true, err
+}
+
+// isMissingFile reports whether the error of a copy over SFTP or by docker
+// or kubectl says the file to copy does not exist, rather than the host
+// being unreachable or the copy being refused.
+func isMissingFile(err error) bool {
+	if errors.Is(err, os.ErrNotExist) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "no such file") || strings.Contains(msg, "could not find the file")
+}
+
+var runCommand = func(ctx context.Context, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, &stdout, &stderr
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if msg := strings.TrimSpace(stderr.String()); err != nil && msg != "" {
+		return "", fmt.Errorf("%v: %s", err, msg)
+	}
+	return stdout.String(), err
+}
+
+// pushKeys merges ours into the key file of the remote host, a missing
+// remote key file is created. Any other failure to fetch the remote keys
+// aborts the push, which would otherwise replace them by ours. It returns
+// the remote keys before and after.
+func pushKeys(ctx context.Context, r remote, ours map[string]entry, strategy string) (map[string]entry, map[string]entry, []string, error) {
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, ".dial_keys")
+	path, err := r.remotePath(ctx, r.path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	theirs, fetched, err := fetchKeys(ctx, r, path, local)
+	switch {
+	case fetched && err != nil:
+		return nil, nil, nil, fmt.Errorf("the key file of %s is not valid: %v", r.host(), err)
+	case !fetched && !isMissingFile(err):
+		return nil, nil, nil, fmt.Errorf("cannot fetch %s: %v", r.keyFile(), err)
+	}
+	merged, conflicts, err := mergeKeys(ours, theirs, strategy)
+	if err == nil {
+		err = writeKeyFile(local, merged)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := r.copy(ctx, local, ":"+path); err != nil {
+		return nil, nil, nil, fmt.Errorf("cannot copy the key file to %s: %v", r.keyFile(), err)
+	}
+	if r.binary != "" {
+		if err := pushBinary(ctx, r); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return theirs, merged, conflicts, nil
+}
+
+var executable = os.Executable
+
+func pushBinary(ctx context.Context, r remote) error {
+	binary, err := executable()
+	if err == nil {
+		var path string
+		if path, err = r.remotePath(ctx, r.binary); err == nil {
+			err = r.copy(ctx, binary, ":"+path)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot copy sd to %s:%s: %v", r.host(), r.binary, err)
+	}
+	return nil
+}
+
+// transferFile merges the local keys into the key file of the remote host.
+var transferFile = func(r remote, strategy string) int {
+	if !isValidStrategy(strategy) || !fileExists() {
+		return 1
+	}
+	theirs, merged, conflicts, err := pushKeys(context.Background(), r, readFile(), strategy)
+	if err != nil {
+		print("cannot execute command: %s, %v\n", EXPORT, err)
+		return 1
+	}
+	printMergeSummary("exported", r.keyFile(), theirs, merged, conflicts)
+	return 0
+}
+
+type hostResult struct {
+	host     string
+	attempts int
+	elapsed  time.Duration
+	added    int
+	updated  int
+	err      error
+}
+
+func pushWithRetries(r remote, ours map[string]entry, strategy string, f fanOut) hostResult {
+	result := hostResult{host: r.keyFile()}
+	start := time.Now()
+	for result.attempts < f.retries+1 {
+		if result.attempts > 0 {
+			time.Sleep(time.Duration(result.attempts) * retryDelay)
+		}
+		result.attempts++
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if f.timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		}
+		theirs, merged, _, err := pushKeys(ctx, r, ours, strategy)
+		cancel()
+		if result.err = err; err == nil {
+			added, _, changed := diffKeys(theirs, merged)
+			result.added, result.updated = len(added), len(changed)
+			break
+		}
+	}
+	result.elapsed = since(start)
+	return result
+}
+
+// pushAll pushes to the targets with a pool of workers, keeping the order
+// of the targets in the results.
+func pushAll(targets []remote, ours map[string]entry, strategy string, f fanOut) []hostResult {
+	results := make([]hostResult, len(targets))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < f.workers && w < len(targets); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = pushWithRetries(targets[i], ours, strategy, f)
+			}
+		}()
+	}
+	for i := range targets {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+func formatHostResults(results []hostResult) string {
+	var out bytes.Buffer
+	w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSTATUS\tATTEMPTS\tTIME\tDETAILS")
+	for _, r := range results {
+		status, details := "ok", fmt.Sprintf("%d added, %d updated", r.added, r.updated)
+		if r.err != nil {
+			status, details = "failed", r.err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", r.host, status, r.attempts, r.elapsed.Round(100*time.Millisecond), details)
+	}
+	w.Flush()
+	return out.String()
+}
+
+func exportToHosts(targets []remote, strategy string, f fanOut) int {
+	if !isValidStrategy(strategy) || !fileExists() {
+		return 1
+	}
+	if strategy == MERGEINTERACTIVE {
+		print("cannot execute command: %s, the interactive merge cannot be used with several hosts\n", EXPORT)
+		return 1
+	}
+	if f.workers < 1 || f.retries < 0 {
+		print("cannot execute command: %s, -workers must be at least 1 and -retries at least 0\n", EXPORT)
+		return 1
+	}
+	for i := range targets {
+		targets[i].batch = true
+	}
+	results := pushAll(targets, readFile(), strategy, f)
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+		}
+	}
+	print("%s", formatHostResults(results))
+	print("exported to %d of %d host(s)\n", len(results)-failed, len(results))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func pull(command *flag.FlagSet, r remote, strategy string, dryRun bool, secretPolicy string) int {
+	targets, err := r.targets("")
+	if err == nil && len(targets) > 1 {
+		err = fmt.Errorf("keys can only be pulled from one host at a time")
+	}
+	if err != nil {
+		print("cannot execute command: %s, %v\n", PULL, err)
+		command.PrintDefaults()
+		return 1
+	}
+	r = targets[0]
+	if !isValidStrategy(strategy) || !isValidSecretPolicy(secretPolicy) {
+		return 1
+	}
+	dir, err := ioutil.TempDir("", "sd")
+	if err != nil {
+		print("cannot execute command: %s, %v\n", PULL, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	path, err := r.remotePath(context.Background(), r.path)
+	if err != nil {
+		print("cannot execute command: %s, %v\n", PULL, err)
+		return 1
+	}
+	theirs, fetched, err := fetchKeys(context.Background(), r, path, filepath.Join(dir, ".dial_keys"))
+	if !fetched {
+		print("cannot execute command: %s, cannot fetch %s: %v\n", PULL, r.keyFile(), err)
+		return 1
+	}
+	if err != nil {
+		print("cannot execute command: %s, the key file of %s is not valid: %v\n", PULL, r.host(), err)
+		return 1
+	}
+	return mergeLocal(PULL, theirs, strategy, dryRun, secretPolicy, "pulled", "would pull", "Pull the keys of "+r.host())
+}
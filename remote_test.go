@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRemote(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName:   "Test remote by ip",
+			tInput:  []T{remote{ip: "10.0.0.1", user: "me"}},
+			tFunc:   remote.keyFile,
+			tOutput: "me@10.0.0.1:.dial_keys",
+		},
+		{
+			tName:   "Test remote by alias with path",
+			tInput:  []T{remote{sshAlias: "box", user: "me", path: "sd/keys.json"}},
+			tFunc:   remote.keyFile,
+			tOutput: "box:sd/keys.json",
+		},
+		{
+			tName:   "Test remote without host",
+			tInput:  []T{remote{user: "me"}},
+			tFunc:   remote.validate,
+			tOutput: "either -ip or -ssh is required",
+		},
+		{
+			tName:   "Test remote with ip and alias",
+			tInput:  []T{remote{ip: "10.0.0.1", sshAlias: "box"}},
+			tFunc:   remote.validate,
+			tOutput: "either -ip or -ssh is required",
+		},
+		{
+			tName:   "Test remote with invalid port",
+			tInput:  []T{remote{ip: "10.0.0.1", port: "70000"}},
+			tFunc:   remote.validate,
+			tOutput: "invalid port \"70000\"",
+		},
+		{
+			tName:   "Test remote with missing identity",
+			tInput:  []T{remote{ip: "10.0.0.1", identity: "./test/missing_id"}},
+			tFunc:   remote.validate,
+			tOutput: "cannot read the identity file: stat ./test/missing_id: no such file or directory",
+		},
+		{
+			tName:   "Test valid remote",
+			tInput:  []T{remote{ip: "10.0.0.1", port: "22", identity: "./test/ssh_config"}},
+			tFunc:   remote.validate,
+			tOutput: "<nil>",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestTransferFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	keyFile = filepath.Join(dir, ".dial_keys")
+	remoteFile := filepath.Join(dir, "remote")
+	ioutil.WriteFile(keyFile, []byte(`{"a":"echo a","b":"echo b"}`), 0644)
+	ioutil.WriteFile(remoteFile, []byte(`{"b":"echo B","r":"echo r"}`), 0644)
+	defer func(copy func(context.Context, remote, string, string) error) { sshCopy = copy }(sshCopy)
+	var calls []string
+	sshCopy = func(ctx context.Context, r remote, from, to string) error {
+		calls = append(calls, r.host()+" "+r.port+" "+from+" "+to)
+		switch {
+		case r.host() == "denied":
+			return fmt.Errorf("cannot connect to denied: ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain")
+		case r.host() == "empty" && strings.HasPrefix(from, ":"):
+			return fmt.Errorf(".dial_keys: %w", os.ErrNotExist)
+		case r.host() == "empty":
+			return fmt.Errorf(".dial_keys: %w", os.ErrPermission)
+		case r.host() != "me@host":
+			return fmt.Errorf("cannot connect to %s: dial tcp: lookup %s: no such host", r.host(), r.host())
+		}
+		from = strings.Replace(from, ":keys.json", remoteFile, 1)
+		to = strings.Replace(to, ":keys.json", remoteFile, 1)
+		content, err := ioutil.ReadFile(from)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(to, content, 0644)
+	}
+	transfer := func(r remote, strategy string) string {
+		calls = nil
+		out := capturePrint(func() int { return transferFile(r, strategy) })
+		content, _ := ioutil.ReadFile(remoteFile)
+		return fmt.Sprintf("%s%s %d", out, content, len(calls))
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test transfer keeping remote only keys",
+			tInput:  []T{remote{ip: "host", user: "me", port: "2222", path: "keys.json"}, MERGEOURS},
+			tFunc:   transfer,
+			tOutput: "0\nadded a: echo a\nupdated b: echo b\nexported 3 key(s), 1 added and 1 updated in me@host:keys.json\n" + `{"a":"echo a","b":{"cmd":"echo b","history":[{"cmd":"echo B"}]},"r":"echo r"} 2`,
+		},
+	}
+	testPackageMethod(tt, t)
+	tt = []ttFStruct{
+		{
+			tName:  "Test transfer copies from and to the remote path",
+			tInput: []T{},
+			tFunc: func() string {
+				return strings.Join(strings.Fields(calls[0])[:3], " ") + " " + strings.Fields(calls[1])[3]
+			},
+			tOutput: "me@host 2222 :keys.json :keys.json",
+		},
+		{
+			tName:  "Test transfer to a remote without key file",
+			tInput: []T{remote{sshAlias: "empty"}, MERGEOURS},
+			tFunc: func(r remote, strategy string) string {
+				return capturePrint(func() int { return transferFile(r, strategy) })
+			},
+			tOutput: "1\ncannot execute command: export, cannot copy the key file to empty:.dial_keys: .dial_keys: permission denied\n",
+		},
+		{
+			tName:   "Test transfer aborted when the remote keys cannot be fetched",
+			tInput:  []T{remote{sshAlias: "denied"}, MERGEOURS},
+			tFunc:   transfer,
+			tOutput: "1\ncannot execute command: export, cannot fetch denied:.dial_keys: cannot connect to denied: ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain\n" + `{"a":"echo a","b":{"cmd":"echo b","history":[{"cmd":"echo B"}]},"r":"echo r"} 1`,
+		},
+		{
+			tName:       "Test transfer to unreachable remote",
+			tInput:      []T{remote{sshAlias: "other"}, MERGEOURS},
+			tFunc:       transferFile,
+			tOutput:     1,
+			tPipeOutput: "cannot execute command: export, cannot fetch other:.dial_keys: cannot connect to other: dial tcp: lookup other: no such host\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestPull(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	keyFile = filepath.Join(dir, ".dial_keys")
+	remoteFile := filepath.Join(dir, "remote")
+	ioutil.WriteFile(keyFile, []byte(`{"a":"echo a","b":"echo b"}`), 0644)
+	ioutil.WriteFile(remoteFile, []byte(`{"b":"echo B","r":"echo r"}`), 0644)
+	defer func(write func(map[string]entry)) { writeFile = write }(writeFile)
+	writeFile = func(sdMap map[string]entry) { writeKeyFile(keyFile, sdMap) }
+	defer func(copy func(context.Context, remote, string, string) error) { sshCopy = copy }(sshCopy)
+	sshCopy = func(ctx context.Context, r remote, from, to string) error {
+		if r.host() != "box" || from != ":.dial_keys" {
+			return fmt.Errorf("cannot connect to %s: dial tcp: lookup %s: no such host", r.host(), r.host())
+		}
+		content, _ := ioutil.ReadFile(remoteFile)
+		return ioutil.WriteFile(to, content, 0644)
+	}
+	pulled := func(sshAlias, strategy string) string {
+		out := capturePrint(func() int {
+			return pull(flag.NewFlagSet(PULL, flag.ExitOnError), remote{sshAlias: sshAlias}, strategy, false, SECRETSREFUSE)
+		})
+		content, _ := ioutil.ReadFile(keyFile)
+		return out + string(content)
+	}
+	tt := []ttFStruct{
+		{
+			tName:       "Test pull without remote",
+			tInput:      []T{flag.NewFlagSet(PULL, flag.ContinueOnError), remote{}, MERGEOURS, false, SECRETSREFUSE},
+			tFunc:       pull,
+			tOutput:     1,
+			tPipeOutput: "cannot execute command: pull, either -ip, -ssh, -hosts, -docker or -kube is required\n",
+		},
+		{
+			tName:       "Test pull from unreachable remote",
+			tInput:      []T{flag.NewFlagSet(PULL, flag.ExitOnError), remote{sshAlias: "other"}, MERGEOURS, false, SECRETSREFUSE},
+			tFunc:       pull,
+			tOutput:     1,
+			tPipeOutput: "cannot execute command: pull, cannot fetch other:.dial_keys: cannot connect to other: dial tcp: lookup other: no such host\n",
+		},
+		{
+			tName:   "Test pull keeping ours",
+			tInput:  []T{"box", MERGEOURS},
+			tFunc:   pulled,
+			tOutput: "0\nadded r: echo r\nkept the conflicting keys already in " + keyFile + ": b\npulled 3 key(s), 1 added and 0 updated in " + keyFile + "\n" + `{"a":"echo a","b":{"cmd":"echo b","history":[{"cmd":"echo B"}]},"r":"echo r"}`,
+		},
+		{
+			tName:   "Test pull taking theirs",
+			tInput:  []T{"box", MERGETHEIRS},
+			tFunc:   pulled,
+			tOutput: "0\nupdated b: echo B\npulled 3 key(s), 0 added and 1 updated in " + keyFile + "\n" + `{"a":"echo a","b":{"cmd":"echo B","history":[{"cmd":"echo b"}]},"r":"echo r"}`,
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestTargets(t *testing.T) {
+	targets := func(r remote, hostsFile string) string {
+		targets, err := r.targets(hostsFile)
+		var hosts []string
+		for _, target := range targets {
+			hosts = append(hosts, target.keyFile())
+		}
+		return fmt.Sprintf("%v %v", hosts, err)
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test targets of repeated ssh aliases and hosts file",
+			tInput:  []T{remote{aliases: stringList{"db"}, port: "2222"}, "./test/hosts"},
+			tFunc:   targets,
+			tOutput: "[db:.dial_keys web1:.dial_keys deploy@web2:.dial_keys] <nil>",
+		},
+		{
+			tName:   "Test targets of ip combined with hosts file",
+			tInput:  []T{remote{ip: "10.0.0.1"}, "./test/hosts"},
+			tFunc:   targets,
+			tOutput: "[] -ip cannot be combined with -ssh or -hosts",
+		},
+		{
+			tName:   "Test targets of missing hosts file",
+			tInput:  []T{remote{}, "./test/missing_hosts"},
+			tFunc:   targets,
+			tOutput: "[] open ./test/missing_hosts: no such file or directory",
+		},
+		{
+			tName:   "Test targets with invalid port",
+			tInput:  []T{remote{aliases: stringList{"db"}, port: "0"}, ""},
+			tFunc:   targets,
+			tOutput: "[] invalid port \"0\"",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestExportToHosts(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	keyFile = filepath.Join(dir, ".dial_keys")
+	ioutil.WriteFile(keyFile, []byte(`{"a":"echo a","b":"echo b"}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "web1"), []byte(`{"b":"echo B"}`), 0644)
+	defer func(delay time.Duration, elapsed func(time.Time) time.Duration) { retryDelay, since = delay, elapsed }(retryDelay, since)
+	retryDelay = 0
+	since = func(time.Time) time.Duration { return 1500 * time.Millisecond }
+	defer func(copy func(context.Context, remote, string, string) error) { sshCopy = copy }(sshCopy)
+	var mu sync.Mutex
+	pushes := map[string]int{}
+	sshCopy = func(ctx context.Context, r remote, from, to string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !r.batch {
+			return fmt.Errorf("not in batch mode")
+		}
+		host := r.host()
+		if host == "down" {
+			return fmt.Errorf("cannot connect to down: dial tcp 10.0.0.9:22: connect: connection refused")
+		}
+		if host == "flaky" && to == ":.dial_keys" {
+			if pushes["flaky"]++; pushes["flaky"] == 1 {
+				return context.DeadlineExceeded
+			}
+		}
+		if from == ":.dial_keys" {
+			from = filepath.Join(dir, host)
+		}
+		if to == ":.dial_keys" {
+			to = filepath.Join(dir, host)
+		}
+		content, err := ioutil.ReadFile(from)
+		if err != nil {
+			return fmt.Errorf("keys.json: %w", os.ErrNotExist)
+		}
+		return ioutil.WriteFile(to, content, 0644)
+	}
+	export := func(hosts []string, strategy string) string {
+		var targets []remote
+		for _, host := range hosts {
+			targets = append(targets, remote{sshAlias: host})
+		}
+		return capturePrint(func() int {
+			return exportToHosts(targets, strategy, fanOut{workers: 2, retries: 1})
+		})
+	}
+	tt := []ttFStruct{
+		{
+			tName:  "Test export to hosts with failures and retries",
+			tInput: []T{[]string{"web1", "down", "flaky"}, MERGEOURS},
+			tFunc:  export,
+			tOutput: "1\n" +
+				"HOST              STATUS  ATTEMPTS  TIME  DETAILS\n" +
+				"web1:.dial_keys   ok      1         1.5s  1 added, 1 updated\n" +
+				"down:.dial_keys   failed  2         1.5s  cannot fetch down:.dial_keys: cannot connect to down: dial tcp 10.0.0.9:22: connect: connection refused\n" +
+				"flaky:.dial_keys  ok      2         1.5s  2 added, 0 updated\n" +
+				"exported to 2 of 3 host(s)\n",
+		},
+		{
+			tName:   "Test export to hosts with interactive merge",
+			tInput:  []T{[]string{"web1"}, MERGEINTERACTIVE},
+			tFunc:   export,
+			tOutput: "1\ncannot execute command: export, the interactive merge cannot be used with several hosts\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestExportToContainers(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	stubs, _ := filepath.Abs("./test/stubs")
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", stubs+string(os.PathListSeparator)+os.Getenv("PATH"))
+	defer os.Setenv("STUB_ROOT", os.Getenv("STUB_ROOT"))
+	os.Setenv("STUB_ROOT", dir)
+	keyFile = filepath.Join(dir, ".dial_keys")
+	ioutil.WriteFile(keyFile, []byte(`{"a":"echo a"}`), 0644)
+	os.MkdirAll(filepath.Join(dir, "web1", "root"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "web1", "root", ".dial_keys"), []byte(`{"w":"echo w"}`), 0644)
+	defer func(elapsed func(time.Time) time.Duration) { since = elapsed }(since)
+	since = func(time.Time) time.Duration { return 0 }
+	defer func(exe func() (string, error)) { executable = exe }(executable)
+	executable = func() (string, error) { return keyFile, nil }
+	defer func(write func(map[string]entry)) { writeFile = write }(writeFile)
+	writeFile = func(sdMap map[string]entry) { writeKeyFile(keyFile, sdMap) }
+	read := func(path ...string) string {
+		content, err := ioutil.ReadFile(filepath.Join(append([]string{dir}, path...)...))
+		if err != nil {
+			return err.Error()
+		}
+		return string(content)
+	}
+	log := func() string {
+		defer os.Remove(filepath.Join(dir, "log"))
+		return read("log")
+	}
+	export := func(r remote) string {
+		targets, err := r.targets("")
+		if err != nil {
+			return err.Error()
+		}
+		return capturePrint(func() int { return exportToHosts(targets, MERGEOURS, fanOut{workers: 1}) })
+	}
+	tt := []ttFStruct{
+		{
+			tName:  "Test export to pods by label",
+			tInput: []T{remote{kubeLabel: "app=api", namespace: "prod", container: "app"}},
+			tFunc:  export,
+			tOutput: "0\n" +
+				"HOST                        STATUS  ATTEMPTS  TIME  DETAILS\n" +
+				"kube:prod/api-1:.dial_keys  ok      1         0s    1 added, 0 updated\n" +
+				"kube:prod/api-2:.dial_keys  ok      1         0s    1 added, 0 updated\n" +
+				"exported to 2 of 2 host(s)\n",
+		},
+		{
+			tName:   "Test keys copied into the pods",
+			tInput:  []T{"prod", "api-2", "home", "app", ".dial_keys"},
+			tFunc:   func(path ...string) string { return read(path...) },
+			tOutput: `{"a":"echo a"}`,
+		},
+		{
+			tName:  "Test kubectl invocations",
+			tInput: []T{},
+			tFunc: func() string {
+				calls := strings.Split(log(), "\n")
+				return strings.Join(calls[:2], "\n") + "\n" + strings.Join(strings.Fields(calls[2])[:7], " ")
+			},
+			tOutput: "kubectl -n prod get pods -l app=api -o name\n" +
+				"kubectl -n prod exec api-1 -c app -- sh -c echo $HOME\n" +
+				"kubectl -n prod cp -c app api-1:/home/app/.dial_keys",
+		},
+	}
+	testPackageMethod(tt, t)
+
+	tt = []ttFStruct{
+		{
+			tName:  "Test export to a container with the binary",
+			tInput: []T{remote{containers: stringList{"web1"}, binary: "bin/sd"}},
+			tFunc:  export,
+			tOutput: "0\n" +
+				"HOST                    STATUS  ATTEMPTS  TIME  DETAILS\n" +
+				"docker:web1:.dial_keys  ok      1         0s    1 added, 0 updated\n" +
+				"exported to 1 of 1 host(s)\n",
+		},
+		{
+			tName:   "Test keys merged into the container",
+			tInput:  []T{"web1", "root", ".dial_keys"},
+			tFunc:   func(path ...string) string { return read(path...) },
+			tOutput: `{"a":"echo a","w":"echo w"}`,
+		},
+		{
+			tName:   "Test binary copied into the container",
+			tInput:  []T{"web1", "root", "bin", "sd"},
+			tFunc:   func(path ...string) string { return read(path...) },
+			tOutput: `{"a":"echo a"}`,
+		},
+		{
+			tName:   "Test export to a missing container",
+			tInput:  []T{remote{docker: "gone"}},
+			tFunc:   export,
+			tOutput: "1\nHOST                    STATUS  ATTEMPTS  TIME  DETAILS\ndocker:gone:.dial_keys  failed  1         0s    cannot find the home directory of docker:gone: exit status 1: Error: No such container: gone\nexported to 0 of 1 host(s)\n",
+		},
+		{
+			tName:  "Test pull from a container",
+			tInput: []T{},
+			tFunc: func() string {
+				return capturePrint(func() int {
+					return pull(flag.NewFlagSet(PULL, flag.ExitOnError), remote{containers: stringList{"web1"}}, MERGEOURS, false, SECRETSREFUSE)
+				})
+			},
+			tOutput: "0\nadded w: echo w\npulled 2 key(s), 1 added and 0 updated in " + keyFile + "\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
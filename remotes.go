@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var REMOTE = "remote"
+var (
+	REMOTEADD    = "add"
+	REMOTEREMOVE = "remove"
+	REMOTELIST   = "list"
+)
+
+var remotesFile = getHomeDir() + string(os.PathSeparator) + ".dial_remotes"
+
+// httpRemote is a named sd serve instance the keys can be synced with. Base
+// holds the keys as of the last sync, the common ancestor of the next merge
+// which tells deleted keys from keys added on the other side.
+type httpRemote struct {
+	URL   string           `json:"url"`
+	Token string           `json:"token,omitempty"`
+	Base  map[string]entry `json:"base,omitempty"`
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+var errKeysChanged = errors.New("the keys were changed in the meantime")
+
+func readRemotes() (map[string]httpRemote, error) {
+	remotes := map[string]httpRemote{}
+	content, err := ioutil.ReadFile(remotesFile)
+	if os.IsNotExist(err) {
+		return remotes, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &remotes)
+	}
+	return remotes, err
+}
+
+// writeRemotes keeps the file private to the user, it holds the tokens.
+func writeRemotes(remotes map[string]httpRemote) error {
+	content, err := json.MarshalIndent(remotes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(remotesFile, content, 0600)
+}
+
+// parseInterspersed parses the flags of command wherever they appear among
+// args, returning the positional arguments.
+func parseInterspersed(command *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		command.Parse(args)
+		args = command.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func remotes(command *flag.FlagSet, args []string, token string) int {
+	usage := func(format string, a ...interface{}) int {
+		print("cannot execute command: %s, %s\n", REMOTE, fmt.Sprintf(format, a...))
+		command.PrintDefaults()
+		return 1
+	}
+	if len(args) == 0 {
+		args = []string{REMOTELIST}
+	}
+	all, err := readRemotes()
+	if err != nil {
+		print("cannot execute command: %s, %v\n", REMOTE, err)
+		return 1
+	}
+	switch args[0] {
+	case REMOTELIST:
+		names := make([]string, 0, len(all))
+		for name := range all {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			print("%s\t%s\n", name, all[name].URL)
+		}
+		return 0
+	case REMOTEADD:
+		if len(args) != 3 {
+			return usage("usage: %s %s NAME URL", REMOTE, REMOTEADD)
+		}
+		u, err := url.Parse(args[2])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return usage("invalid URL \"%s\", expected http(s)://HOST[:PORT]", args[2])
+		}
+		all[args[1]] = httpRemote{URL: strings.TrimSuffix(args[2], "/"), Token: token}
+	case REMOTEREMOVE:
+		if len(args) != 2 {
+			return usage("usage: %s %s NAME", REMOTE, REMOTEREMOVE)
+		}
+		if _, ok := all[args[1]]; !ok {
+			return usage("unknown remote \"%s\"", args[1])
+		}
+		delete(all, args[1])
+	default:
+		return usage("unknown action \"%s\", expected %s, %s or %s", args[0], REMOTEADD, REMOTEREMOVE, REMOTELIST)
+	}
+	if err := writeRemotes(all); err != nil {
+		print("cannot execute command: %s, %v\n", REMOTE, err)
+		return 1
+	}
+	return 0
+}
+
+func (r httpRemote) request(method string, body interface{}, headers map[string]string) (*http.Response, error) {
+	var content io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		content = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, r.URL+"/keys", content)
+	if err != nil {
+		return nil, err
+	}
+	token := r.Token
+	if token == "" {
+		token = os.Getenv("SD_TOKEN")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		resp.Body.Close()
+		return nil, errKeysChanged
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		if failure.Error == "" {
+			failure.Error = resp.Status
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, r.URL, failure.Error)
+	}
+	return resp, nil
+}
+
+func (r httpRemote) fetch() (map[string]entry, string, error) {
+	resp, err := r.request(http.MethodGet, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	sdMap := map[string]entry{}
+	if err := json.NewDecoder(resp.Body).Decode(&sdMap); err != nil {
+		return nil, "", err
+	}
+	return sdMap, resp.Header.Get("ETag"), nil
+}
+
+// put replaces the keys of the remote, failing with errKeysChanged when they
+// no longer match the given ETag.
+func (r httpRemote) put(sdMap map[string]entry, tag string) error {
+	resp, err := r.request(http.MethodPut, sdMap, map[string]string{"If-Match": tag})
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+var syncAttempts = 3
+
+// syncRemote merges the changes of the keys of a named remote and of the
+// local ones since the last sync, and uploads the result, starting over when
+// someone else changed the remote keys in between.
+func syncRemote(name, strategy, secretPolicy string) int {
+	if !isValidStrategy(strategy) || !isValidSecretPolicy(secretPolicy) {
+		return 1
+	}
+	all, err := readRemotes()
+	if err != nil {
+		print("cannot execute command: %s, %v\n", SYNC, err)
+		return 1
+	}
+	r, ok := all[name]
+	if !ok {
+		print("cannot execute command: %s, unknown remote \"%s\", add it with sd %s %s\n", SYNC, name, REMOTE, REMOTEADD)
+		return 1
+	}
+	ours := map[string]entry{}
+	if fileExists() {
+		ours = readFile()
+	}
+	for attempt := 0; attempt < syncAttempts; attempt++ {
+		theirs, tag, err := r.fetch()
+		if err != nil {
+			print("cannot execute command: %s, %v\n", SYNC, err)
+			return 1
+		}
+		merged, conflicts, err := mergeThreeWay(r.Base, ours, theirs, strategy)
+		if err != nil {
+			print("cannot execute command: %s, %v\n", SYNC, err)
+			return 1
+		}
+		if !guardIncoming(SYNC, ours, merged, secretPolicy) {
+			return 1
+		}
+		err = r.put(merged, tag)
+		if err == errKeysChanged {
+			continue
+		}
+		if err != nil {
+			print("cannot execute command: %s, %v\n", SYNC, err)
+			return 1
+		}
+		writeFile(merged)
+		commitKeys("Sync the keys with " + name)
+		// Moving secrets scrubbed the bases of the remotes in the meantime.
+		if all, err = readRemotes(); err == nil {
+			r.Base = merged
+			all[name] = r
+			err = writeRemotes(all)
+		}
+		if err != nil {
+			print("cannot execute command: %s, %v\n", SYNC, err)
+			return 1
+		}
+		printMergeSummary("synced", keyFile, ours, merged, conflicts)
+		return 0
+	}
+	print("cannot execute command: %s, the keys of %s kept changing, try again\n", SYNC, name)
+	return 1
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRemotes(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	defer func(file string) { remotesFile = file }(remotesFile)
+	remotesFile = filepath.Join(dir, ".dial_remotes")
+	run := func(token string, args ...string) string {
+		return capturePrint(func() int { return remotes(flag.NewFlagSet(REMOTE, flag.ContinueOnError), args, token) })
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test list without remotes",
+			tInput:  []T{""},
+			tFunc:   run,
+			tOutput: "0\n",
+		},
+		{
+			tName:   "Test add",
+			tInput:  []T{"secret", "add", "team", "http://sd.example.com:8080/"},
+			tFunc:   run,
+			tOutput: "0\n",
+		},
+		{
+			tName:   "Test add with an invalid URL",
+			tInput:  []T{"", "add", "other", "sd.example.com"},
+			tFunc:   run,
+			tOutput: "1\ncannot execute command: remote, invalid URL \"sd.example.com\", expected http(s)://HOST[:PORT]\n",
+		},
+		{
+			tName:   "Test add without URL",
+			tInput:  []T{"", "add", "other"},
+			tFunc:   run,
+			tOutput: "1\ncannot execute command: remote, usage: remote add NAME URL\n",
+		},
+		{
+			tName:   "Test list",
+			tInput:  []T{"", "list"},
+			tFunc:   run,
+			tOutput: "0\nteam\thttp://sd.example.com:8080\n",
+		},
+		{
+			tName:  "Test remotes file is private",
+			tInput: []T{},
+			tFunc: func() string {
+				info, _ := os.Stat(remotesFile)
+				content, _ := ioutil.ReadFile(remotesFile)
+				return info.Mode().String() + " " + string(content)
+			},
+			tOutput: "-rw------- {\n  \"team\": {\n    \"url\": \"http://sd.example.com:8080\",\n    \"token\": \"secret\"\n  }\n}",
+		},
+		{
+			tName:   "Test remove of an unknown remote",
+			tInput:  []T{"", "remove", "other"},
+			tFunc:   run,
+			tOutput: "1\ncannot execute command: remote, unknown remote \"other\"\n",
+		},
+		{
+			tName:   "Test remove",
+			tInput:  []T{"", "remove", "team"},
+			tFunc:   run,
+			tOutput: "0\n",
+		},
+		{
+			tName:   "Test unknown action",
+			tInput:  []T{"", "rename"},
+			tFunc:   run,
+			tOutput: "1\ncannot execute command: remote, unknown action \"rename\", expected add, remove or list\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestSyncRemote(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	defer func(file, sync, remotes string) { keyFile, syncDir, remotesFile = file, sync, remotes }(keyFile, syncDir, remotesFile)
+	remotesFile = filepath.Join(dir, ".dial_remotes")
+	syncDir = filepath.Join(dir, ".dial_keys.sync")
+	defer func(write func(map[string]entry)) { writeFile = write }(writeFile)
+	writeFile = func(sdMap map[string]entry) { writeKeyFile(keyFile, sdMap) }
+	serverFile := filepath.Join(dir, "server")
+	localFile := filepath.Join(dir, "local")
+	ioutil.WriteFile(serverFile, []byte(`{"a":"echo A","s":"echo s"}`), 0644)
+	ioutil.WriteFile(localFile, []byte(`{"a":"echo a","l":"echo l"}`), 0644)
+	// The server and the client share the process, keyFile points to the key
+	// file of whichever side is running.
+	changes := 0
+	keys := &keyServer{token: "secret", secrets: SECRETSREFUSE}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyFile = serverFile
+		defer func() { keyFile = localFile }()
+		if r.Method == http.MethodPut && changes > 0 {
+			// Someone else changes the keys between our fetch and our put.
+			changes--
+			sdMap, _ := readKeyFile(serverFile)
+			sdMap["c"] = entry{Cmd: "echo c" + strings.Repeat("!", changes)}
+			writeKeyFile(serverFile, sdMap)
+		}
+		keys.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	writeRemotes(map[string]httpRemote{"team": {URL: server.URL, Token: "secret"}, "wrong": {URL: server.URL, Token: "guess"}})
+	keyFile = localFile
+	synced := func(name, strategy string, concurrentChanges int) string {
+		changes = concurrentChanges
+		return capturePrint(func() int { return syncRemote(name, strategy, SECRETSREFUSE) })
+	}
+	read := func(file string) string {
+		content, _ := ioutil.ReadFile(file)
+		return string(content)
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test sync with an unknown remote",
+			tInput:  []T{"other", MERGEOURS, 0},
+			tFunc:   synced,
+			tOutput: "1\ncannot execute command: sync, unknown remote \"other\", add it with sd remote add\n",
+		},
+		{
+			tName:   "Test sync with a wrong token",
+			tInput:  []T{"wrong", MERGEOURS, 0},
+			tFunc:   synced,
+			tOutput: "1\ncannot execute command: sync, GET " + server.URL + ": missing or invalid token\n",
+		},
+		{
+			tName:   "Test sync retrying after a concurrent change",
+			tInput:  []T{"team", MERGEOURS, 1},
+			tFunc:   synced,
+			tOutput: "0\nadded c: echo c\nadded s: echo s\nkept the conflicting keys already in " + localFile + ": a\nsynced 4 key(s), 2 added and 0 updated in " + localFile + "\n",
+		},
+		{
+			tName:   "Test local keys after sync",
+			tInput:  []T{localFile},
+			tFunc:   read,
+			tOutput: `{"a":{"cmd":"echo a","history":[{"cmd":"echo A"}]},"c":"echo c","l":"echo l","s":"echo s"}`,
+		},
+		{
+			tName:   "Test server keys after sync",
+			tInput:  []T{serverFile},
+			tFunc:   read,
+			tOutput: `{"a":{"cmd":"echo a","history":[{"cmd":"echo A"}]},"c":"echo c","l":"echo l","s":"echo s"}`,
+		},
+		{
+			tName:  "Test sync after deleting a key locally",
+			tInput: []T{"team", MERGEOURS, 0},
+			tFunc: func(name, strategy string, concurrentChanges int) string {
+				sdMap, _ := readKeyFile(localFile)
+				delete(sdMap, "l")
+				writeKeyFile(localFile, sdMap)
+				return synced(name, strategy, concurrentChanges) + read(serverFile)
+			},
+			tOutput: "0\nsynced 3 key(s), 0 added and 0 updated in " + localFile + "\n" + `{"a":{"cmd":"echo a","history":[{"cmd":"echo A"}]},"c":"echo c","s":"echo s"}`,
+		},
+		{
+			tName:  "Test sync after deleting a key on the server",
+			tInput: []T{"team", MERGEOURS, 0},
+			tFunc: func(name, strategy string, concurrentChanges int) string {
+				req, _ := http.NewRequest(http.MethodDelete, server.URL+"/keys/s", nil)
+				req.Header.Set("Authorization", "Bearer secret")
+				if resp, err := http.DefaultClient.Do(req); err == nil {
+					resp.Body.Close()
+				}
+				return synced(name, strategy, concurrentChanges) + read(localFile)
+			},
+			tOutput: "0\nremoved s\nsynced 2 key(s), 0 added and 0 updated in " + localFile + "\n" + `{"a":{"cmd":"echo a","history":[{"cmd":"echo A"}]},"c":"echo c"}`,
+		},
+		{
+			tName:   "Test sync giving up on keys changing all the time",
+			tInput:  []T{"team", MERGEOURS, syncAttempts},
+			tFunc:   synced,
+			tOutput: "1\ncannot execute command: sync, the keys of team kept changing, try again\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestParseInterspersed(t *testing.T) {
+	parse := func(args ...string) string {
+		command := flag.NewFlagSet(REMOTE, flag.ContinueOnError)
+		token := command.String("token", "", "")
+		positional := parseInterspersed(command, args)
+		return strings.Join(positional, " ") + " token=" + *token
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test flags before the positional arguments",
+			tInput:  []T{"-token", "t", "add", "team", "http://host"},
+			tFunc:   parse,
+			tOutput: "add team http://host token=t",
+		},
+		{
+			tName:   "Test flags among the positional arguments",
+			tInput:  []T{"add", "-token", "t", "team", "http://host"},
+			tFunc:   parse,
+			tOutput: "add team http://host token=t",
+		},
+	}
+	testPackageMethod(tt, t)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	FORMATSCRIPT = "script"
+	FORMATMAKE   = "make"
+	FORMATJUST   = "just"
+)
+
+var (
+	rMakeTarget = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_./-]*$`)
+	rJustRecipe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+)
+
+func sortedKeys(sdMap map[string]entry) []string {
+	keys := make([]string, 0, len(sdMap))
+	for key := range sdMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func maxPlaceholder(cmd string) (int, int) {
+	maxIdx, maxRequired := 0, 0
+	for _, p := range placeholders(cmd) {
+		if p.idx > maxIdx {
+			maxIdx = p.idx
+		}
+		if !p.hasDef && p.idx > maxRequired {
+			maxRequired = p.idx
+		}
+	}
+	return maxIdx, maxRequired
+}
+
+// singleLine joins the lines of a description, so it fits a comment or a
+// line of the usage.
+func singleLine(desc string) string {
+	return strings.Join(strings.Fields(strings.NewReplacer("\r\n", "\n").Replace(desc)), " ")
+}
+
+// skipSecrets reports in a comment that key is not exported when cmd uses
+// secrets, which only sd can resolve.
+func skipSecrets(key, cmd, indent string) (string, bool) {
+	if !rSecret.MatchString(cmd) {
+		return "", false
+	}
+	return fmt.Sprintf("%s# skipped key %s: uses secrets, which only sd can resolve\n", indent, key), true
+}
+
+func escapeDoubleQuoted(val string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(val)
+}
+
+// scriptCase renders the case branch of the key dispatcher. The command is
+// built as a string with the arguments inserted as is and evaluated, which
+// gives arguments the same meaning as the textual replacement of parseCmd.
+func scriptCase(key, cmd string) string {
+	maxIdx, maxRequired := maxPlaceholder(cmd)
+	body := rAll.ReplaceAllStringFunc(escapeDoubleQuoted(cmd), func(matchedVal string) string {
+		p := parsePlaceholder(matchedVal)
+		if p.hasDef {
+			return fmt.Sprintf("${%d-%s}", p.idx, p.def)
+		}
+		return fmt.Sprintf("${%d}", p.idx)
+	})
+	str := "  " + quoteSh(key) + ")\n"
+	if maxRequired > 0 {
+		str += fmt.Sprintf("    [ $# -ge %d ] || sd_missing %s\n", maxRequired, quoteSh(cmd))
+	}
+	str += "    sd_cmd=\"" + body + "\"\n"
+	str += fmt.Sprintf("    if [ $# -gt %d ]; then shift %d; sd_cmd=\"$sd_cmd $*\"; fi\n", maxIdx, maxIdx)
+	return str + "    ;;\n"
+}
+
+func renderScript(sdMap map[string]entry) string {
+	str := "#!/bin/sh\n" +
+		"# Generated by \"sd export -format script\". Usage: " + "$0 KEY [ARGS...]\n\n" +
+		"sd_usage() {\n" +
+		"  echo \"usage: $0 KEY [ARGS...]\"\n" +
+		"  echo\n" +
+		"  echo \"keys:\"\n"
+	for _, key := range sortedKeys(sdMap) {
+		if rSecret.MatchString(sdMap[key].Cmd) {
+			continue
+		}
+		line := key
+		if desc := sdMap[key].Desc; desc != "" {
+			line += "\t" + singleLine(desc)
+		}
+		str += "  printf '  %s\\n' " + quoteSh(line) + "\n"
+	}
+	str += "}\n\n" +
+		"sd_missing() {\n" +
+		"  echo \"Cannot parse cmd: $1, not enough arguments\" >&2\n" +
+		"  exit 1\n" +
+		"}\n\n" +
+		"if [ $# -eq 0 ]; then\n" +
+		"  sd_usage >&2\n" +
+		"  exit 1\n" +
+		"fi\n\n" +
+		"sd_key=$1\n" +
+		"shift\n\n" +
+		"case $sd_key in\n" +
+		"  -h|--help|help)\n" +
+		"    sd_usage\n" +
+		"    exit 0\n" +
+		"    ;;\n"
+	for _, key := range sortedKeys(sdMap) {
+		if skipped, ok := skipSecrets(key, sdMap[key].Cmd, "  "); ok {
+			str += skipped
+			continue
+		}
+		str += scriptCase(key, sdMap[key].Cmd)
+	}
+	return str + "  *)\n" +
+		"    echo \"unknown key \\\"$sd_key\\\"\" >&2\n" +
+		"    exit 1\n" +
+		"    ;;\n" +
+		"esac\n\n" +
+		"eval \"$sd_cmd\"\n"
+}
+
+// renderMakefile renders every key as a target. Make has no positional
+// arguments, placeholder {N} is read from the variable ARGN instead and
+// further arguments from ARGS, e.g. make logs ARG1=pod ARGS=-f.
+func renderMakefile(sdMap map[string]entry) string {
+	var targets []string
+	recipes := ""
+	for _, key := range sortedKeys(sdMap) {
+		cmd := sdMap[key].Cmd
+		if !rMakeTarget.MatchString(key) || strings.Contains(cmd, "\n") {
+			recipes += fmt.Sprintf("# skipped key %s: not a valid target or a multi-line command\n\n", key)
+			continue
+		}
+		if skipped, ok := skipSecrets(key, cmd, ""); ok {
+			recipes += skipped + "\n"
+			continue
+		}
+		targets = append(targets, key)
+		if desc := sdMap[key].Desc; desc != "" {
+			recipes += "# " + singleLine(desc) + "\n"
+		}
+		recipes += key + ":\n"
+		required := map[int]bool{}
+		for _, p := range placeholders(cmd) {
+			if !p.hasDef && !required[p.idx] {
+				required[p.idx] = true
+				recipes += fmt.Sprintf("\t$(if $(ARG%d),,$(error %s requires ARG%d))\n", p.idx, key, p.idx)
+			}
+		}
+		body := rAll.ReplaceAllStringFunc(strings.Replace(cmd, "$", "$$", -1), func(matchedVal string) string {
+			p := parsePlaceholder(matchedVal)
+			if p.hasDef {
+				return fmt.Sprintf("$(or $(ARG%d),%s)", p.idx, p.def)
+			}
+			return fmt.Sprintf("$(ARG%d)", p.idx)
+		})
+		recipes += "\t" + body + " $(ARGS)\n\n"
+	}
+	return "# Generated by \"sd export -format make\". Placeholder {N} is set with ARGN=value,\n" +
+		"# further arguments with ARGS=\"...\", e.g. make KEY ARG1=value.\n\n" +
+		"SHELL := bash\n\n" +
+		".PHONY: " + strings.Join(targets, " ") + "\n\n" +
+		strings.TrimSuffix(recipes, "\n")
+}
+
+func justString(val string) string {
+	return "\"" + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(val) + "\""
+}
+
+func renderJustfile(sdMap map[string]entry) string {
+	recipes := ""
+	for _, key := range sortedKeys(sdMap) {
+		cmd := sdMap[key].Cmd
+		if !rJustRecipe.MatchString(key) || strings.Contains(cmd, "\n") {
+			recipes += fmt.Sprintf("# skipped key %s: not a valid recipe name or a multi-line command\n\n", key)
+			continue
+		}
+		if skipped, ok := skipSecrets(key, cmd, ""); ok {
+			recipes += skipped + "\n"
+			continue
+		}
+		maxIdx, _ := maxPlaceholder(cmd)
+		defaults := map[int]string{}
+		for _, p := range placeholders(cmd) {
+			if p.hasDef {
+				defaults[p.idx] = p.def
+			}
+		}
+		params := []string{key}
+		for idx := 1; idx <= maxIdx; idx++ {
+			param := "arg" + strconv.Itoa(idx)
+			if def, ok := defaults[idx]; ok {
+				param += "=" + justString(def)
+			}
+			params = append(params, param)
+		}
+		params = append(params, "*args")
+		body := rAll.ReplaceAllStringFunc(strings.Replace(cmd, "{{", "{{{{", -1), func(matchedVal string) string {
+			return fmt.Sprintf("{{arg%d}}", parsePlaceholder(matchedVal).idx)
+		})
+		if desc := sdMap[key].Desc; desc != "" {
+			recipes += "# " + singleLine(desc) + "\n"
+		}
+		recipes += strings.Join(params, " ") + ":\n    " + body + " {{args}}\n\n"
+	}
+	return "# Generated by \"sd export -format just\".\n\n" +
+		"set shell := [\"bash\", \"-c\"]\n\n" +
+		strings.TrimSuffix(recipes, "\n")
+}
+
+var exportFormats = map[string]func(map[string]entry) string{
+	FORMATSCRIPT: renderScript,
+	FORMATMAKE:   renderMakefile,
+	FORMATJUST:   renderJustfile,
+}
+
+func exportToFormat(format string) int {
+	render, ok := exportFormats[format]
+	if !ok {
+		print("cannot execute command: %s, unknown format \"%s\", expected one of: script, make, just\n", EXPORT, format)
+		return 1
+	}
+	if !fileExists() {
+		return 1
+	}
+	print("%s", render(readFile()))
+	return 0
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestScriptCase(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName:   "Test script case without placeholders",
+			tInput:  []T{"say", "echo \"it's\" $HOME"},
+			tFunc:   scriptCase,
+			tOutput: "  'say')\n    sd_cmd=\"echo \\\"it's\\\" \\$HOME\"\n    if [ $# -gt 0 ]; then shift 0; sd_cmd=\"$sd_cmd $*\"; fi\n    ;;\n",
+		},
+		{
+			tName:   "Test script case with placeholders",
+			tInput:  []T{"logs", "kubectl logs {1} -n {2|default}"},
+			tFunc:   scriptCase,
+			tOutput: "  'logs')\n    [ $# -ge 1 ] || sd_missing 'kubectl logs {1} -n {2|default}'\n    sd_cmd=\"kubectl logs ${1} -n ${2-default}\"\n    if [ $# -gt 2 ]; then shift 2; sd_cmd=\"$sd_cmd $*\"; fi\n    ;;\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestRenderScript(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	dir, err := ioutil.TempDir("", "sd-script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "sd.sh")
+	sdMap := map[string]entry{
+		"greet": {Cmd: "echo hi {1} {2|there}", Desc: "Say hi"},
+		"say":   {Cmd: "echo \"it's\""},
+		"login": {Cmd: "docker login -p {secret:REGISTRY}", Desc: "Log in\nto the registry"},
+		"pull":  {Cmd: "docker pull {1}", Desc: "Pull\nan image"},
+	}
+	if err := ioutil.WriteFile(script, []byte(renderScript(sdMap)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	run := func(args ...string) string {
+		out, _ := exec.Command("sh", append([]string{script}, args...)...).CombinedOutput()
+		return string(out)
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test script with default",
+			tInput:  []T{"greet", "bob"},
+			tFunc:   run,
+			tOutput: "hi bob there\n",
+		},
+		{
+			tName:   "Test script with extra arguments",
+			tInput:  []T{"greet", "bob", "you", "all"},
+			tFunc:   run,
+			tOutput: "hi bob you all\n",
+		},
+		{
+			tName:   "Test script with missing argument",
+			tInput:  []T{"greet"},
+			tFunc:   run,
+			tOutput: "Cannot parse cmd: echo hi {1} {2|there}, not enough arguments\n",
+		},
+		{
+			tName:   "Test script without placeholders",
+			tInput:  []T{"say", "twice"},
+			tFunc:   run,
+			tOutput: "it's twice\n",
+		},
+		{
+			tName:   "Test script with unknown key",
+			tInput:  []T{"nope"},
+			tFunc:   run,
+			tOutput: "unknown key \"nope\"\n",
+		},
+		{
+			tName:   "Test script skips keys with secrets",
+			tInput:  []T{"login"},
+			tFunc:   run,
+			tOutput: "unknown key \"login\"\n",
+		},
+		{
+			tName:   "Test script help",
+			tInput:  []T{"-h"},
+			tFunc:   run,
+			tOutput: "usage: " + script + " KEY [ARGS...]\n\nkeys:\n  greet\tSay hi\n  pull\tPull an image\n  say\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestRenderMakefile(t *testing.T) {
+	sdMap := map[string]entry{
+		"greet":    {Cmd: "echo hi {1} {2|there} $$"},
+		"k8s/pods": {Cmd: "kubectl get pods", Desc: "List pods\nof the namespace"},
+		"a b":      {Cmd: "ls"},
+		"login":    {Cmd: "docker login -p {secret:REGISTRY}"},
+	}
+	tt := []ttFStruct{
+		{
+			tName:  "Test render makefile",
+			tInput: []T{sdMap},
+			tFunc:  renderMakefile,
+			tOutput: "# Generated by \"sd export -format make\". Placeholder {N} is set with ARGN=value,\n" +
+				"# further arguments with ARGS=\"...\", e.g. make KEY ARG1=value.\n\n" +
+				"SHELL := bash\n\n" +
+				".PHONY: greet k8s/pods\n\n" +
+				"# skipped key a b: not a valid target or a multi-line command\n\n" +
+				"greet:\n" +
+				"\t$(if $(ARG1),,$(error greet requires ARG1))\n" +
+				"\techo hi $(ARG1) $(or $(ARG2),there) $$$$ $(ARGS)\n\n" +
+				"# List pods of the namespace\n" +
+				"k8s/pods:\n" +
+				"\tkubectl get pods $(ARGS)\n\n" +
+				"# skipped key login: uses secrets, which only sd can resolve\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestRenderJustfile(t *testing.T) {
+	sdMap := map[string]entry{
+		"greet":    {Cmd: "echo hi {2|\"there\"} {{x}}", Desc: "Say hi\r\nto someone"},
+		"k8s/pods": {Cmd: "kubectl get pods"},
+		"login":    {Cmd: "docker login -p {secret:REGISTRY}"},
+	}
+	tt := []ttFStruct{
+		{
+			tName:  "Test render justfile",
+			tInput: []T{sdMap},
+			tFunc:  renderJustfile,
+			tOutput: "# Generated by \"sd export -format just\".\n\n" +
+				"set shell := [\"bash\", \"-c\"]\n\n" +
+				"# Say hi to someone\n" +
+				"greet arg1 arg2=\"\\\"there\\\"\" *args:\n" +
+				"    echo hi {{arg2}} {{{{x}} {{args}}\n\n" +
+				"# skipped key k8s/pods: not a valid recipe name or a multi-line command\n\n" +
+				"# skipped key login: uses secrets, which only sd can resolve\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestExportToFormat(t *testing.T) {
+	keyFile = "./test/.dial_keys_valid"
+	tt := []ttFStruct{
+		{
+			tName:       "Test export with unknown format",
+			tInput:      []T{"yaml"},
+			tFunc:       exportToFormat,
+			tOutput:     1,
+			tPipeOutput: "cannot execute command: export, unknown format \"yaml\", expected one of: script, make, just\n",
+		},
+		{
+			tName:   "Test export as makefile",
+			tInput:  []T{FORMATMAKE},
+			tFunc:   exportToFormat,
+			tOutput: 0,
+			tPipeOutput: "# Generated by \"sd export -format make\". Placeholder {N} is set with ARGN=value,\n" +
+				"# further arguments with ARGS=\"...\", e.g. make KEY ARG1=value.\n\n" +
+				"SHELL := bash\n\n" +
+				".PHONY: hello something\n\n" +
+				"hello:\n\techo world $(ARGS)\n\n" +
+				"something:\n\tnew $(ARGS)\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
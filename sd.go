@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -26,37 +27,117 @@ var rReg, _ = regexp.Compile("{[0-9]+}")
 var aReg, _ = regexp.Compile("{([0-9])+")
 
 var keyFile = getHomeDir() + string(os.PathSeparator) + ".dial_keys"
-var aliasFile = getHomeDir() + string(os.PathSeparator) + ".bash_aliases"
-
-var (
-	keyTableTitle        = "Key"
-	valueTableTitle      = "Value"
-	overflowIndicator    = "..."
-	maxKey               = len(keyTableTitle)
-	maxVal               = len(valueTableTitle)
-	overflowIndicatorLen = len(overflowIndicator)
-)
-
 var (
 	GET         = "get"
 	KEYS        = "keys"
 	VALUES      = "values"
+	DESCS       = "descs"
+	TAGS        = "tags"
 	SAVE        = "save"
 	DELETE      = "delete"
 	EXPORT      = "export"
 	LIST        = "list"
+	SEARCH      = "search"
+	SHOW        = "show"
+	PICK        = "pick"
+	INIT        = "init"
+	EXPAND      = "expand"
+	COMPLETION  = "completion"
+	IMPORT      = "import"
+	COMPLETE    = "__complete"
 	HELP        = "help"
 	HELPSHORT   = "-h"
 	HELPSHORTER = "--help"
 )
 
 var helpText = map[string]string{
-	SAVE:   "save\tSave/update a command as a speed dial key",
-	DELETE: "delete\tDelete a saved speed dial key",
-	GET:    "get\tGet speed dial entities (keys, values) as a whitespace separated list. Useful for the creation of helper functions (bash completion for ex).",
-	EXPORT: "export\tExport your .dial_key file to another remote location",
-	LIST:   "list\tList all dial keys",
-	HELP:   "help\tPrint this help",
+	SAVE:       "save\tSave/update a command as a speed dial key",
+	DELETE:     "delete\tDelete a saved speed dial key",
+	GET:        "get\tGet speed dial entities (keys, values) as a whitespace separated list. Useful for the creation of helper functions (bash completion for ex).",
+	EXPORT:     "export\tExport your .dial_key file to another remote location or a file, merging it with the keys already there",
+	LIST:       "list\tList all dial keys",
+	SEARCH:     "search\tSearch keys, descriptions, tags and commands for a term",
+	SHOW:       "show\tShow a single speed dial key in full",
+	PICK:       "pick\tInteractively pick a key to execute, fuzzy matching keys, descriptions and commands. Also started by sd without arguments on a terminal",
+	INIT:       "init\tPrint shell code (bash, zsh or fish) binding Ctrl-G to pick a key and insert its command into the command line",
+	EXPAND:     "expand\tPrint the command of a key expanded with the given arguments, without executing it",
+	COMPLETION: "completion\tPrint the completion script for bash, zsh or fish",
+	IMPORT:     "import\tImport aliases and simple functions from shell rc files, or the keys of an exported file",
+	PULL:       "pull\tFetch the .dial_keys file of a remote location and merge it into your keys",
+	SYNC:       "sync\tSync your keys with a git repository, committing every save and delete, or with a remote added by sd remote",
+	SERVE:      "serve\tServe your keys over a REST API other machines can sync with",
+	REMOTE:     "remote\tAdd, remove or list the sd serve instances to sync with",
+	DIFF:       "diff\tShow the keys added, removed or changed in an exported file compared to your keys",
+	SECRET:     "secret\tSet, delete or list the encrypted secrets commands refer to as {secret:NAME}",
+	AUDIT:      "audit\tReport the keys whose commands contain likely credentials (tokens, passwords, private keys)",
+	UNDO:       "undo\tUndo the last change of your keys, going further back every time",
+	TRASH:      "trash\tList the deleted keys, restore one or empty the trash",
+	SUGGEST:    "suggest\tSuggest keys for the commands you type over and over again, found in the history of your shell",
+	EDIT:       "edit\tEdit a key, or all the keys without a key, in $EDITOR without having to quote the command",
+	LOG:        "log\tList the previous values of a key with when and by whom they were saved, or the changes between two of them",
+	REVERT:     "revert\tMake a previous value of a key, as numbered by log, its current value again",
+	BACKUP:     "backup\tSave a snapshot of your keys to a file",
+	RESTORE:    "restore\tReplace your keys with a snapshot saved by backup",
+	ON:         "-on\tExecute a key on other hosts over SSH: sd -on HOST[,HOST...] KEY [ARGS]",
+	HELP:       "help\tPrint this help",
+}
+
+type entry struct {
+	Cmd  string         `json:"cmd"`
+	Desc string         `json:"desc,omitempty"`
+	Tags []string       `json:"tags,omitempty"`
+	Args map[int]string `json:"args,omitempty"`
+	// Updated is the RFC 3339 time the key was last saved, used to merge stores.
+	Updated string `json:"updated,omitempty"`
+	// By is the user@host that last saved the key.
+	By string `json:"by,omitempty"`
+	// History holds the previous values of the key, oldest first.
+	History []revision `json:"history,omitempty"`
+}
+
+type entryFields entry
+
+// Keys without any metadata are stored as a plain command string, the only
+// format older versions of sd read: they skip the keys stored as objects and
+// drop them when they save. When and by whom a key was saved is metadata as
+// well, which merges keeping the newest key compare.
+func (e entry) MarshalJSON() ([]byte, error) {
+	if !e.hasMetadata() {
+		return json.Marshal(e.Cmd)
+	}
+	return json.Marshal(entryFields(e))
+}
+
+func (e *entry) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*e = entry{}
+		return json.Unmarshal(data, &e.Cmd)
+	}
+	return json.Unmarshal(data, (*entryFields)(e))
+}
+
+func (e entry) hasMetadata() bool {
+	return e.Desc != "" || len(e.Tags) > 0 || len(e.Args) > 0 || e.Updated != "" || e.By != "" || len(e.History) > 0
+}
+
+func (e entry) hasTag(tag string) bool {
+	for _, t := range e.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(val string) error {
+	*s = append(*s, val)
+	return nil
 }
 
 func getHomeDir() string {
@@ -77,59 +158,57 @@ func fileExists() bool {
 	return true
 }
 
-func readFile() map[string]string {
-	f, err := ioutil.ReadFile(keyFile)
+func readKeyFile(file string) (map[string]entry, error) {
+	f, err := ioutil.ReadFile(file)
 	if err != nil {
-		_error(err.Error())
+		return nil, err
 	}
-	speedDialStruct := map[string]string{}
+	speedDialStruct := map[string]entry{}
 	if err := json.Unmarshal(f, &speedDialStruct); err != nil {
-		_error(err.Error())
+		return nil, fmt.Errorf("%s: %v", file, err)
 	}
-	return speedDialStruct
+	return speedDialStruct, nil
 }
 
-var writeFile = func(speedDialStruct map[string]string) {
+func writeKeyFile(file string, speedDialStruct map[string]entry) error {
 	speedDialJSON, err := json.Marshal(speedDialStruct)
 	if err != nil {
-		_error(err.Error())
+		return err
 	}
-	if err := ioutil.WriteFile(keyFile, speedDialJSON, 0644); err != nil {
-		_error(err.Error())
+	// Only the user may read the keys, existing files keep their mode on write.
+	if err := ioutil.WriteFile(file, speedDialJSON, 0600); err != nil {
+		return err
 	}
+	return os.Chmod(file, 0600)
 }
 
-var transferFile = func(ip string, privateSSHKeyFile string, user string, sshAlias string) int {
-	cmd := ""
-	if sshAlias != "" {
-		cmd = fmt.Sprintf("scp %s %s", keyFile, sshAlias)
-	} else {
-		cmd = fmt.Sprintf("scp -i %s %s %s:%s", privateSSHKeyFile, keyFile, user, ip)
+func readFile() map[string]entry {
+	speedDialStruct, err := readKeyFile(keyFile)
+	if err != nil {
+		_error(err.Error())
+		return map[string]entry{}
 	}
-	return execCmd(cmd)
+	return speedDialStruct
 }
 
-var exportToAlias = func() {
-	if fileExists() {
-		sdMap := readFile()
-		str := ""
-		for key, value := range sdMap {
-			str += fmt.Sprintf("alias %s=\"%s\"", key, value)
-		}
-		if err := ioutil.WriteFile(aliasFile, []byte(str), 0644); err != nil {
-			_error(err.Error())
-		}
-		print("Wrote speed-dial content to %s as BASH aliases\n", aliasFile)
+var writeFile = func(speedDialStruct map[string]entry) {
+	if err := backupKeyFile(speedDialStruct); err != nil {
+		_error(err.Error())
+	}
+	if err := writeKeyFile(keyFile, speedDialStruct); err != nil {
+		_error(err.Error())
 	}
 }
 
-var execCmd = func(cmd string) int {
+// execCmd replaces sd by bash running cmd, with the variables of env added
+// to the environment.
+var execCmd = func(cmd string, env []string) int {
 	binary, err := exec.LookPath("bash")
 	if err != nil {
 		_error(err.Error())
 	}
 
-	err = syscall.Exec(binary, []string{"bash", "-c", cmd}, os.Environ())
+	err = syscall.Exec(binary, []string{"bash", "-c", cmd}, append(os.Environ(), env...))
 	if err != nil {
 		_error(err.Error())
 	}
@@ -140,10 +219,33 @@ func printMainHelp() {
 	print("Speed dial: a CLI intended to help you remember and faster execute commands you typically write, over and over again.\n")
 	print("Commands:\n")
 	print("%s\n", helpText[SAVE])
+	print("%s\n", helpText[EDIT])
 	print("%s\n", helpText[DELETE])
 	print("%s\n", helpText[GET])
 	print("%s\n", helpText[EXPORT])
+	print("%s\n", helpText[PULL])
+	print("%s\n", helpText[SYNC])
+	print("%s\n", helpText[SERVE])
+	print("%s\n", helpText[REMOTE])
 	print("%s\n", helpText[LIST])
+	print("%s\n", helpText[IMPORT])
+	print("%s\n", helpText[SUGGEST])
+	print("%s\n", helpText[DIFF])
+	print("%s\n", helpText[SEARCH])
+	print("%s\n", helpText[SHOW])
+	print("%s\n", helpText[PICK])
+	print("%s\n", helpText[INIT])
+	print("%s\n", helpText[EXPAND])
+	print("%s\n", helpText[COMPLETION])
+	print("%s\n", helpText[LOG])
+	print("%s\n", helpText[REVERT])
+	print("%s\n", helpText[UNDO])
+	print("%s\n", helpText[TRASH])
+	print("%s\n", helpText[BACKUP])
+	print("%s\n", helpText[RESTORE])
+	print("%s\n", helpText[SECRET])
+	print("%s\n", helpText[AUDIT])
+	print("%s\n", helpText[ON])
 	print("%s\n", helpText[HELP])
 }
 
@@ -158,16 +260,16 @@ func isHelpRequested(command *flag.FlagSet, args []string) bool {
 	return false
 }
 
-func printEntity(sdMap map[string]string, entity string) {
-	entityValues := make([]string, 0, len(sdMap))
-	for k, v := range sdMap {
-		if entity == KEYS {
-			entityValues = append(entityValues, k)
-		} else {
-			entityValues = append(entityValues, v)
-		}
+func printEntity(sdMap map[string]entry, entity string) {
+	rows := entityRows(sdMap, entity)
+	if entity == DESCS {
+		print("%s", formatEntity(rows, OUTPLAIN, false))
+		return
+	}
+	entityValues := make([]string, 0, len(rows))
+	for _, row := range rows {
+		entityValues = append(entityValues, row[0])
 	}
-	sort.Strings(entityValues)
 	print("%s\n", strings.Join(entityValues, " "))
 }
 
@@ -182,6 +284,47 @@ func evalCmd(cmd string) string {
 	return string(out)
 }
 
+type placeholder struct {
+	idx    int
+	def    string
+	hasDef bool
+}
+
+func parsePlaceholder(matchedVal string) placeholder {
+	inner := strings.TrimSuffix(strings.TrimPrefix(matchedVal, "{"), "}")
+	parts := strings.SplitN(inner, "|", 2)
+	idx, _ := strconv.Atoi(parts[0])
+	if len(parts) == 2 {
+		return placeholder{idx: idx, def: parts[1], hasDef: true}
+	}
+	return placeholder{idx: idx}
+}
+
+func placeholders(cmd string) []placeholder {
+	found := map[int]placeholder{}
+	for _, matchedVal := range rAll.FindAllString(cmd, -1) {
+		p := parsePlaceholder(matchedVal)
+		if _, ok := found[p.idx]; !ok || p.hasDef {
+			found[p.idx] = p
+		}
+	}
+	sorted := make([]placeholder, 0, len(found))
+	for _, p := range found {
+		sorted = append(sorted, p)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].idx < sorted[j].idx })
+	return sorted
+}
+
+func previewCmd(cmd string) string {
+	return rAll.ReplaceAllStringFunc(cmd, func(matchedVal string) string {
+		if p := parsePlaceholder(matchedVal); p.hasDef {
+			return p.def
+		}
+		return matchedVal
+	})
+}
+
 func parseCmd(cmd string, args []string) string {
 
 	origCmd := cmd
@@ -229,75 +372,6 @@ func isValidSave(cmd string) bool {
 	return defaultIdx[0][0] > regularIdx[len(regularIdx)-1][1]
 }
 
-func printAsTable(sdMap map[string]string, listLong bool) {
-	padding := 5
-	ellipsed := false
-	windowSizes := evalCmd("stty size")
-	windowSize := strings.Split(windowSizes, " ")
-	windowWidth, _ := strconv.Atoi(strings.Replace(windowSize[1], "\n", "", 1))
-
-	abs := func(val int) int {
-		if val < 0 {
-			return -val
-		}
-		return val
-	}
-
-	var sortedKeys []string
-	for key := range sdMap {
-		keyLen := len(key)
-		if keyLen > maxKey {
-			maxKey = keyLen
-		}
-		sortedKeys = append(sortedKeys, key)
-	}
-
-	for key, value := range sdMap {
-		valueLen := len(value)
-		if valueLen > maxVal {
-			maxVal = valueLen
-		}
-		overflow := windowWidth - maxKey - valueLen - (4*padding + 3)
-		if overflow < 0 && !listLong {
-			ellipsed = true
-			maxVal = valueLen - abs(overflow)
-			sdMap[key] = value[0:maxVal-overflowIndicatorLen] + overflowIndicator
-		}
-	}
-	sort.Strings(sortedKeys)
-
-	printTableRow := func(key string, value string) {
-		leftPaddingSpacing := strings.Repeat(" ", padding)
-		keyRightPaddingSpacing := strings.Repeat(" ", abs(maxKey-len(key))+padding)
-		valueRightPaddingSpacing := strings.Repeat(" ", abs(maxVal-len(value))+padding)
-		print("|" + leftPaddingSpacing + key + keyRightPaddingSpacing + "|" + leftPaddingSpacing + value + valueRightPaddingSpacing + "|\n")
-
-	}
-
-	tableHorizontalBorder := strings.Repeat("-", maxVal+maxKey+(4*padding+3))
-
-	print(tableHorizontalBorder + "\n")
-	printTableRow(keyTableTitle, valueTableTitle)
-
-	print(tableHorizontalBorder + "\n")
-	for _, sKey := range sortedKeys {
-		printTableRow(sKey, sdMap[sKey])
-	}
-
-	print(tableHorizontalBorder + "\n")
-	if ellipsed {
-		print("Note: some values have been ellipsed. Add \"-l\" to see values in full.\n")
-	}
-}
-
-func readPrivateKeyFile(file string) []byte {
-	content, err := ioutil.ReadFile(file)
-	if err != nil {
-		_error(err.Error())
-	}
-	return content
-}
-
 func execute(key string, args []string, debug bool) int {
 	if !fileExists() {
 		return 1
@@ -308,26 +382,83 @@ func execute(key string, args []string, debug bool) int {
 		print("cannot execute command: unknown key \"%s\"\n", key)
 		return 1
 	}
-	val = parseCmd(val, args)
+	cmd := parseCmd(val.Cmd, args)
 	if debug {
-		print("Executed CMD: %s\n", val)
+		print("Executed CMD: %s\n", cmd)
+	}
+	cmd, env, err := resolveSecrets(cmd)
+	if err != nil {
+		print("cannot execute command: %s, %v\n", key, err)
+		return 1
+	}
+	return execCmd(cmd, env)
+}
+
+// validKey tells why key cannot be a key: sd could not tell it from a
+// subcommand or a flag, or it would be split by the shell.
+func validKey(key string) error {
+	if _, reserved := helpText[key]; reserved {
+		return errors.New("is a reserved subcommand name")
 	}
-	return execCmd(val)
+	if strings.ContainsAny(key, " \t\n") {
+		return errors.New("contains whitespace")
+	}
+	if strings.HasPrefix(key, "-") {
+		return errors.New("starts with -")
+	}
+	return nil
 }
 
-func save(command *flag.FlagSet, key, val string) int {
+func save(command *flag.FlagSet, key, val, desc string, tags, argSpecs []string, secretPolicy string) int {
+	if !isValidSecretPolicy(secretPolicy) {
+		return 1
+	}
 	if key == "" || val == "" || strings.Contains(key, " ") {
 		command.PrintDefaults()
 		return 1
 	}
+	for _, tag := range tags {
+		if tag == "" || strings.Contains(tag, " ") {
+			command.PrintDefaults()
+			return 1
+		}
+	}
+	if err := validKey(key); err != nil {
+		print("cannot save key: \"%s\", %v\n", key, err)
+		return 1
+	}
+	args, err := parseArgSources(argSpecs, val)
+	if err != nil {
+		print("cannot save key: \"%s\", %v\n", key, err)
+		return 1
+	}
 	if !fileExists() {
-		writeFile(map[string]string{})
+		writeFile(map[string]entry{})
 	}
 	sdMap := readFile()
 	if isValidSave(val) {
-		sdMap[key] = val
+		previous := sdMap[key]
+		e := previous
+		e.History = nil
+		e.Cmd = val
+		if desc != "" {
+			e.Desc = desc
+		}
+		if len(tags) > 0 {
+			e.Tags = tags
+		}
+		if len(args) > 0 {
+			e.Args = args
+		}
+		e.Updated = timestamp()
+		saved := map[string]entry{key: e}
+		if !guardSecrets(SAVE, saved, secretPolicy) {
+			return 1
+		}
+		sdMap[key] = recordRevision(previous, saved[key])
 		writeFile(sdMap)
-		print("Saved key %s as value: %s", key, val)
+		commitKeys("Save " + key)
+		print("Saved key %s as value: %s", key, saved[key].Cmd)
 		return 0
 	}
 	print("cannot save key: \"%s\", value: \"%s\" contains default argument preceeding regular argument", key, val)
@@ -343,9 +474,14 @@ func deleted(command *flag.FlagSet, key string) int {
 		return 1
 	}
 	sdMap := readFile()
-	if _, exists := sdMap[key]; exists {
+	if e, exists := sdMap[key]; exists {
+		if err := trashKey(key, e); err != nil {
+			print("cannot execute command: %s, %v", DELETE, err)
+			return 1
+		}
 		delete(sdMap, string(key))
 		writeFile(sdMap)
+		commitKeys("Delete " + key)
 		print("deleted the key: %s from speed dial keys", key)
 		return 0
 	}
@@ -353,42 +489,125 @@ func deleted(command *flag.FlagSet, key string) int {
 	return 1
 }
 
-func get(command *flag.FlagSet, getKey, getVal bool) int {
-	if getKey && getVal {
+func get(command *flag.FlagSet, getKey, getVal, getDesc, getTags bool, format string, nulDelimited bool) int {
+	requested := 0
+	for _, flagSet := range []bool{getKey, getVal, getDesc, getTags} {
+		if flagSet {
+			requested++
+		}
+	}
+	if requested > 1 {
 		command.PrintDefaults()
 		return 1
 	}
+	if format != "" && !isValidFormat(format, OUTJSON, OUTYAML, OUTCSV, OUTTSV, OUTPLAIN) {
+		print("unknown output format \"%s\", expected one of: json, yaml, csv, tsv, plain\n", format)
+		return 1
+	}
 	if !fileExists() {
 		return 1
 	}
 	sdMap := readFile()
+	if format != "" || nulDelimited {
+		entity := VALUES
+		switch {
+		case getKey:
+			entity = KEYS
+		case getDesc:
+			entity = DESCS
+		case getTags:
+			entity = TAGS
+		}
+		print("%s", formatEntity(entityRows(sdMap, entity), format, nulDelimited))
+		return 0
+	}
 	if getKey {
 		printEntity(sdMap, KEYS)
 	}
 	if getVal {
 		printEntity(sdMap, VALUES)
 	}
+	if getDesc {
+		printEntity(sdMap, DESCS)
+	}
+	if getTags {
+		printEntity(sdMap, TAGS)
+	}
 	return 0
 }
 
-func export(command *flag.FlagSet, exportToAliasFormat bool, exportIP, exportPrivateKeyFile, exportUser, exportSSHAlias string) int {
+func export(command *flag.FlagSet, exportToAliasFormat bool, exportAliasShell, exportAliasFile, exportFormat, exportFile, exportStrategy string, target remote, hosts fanOut, secretPolicy string) int {
+	if !isValidSecretPolicy(secretPolicy) {
+		return 1
+	}
+	if fileExists() {
+		sdMap := readFile()
+		found := len(scanKeys(sdMap, false)) > 0
+		if !guardSecrets(EXPORT, sdMap, secretPolicy) {
+			return 1
+		}
+		if found && secretPolicy == SECRETSMOVE {
+			writeFile(sdMap)
+			commitKeys("Move secrets out of the keys")
+		}
+		// Previous values are exported along, saving the keys again does
+		// not change them, so they only warn and are left to audit.
+		old := 0
+		for _, e := range sdMap {
+			for _, r := range e.History {
+				if len(scanSecrets(r.Cmd)) > 0 {
+					old++
+					break
+				}
+			}
+		}
+		if old > 0 {
+			print("warning: previous values of %d key(s) hold likely secrets, move them into secrets with sd %s -move\n", old, AUDIT)
+		}
+	}
 	if exportToAliasFormat {
-		exportToAlias()
-		return 0
+		return exportToAlias(exportAliasShell, exportAliasFile)
+	}
+	if exportFormat != "" {
+		return exportToFormat(exportFormat)
 	}
-	if (exportIP == "" && exportSSHAlias == "") || (exportIP != "" && exportSSHAlias != "") {
+	if exportFile != "" {
+		return exportToFile(exportFile, exportStrategy)
+	}
+	targets, err := target.targets(hosts.hostsFile)
+	if err != nil {
+		print("cannot execute command: %s, %v\n", EXPORT, err)
 		command.PrintDefaults()
 		return 1
 	}
-	return transferFile(exportIP, exportPrivateKeyFile, exportUser, exportSSHAlias)
+	if len(targets) > 1 || hosts.hostsFile != "" {
+		return exportToHosts(targets, exportStrategy, hosts)
+	}
+	return transferFile(targets[0], exportStrategy)
 }
 
-func list(listLong bool) int {
+func list(listLong, wrap bool, colorMode string, tags []string, format string) int {
+	if !isValidFormat(format, OUTTABLE, OUTJSON, OUTYAML, OUTCSV, OUTTSV, OUTPLAIN) {
+		print("unknown output format \"%s\", expected one of: table, json, yaml, csv, tsv, plain\n", format)
+		return 1
+	}
+	if !isValidFormat(colorMode, COLORAUTO, COLORALWAYS, COLORNEVER) {
+		print("unknown color mode \"%s\", expected one of: auto, always, never\n", colorMode)
+		return 1
+	}
 	if !fileExists() {
 		return 1
 	}
-	sdMap := readFile()
-	printAsTable(sdMap, listLong)
+	sdMap := filterByTags(readFile(), tags)
+	if len(sdMap) == 0 && len(tags) > 0 {
+		print("no keys tagged with: %s\n", strings.Join(tags, ", "))
+		return 1
+	}
+	if format != OUTTABLE {
+		print("%s", formatRecords(toRecords(sdMap), format))
+		return 0
+	}
+	printAsTable(sdMap, listLong, wrap, colorMode)
 	return 0
 }
 
@@ -401,29 +620,146 @@ func sd(user *user.User) int {
 	getCommand := flag.NewFlagSet(GET, flag.ExitOnError)
 	getKeyPtr := getCommand.Bool("key", false, "Get keys as a whitespace separated list")
 	getValPtr := getCommand.Bool("val", false, "Get values as whitespace separated list")
+	getDescPtr := getCommand.Bool("desc", false, "Get keys and their descriptions, one tab separated pair per line")
+	getTagsPtr := getCommand.Bool("tags", false, "Get all tags in use as a whitespace separated list")
+	getFormatPtr := getCommand.String("o", "", "Output format: json, yaml, csv, tsv or plain (one entity per line)")
+	getNulPtr := getCommand.Bool("0", false, "Terminate every entity with a NUL character instead, for use with xargs -0")
 
 	listCommand := flag.NewFlagSet(LIST, flag.ExitOnError)
 	listLongPtr := listCommand.Bool("l", false, "List saved commands in a non-truncated format independent of screen size")
+	var listTags stringList
+	listCommand.Var(&listTags, "tag", "Only list keys carrying this tag. Can be repeated, keys must carry all given tags")
+	listFormatPtr := listCommand.String("o", OUTTABLE, "Output format: table, json, yaml, csv, tsv or plain")
+	listWrapPtr := listCommand.Bool("wrap", false, "Wrap values which do not fit the screen instead of ellipsing them")
+	listColorPtr := listCommand.String("color", COLORAUTO, "Colorize the table: auto, always or never. NO_COLOR is honored in auto mode")
+
+	showCommand := flag.NewFlagSet(SHOW, flag.ExitOnError)
+	showFormatPtr := showCommand.String("o", OUTTABLE, "Output format: table, json, yaml or plain (the command only)")
+
+	pickCommand := flag.NewFlagSet(PICK, flag.ExitOnError)
+	pickDebugPtr := pickCommand.Bool("d", false, "Print the command before executing it")
+	pickPrintPtr := pickCommand.Bool("print", false, "Print the expanded command instead of executing it")
+
+	initCommand := flag.NewFlagSet(INIT, flag.ExitOnError)
+	initKeyPtr := initCommand.String("key", "g", "Letter to bind together with Ctrl")
+
+	expandCommand := flag.NewFlagSet(EXPAND, flag.ExitOnError)
+
+	completionCommand := flag.NewFlagSet(COMPLETION, flag.ExitOnError)
+
+	importCommand := flag.NewFlagSet(IMPORT, flag.ExitOnError)
+	importFromPtr := importCommand.String("from", "", "Shell rc file to import from: bash-aliases, bashrc, zshrc or fish. (Required)")
+	importPathPtr := importCommand.String("path", "", "Path of the rc file, when not at its default location")
+	importDryRunPtr := importCommand.Bool("dry-run", false, "Print what would be imported without saving anything")
+	importOverwritePtr := importCommand.Bool("overwrite", false, "Replace existing keys with a different command instead of reporting them as conflicts")
+	importFilePtr := importCommand.String("file", "", "Exported key file to import and merge, instead of a shell rc file")
+	importMergePtr := importCommand.String("merge", MERGEOURS, "How to resolve keys of -file differing from yours: ours, theirs, newest (the most recently saved) or interactive")
+	importSecrets := importCommand.String("secrets", SECRETSREFUSE, "What to do with likely credentials in the imported commands: refuse to import, warn, or move them into encrypted secrets")
+
+	diffCommand := flag.NewFlagSet(DIFF, flag.ExitOnError)
+
+	syncCommand := flag.NewFlagSet(SYNC, flag.ExitOnError)
+	syncGit := syncCommand.String("git", "", "URL or path of the git repository to sync with. (Required the first time)")
+	syncMerge := syncCommand.String("merge", MERGENEWEST, "How to resolve keys changed both here and in the repository: ours, theirs, newest (the most recently saved) or interactive")
+	syncSecrets := syncCommand.String("secrets", SECRETSREFUSE, "What to do with likely credentials in the commands received: refuse to sync, warn, or move them into encrypted secrets")
+
+	serveCommand := flag.NewFlagSet(SERVE, flag.ExitOnError)
+	serveAddr := serveCommand.String("addr", ":8080", "Address to listen on")
+	serveToken := serveCommand.String("token", "", "Token clients have to send as a bearer token. (Defaults to $SD_TOKEN)")
+	serveSecrets := serveCommand.String("secrets", SECRETSREFUSE, "What to do with likely credentials in the commands sent: refuse them or warn")
+
+	remoteCommand := flag.NewFlagSet(REMOTE, flag.ExitOnError)
+	var remoteArgs []string
+	remoteToken := remoteCommand.String("token", "", "Token of the remote, when adding one. (Defaults to $SD_TOKEN when syncing)")
+
+	secretCommand := flag.NewFlagSet(SECRET, flag.ExitOnError)
+
+	suggestCommand := flag.NewFlagSet(SUGGEST, flag.ExitOnError)
+	suggestOpts := suggestOptions{}
+	suggestCommand.StringVar(&suggestOpts.shell, "shell", currentShell(), "Shell whose history to read: bash, zsh or fish")
+	suggestCommand.StringVar(&suggestOpts.file, "file", "", "History file to read, instead of the one of -shell")
+	suggestCommand.IntVar(&suggestOpts.minCount, "min-count", 3, "Number of times a command has to be repeated to be suggested")
+	suggestCommand.IntVar(&suggestOpts.minLength, "min-length", 20, "Number of characters of the shortest command to suggest")
+	suggestCommand.IntVar(&suggestOpts.maxVary, "max-vary", 2, "Number of words commands may differ in to be suggested as one key, with placeholders for those words")
+	suggestCommand.IntVar(&suggestOpts.limit, "n", 10, "Number of suggestions")
+	suggestCommand.BoolVar(&suggestOpts.printOnly, "print", false, "Print the suggestions instead of asking which ones to save")
+	suggestCommand.StringVar(&suggestOpts.secrets, "secrets", SECRETSREFUSE, "What to do with likely credentials in the accepted commands: refuse to save them, warn, or move them into encrypted secrets")
+
+	editCommand := flag.NewFlagSet(EDIT, flag.ExitOnError)
+	var editArgs []string
+	editSecrets := editCommand.String("secrets", SECRETSREFUSE, "What to do with likely credentials in the edited commands: refuse to save them, warn, or move them into encrypted secrets")
+
+	logCommand := flag.NewFlagSet(LOG, flag.ExitOnError)
+	var logArgs []string
+	logDiff := logCommand.String("diff", "", "Show the changes from revision N to M as N:M, or from revision N to the current value as N")
+	revertCommand := flag.NewFlagSet(REVERT, flag.ExitOnError)
+	var revertArgs []string
+	revertTo := revertCommand.Int("to", 0, "Revision to revert to, as numbered by log. (Required)")
+
+	undoCommand := flag.NewFlagSet(UNDO, flag.ExitOnError)
+	trashCommand := flag.NewFlagSet(TRASH, flag.ExitOnError)
+	backupCommand := flag.NewFlagSet(BACKUP, flag.ExitOnError)
+	restoreCommand := flag.NewFlagSet(RESTORE, flag.ExitOnError)
+
+	auditCommand := flag.NewFlagSet(AUDIT, flag.ExitOnError)
+	auditMovePtr := auditCommand.Bool("move", false, "Move the likely credentials into encrypted secrets, replacing them with {secret:NAME} placeholders")
+
+	onCommand := flag.NewFlagSet(ON, flag.ExitOnError)
+	onOpts := onFlags(onCommand)
+
+	pullCommand := flag.NewFlagSet(PULL, flag.ExitOnError)
+	pullRemote := remoteFlags(pullCommand, user)
+	pullMerge := pullCommand.String("merge", MERGEOURS, "How to resolve remote keys differing from yours: ours, theirs, newest (the most recently saved) or interactive")
+	pullDryRun := pullCommand.Bool("dry-run", false, "Print what would be pulled without saving anything")
+	pullSecrets := pullCommand.String("secrets", SECRETSREFUSE, "What to do with likely credentials in the pulled commands: refuse to pull, warn, or move them into encrypted secrets")
+
+	searchCommand := flag.NewFlagSet(SEARCH, flag.ExitOnError)
+	searchLongPtr := searchCommand.Bool("l", false, "List matching commands in a non-truncated format independent of screen size")
+	searchWrapPtr := searchCommand.Bool("wrap", false, "Wrap values which do not fit the screen instead of ellipsing them")
+	searchColorPtr := searchCommand.String("color", COLORAUTO, "Colorize the table: auto, always or never. NO_COLOR is honored in auto mode")
 
 	saveKeyPtr := saveCommand.String("key", "", "Key to save. (Required)")
-	saveValPtr := saveCommand.String("val", "", "Val to map key to. (Required)\n\n"+
+	saveValPtr := saveCommand.String("val", "", "Val to map key to. (Required, unless the command is given by -last or as the words after --)\n\n"+
 		"Note:\n"+
 		"White space characters are not allowed in the key naming. \n"+
 		"Special characters such as: $ - for variable reference or ' - single quoutes need to be escaped using the \\ character\n\t"+
 		"Ex: sd save -key ex -val \"for i in {1,2,3}; do echo $\\i; done\"\n\t"+
 		"or: sd save -key ex2 -val \"echo I\\'m home\"\n\t"+
-		"or: sd save -key ex3 -val \"echo {1} {2}\", which can be expanded as: sd ex3 hello world -> hello world")
+		"or: sd save -key ex3 -val \"echo {1} {2}\", which can be expanded as: sd ex3 hello world -> hello world\n\t"+
+		"or, without quoting the command as one value: sd save -key ex4 -- kubectl get pods -n prod")
+	saveLastPtr := saveCommand.Bool("last", false, "Save the previous command of your shell, as recorded by sd init or else found in its history file")
+	savePlaceholdersPtr := saveCommand.Bool("placeholders", false, "Turn the values of the command (URLs, IP and e-mail addresses, ids and numbers) into placeholders defaulting to them")
+	saveDescPtr := saveCommand.String("desc", "", "Description of what the command does")
+	saveSecretsPtr := saveCommand.String("secrets", SECRETSREFUSE, "What to do with likely credentials in the command: refuse to save it, warn, or move them into encrypted secrets")
+	var saveTags stringList
+	saveCommand.Var(&saveTags, "tag", "Tag to group the key under. Can be repeated")
+	var saveArgs stringList
+	saveCommand.Var(&saveArgs, "arg", "Completion source of a placeholder as N=SOURCE, SOURCE being one of: choices:a,b,c files dirs hosts cmd:COMMAND. Can be repeated\n"+
+		"Ex: sd save -key logs -val \"kubectl logs {1}\" -arg \"1=cmd:kubectl get pods -o name\"")
 
 	deleteKeyPtr := deleteCommand.String("key", "", "Key to delete. (Required)")
 
-	exportIP := exportCommand.String("ip", "", "Destination IP to transfer file to. (Required if no SSH alias)")
-	exportPrivateKeyFile := exportCommand.String("id", user.HomeDir+"/.ssh/id_rsa", "Specific private key file to use. (Required if no SSH alias)")
-	exportUser := exportCommand.String("user", user.Username, "User to connect with to remote machine. (Required if no SSH alias)")
-	exportSSHAlias := exportCommand.String("ssh", "", "SSH alias - useful in case of multi-hop export")
-	exportToAliasFormat := exportCommand.Bool("to-alias", false, "Export to alias format and update "+user.HomeDir+"/.bash_aliases")
+	exportRemote := remoteFlags(exportCommand, user)
+	exportHosts := fanOutFlags(exportCommand)
+	exportCommand.StringVar(&exportRemote.binary, "binary", "", "Also copy the sd executable to this path of the remote machines or containers, relative to the home directory")
+	exportToAliasFormat := exportCommand.Bool("to-alias", false, "Export to alias format and update a managed block of the alias file of -shell, "+user.HomeDir+"/.bash_aliases for bash")
+	exportAliasShell := exportCommand.String("shell", BASH, "Shell to export aliases for with -to-alias: bash, zsh, fish or sh")
+	exportAliasFile := exportCommand.String("alias-file", "", "File to export aliases to with -to-alias, instead of the default file of -shell")
+	exportFormat := exportCommand.String("format", "", "Print the keys as a standalone POSIX shell script (script), a Makefile (make) or a justfile (just)")
+	exportFile := exportCommand.String("file", "", "File to export the keys to, merged with the keys already in it")
+	exportSecrets := exportCommand.String("secrets", SECRETSREFUSE, "What to do with likely credentials in the commands: refuse to export, warn, or move them into encrypted secrets first")
+	exportMerge := exportCommand.String("merge", MERGEOURS, "How to resolve keys of the destination differing from yours: ours, theirs, newest (the most recently saved) or interactive")
+
+	commands := []*flag.FlagSet{saveCommand, deleteCommand, getCommand, exportCommand, listCommand, searchCommand,
+		showCommand, pickCommand, initCommand, expandCommand, completionCommand, importCommand, diffCommand, pullCommand, syncCommand, serveCommand, remoteCommand, secretCommand, auditCommand,
+		suggestCommand, editCommand, logCommand, revertCommand, undoCommand, trashCommand, backupCommand, restoreCommand}
 
 	exitCode := 0
 
+	if len(os.Args) < 2 && isTerminal(os.Stdin.Fd()) && isTerminal(os.Stdout.Fd()) && fileExists() {
+		return pick("", false, false)
+	}
+
 	if len(os.Args) < 2 {
 		print("A subcommand or execution key is required\n")
 		printMainHelp()
@@ -457,19 +793,139 @@ func sd(user *user.User) int {
 		if isHelpRequested(listCommand, os.Args) {
 			return 0
 		}
+	case SEARCH:
+		searchCommand.Parse(os.Args[2:])
+		if isHelpRequested(searchCommand, os.Args) {
+			return 0
+		}
+	case SHOW:
+		showCommand.Parse(os.Args[2:])
+		if isHelpRequested(showCommand, os.Args) {
+			return 0
+		}
+	case PICK:
+		pickCommand.Parse(os.Args[2:])
+		if isHelpRequested(pickCommand, os.Args) {
+			return 0
+		}
+	case INIT:
+		initCommand.Parse(os.Args[2:])
+		if isHelpRequested(initCommand, os.Args) {
+			return 0
+		}
+	case EXPAND:
+		expandCommand.Parse(os.Args[2:])
+		if isHelpRequested(expandCommand, os.Args[:min(len(os.Args), 3)]) {
+			return 0
+		}
+	case COMPLETION:
+		completionCommand.Parse(os.Args[2:])
+		if isHelpRequested(completionCommand, os.Args) {
+			return 0
+		}
+	case IMPORT:
+		importCommand.Parse(os.Args[2:])
+		if isHelpRequested(importCommand, os.Args) {
+			return 0
+		}
+	case PULL:
+		pullCommand.Parse(os.Args[2:])
+		if isHelpRequested(pullCommand, os.Args) {
+			return 0
+		}
+	case SYNC:
+		syncCommand.Parse(os.Args[2:])
+		if isHelpRequested(syncCommand, os.Args) {
+			return 0
+		}
+	case SERVE:
+		serveCommand.Parse(os.Args[2:])
+		if isHelpRequested(serveCommand, os.Args) {
+			return 0
+		}
+	case REMOTE:
+		remoteArgs = parseInterspersed(remoteCommand, os.Args[2:])
+		if isHelpRequested(remoteCommand, os.Args) {
+			return 0
+		}
+	case SECRET:
+		secretCommand.Parse(os.Args[2:])
+		if isHelpRequested(secretCommand, os.Args) {
+			return 0
+		}
+	case SUGGEST:
+		suggestCommand.Parse(os.Args[2:])
+		if isHelpRequested(suggestCommand, os.Args) {
+			return 0
+		}
+	case EDIT:
+		editArgs = parseInterspersed(editCommand, os.Args[2:])
+		if isHelpRequested(editCommand, os.Args) {
+			return 0
+		}
+	case LOG:
+		logArgs = parseInterspersed(logCommand, os.Args[2:])
+		if isHelpRequested(logCommand, os.Args) {
+			return 0
+		}
+	case REVERT:
+		revertArgs = parseInterspersed(revertCommand, os.Args[2:])
+		if isHelpRequested(revertCommand, os.Args) {
+			return 0
+		}
+	case UNDO:
+		undoCommand.Parse(os.Args[2:])
+		if isHelpRequested(undoCommand, os.Args) {
+			return 0
+		}
+	case TRASH:
+		trashCommand.Parse(os.Args[2:])
+		if isHelpRequested(trashCommand, os.Args) {
+			return 0
+		}
+	case BACKUP:
+		backupCommand.Parse(os.Args[2:])
+		if isHelpRequested(backupCommand, os.Args) {
+			return 0
+		}
+	case RESTORE:
+		restoreCommand.Parse(os.Args[2:])
+		if isHelpRequested(restoreCommand, os.Args) {
+			return 0
+		}
+	case AUDIT:
+		auditCommand.Parse(os.Args[2:])
+		if isHelpRequested(auditCommand, os.Args) {
+			return 0
+		}
+	case DIFF:
+		diffCommand.Parse(os.Args[2:])
+		if isHelpRequested(diffCommand, os.Args) {
+			return 0
+		}
+	case COMPLETE:
+		return complete(commands, os.Args[2:])
 	case HELP, HELPSHORT, HELPSHORTER:
 		printMainHelp()
 		return 0
 	default:
 		if os.Args[1] == "-d" {
 			exitCode = execute(os.Args[2], os.Args[3:], true)
+		} else if os.Args[1] == ON || strings.HasPrefix(os.Args[1], ON+"=") {
+			onCommand.Parse(os.Args[1:])
 		} else {
 			exitCode = execute(os.Args[1], os.Args[2:], false)
 		}
 	}
 
 	if saveCommand.Parsed() {
-		exitCode = save(saveCommand, *saveKeyPtr, *saveValPtr)
+		val, err := saveValue(*saveValPtr, *saveLastPtr, saveCommand.Args(), *savePlaceholdersPtr)
+		if err != nil {
+			print("cannot save key: \"%s\", %v\n", *saveKeyPtr, err)
+			exitCode = 1
+		} else {
+			exitCode = save(saveCommand, *saveKeyPtr, val, *saveDescPtr, saveTags, saveArgs, *saveSecretsPtr)
+		}
 	}
 
 	if deleteCommand.Parsed() {
@@ -477,15 +933,119 @@ func sd(user *user.User) int {
 	}
 
 	if listCommand.Parsed() {
-		exitCode = list(*listLongPtr)
+		exitCode = list(*listLongPtr, *listWrapPtr, *listColorPtr, listTags, *listFormatPtr)
+	}
+
+	if showCommand.Parsed() {
+		exitCode = show(showCommand, showCommand.Arg(0), *showFormatPtr)
+	}
+
+	if pickCommand.Parsed() {
+		exitCode = picked(strings.Join(pickCommand.Args(), " "), *pickDebugPtr, *pickPrintPtr)
+	}
+
+	if initCommand.Parsed() {
+		exitCode = shellInit(initCommand, initCommand.Arg(0), *initKeyPtr)
+	}
+
+	if completionCommand.Parsed() {
+		exitCode = completion(completionCommand, completionCommand.Arg(0))
+	}
+
+	if importCommand.Parsed() {
+		if *importFilePtr != "" {
+			exitCode = importFile(*importFilePtr, *importMergePtr, *importDryRunPtr, *importSecrets)
+		} else {
+			exitCode = importRC(importCommand, *importFromPtr, *importPathPtr, *importDryRunPtr, *importOverwritePtr, *importSecrets)
+		}
+	}
+
+	if pullCommand.Parsed() {
+		exitCode = pull(pullCommand, *pullRemote, *pullMerge, *pullDryRun, *pullSecrets)
+	}
+
+	if syncCommand.Parsed() {
+		if syncCommand.NArg() > 0 {
+			exitCode = syncRemote(syncCommand.Arg(0), *syncMerge, *syncSecrets)
+		} else {
+			exitCode = syncKeys(syncCommand, *syncGit, *syncMerge, *syncSecrets)
+		}
+	}
+
+	if secretCommand.Parsed() {
+		exitCode = secrets(secretCommand, secretCommand.Args())
+	}
+
+	if suggestCommand.Parsed() {
+		exitCode = suggest(suggestOpts)
+	}
+
+	if editCommand.Parsed() {
+		exitCode = edit(strings.Join(editArgs, " "), *editSecrets)
+	}
+
+	if logCommand.Parsed() {
+		exitCode = keyLog(logCommand, strings.Join(logArgs, " "), *logDiff)
+	}
+
+	if revertCommand.Parsed() {
+		exitCode = revert(revertCommand, strings.Join(revertArgs, " "), *revertTo)
+	}
+
+	if undoCommand.Parsed() {
+		exitCode = undo()
+	}
+
+	if trashCommand.Parsed() {
+		exitCode = trash(trashCommand, trashCommand.Args())
+	}
+
+	if backupCommand.Parsed() {
+		exitCode = backup(backupCommand.Arg(0))
+	}
+
+	if restoreCommand.Parsed() {
+		exitCode = restore(restoreCommand, restoreCommand.Arg(0))
+	}
+
+	if auditCommand.Parsed() {
+		exitCode = audit(*auditMovePtr)
+	}
+
+	if onCommand.Parsed() {
+		exitCode = executeOn(onCommand, *onOpts)
+	}
+
+	if serveCommand.Parsed() {
+		exitCode = serve(serveCommand, *serveAddr, *serveToken, *serveSecrets)
+	}
+
+	if remoteCommand.Parsed() {
+		exitCode = remotes(remoteCommand, remoteArgs, *remoteToken)
+	}
+
+	if diffCommand.Parsed() {
+		exitCode = diff(diffCommand, diffCommand.Arg(0))
+	}
+
+	if expandCommand.Parsed() {
+		args := expandCommand.Args()
+		if len(args) == 0 {
+			args = []string{""}
+		}
+		exitCode = expand(expandCommand, args[0], args[1:])
+	}
+
+	if searchCommand.Parsed() {
+		exitCode = search(searchCommand, strings.Join(searchCommand.Args(), " "), *searchLongPtr, *searchWrapPtr, *searchColorPtr)
 	}
 
 	if getCommand.Parsed() {
-		exitCode = get(getCommand, *getKeyPtr, *getValPtr)
+		exitCode = get(getCommand, *getKeyPtr, *getValPtr, *getDescPtr, *getTagsPtr, *getFormatPtr, *getNulPtr)
 	}
 
 	if exportCommand.Parsed() {
-		exitCode = export(exportCommand, *exportToAliasFormat, *exportIP, *exportPrivateKeyFile, *exportUser, *exportSSHAlias)
+		exitCode = export(exportCommand, *exportToAliasFormat, *exportAliasShell, *exportAliasFile, *exportFormat, *exportFile, *exportMerge, *exportRemote, *exportHosts, *exportSecrets)
 	}
 	return exitCode
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -80,9 +81,9 @@ func TestPrintEntity(t *testing.T) {
 		{
 			tName: "Test print entity keys",
 			tInput: []T{
-				map[string]string{
-					"test1": "hello",
-					"test2": "world",
+				map[string]entry{
+					"test1": {Cmd: "hello"},
+					"test2": {Cmd: "world"},
 				},
 				KEYS,
 			},
@@ -92,15 +93,39 @@ func TestPrintEntity(t *testing.T) {
 		{
 			tName: "Test print entity values",
 			tInput: []T{
-				map[string]string{
-					"test1": "hello",
-					"test2": "world",
+				map[string]entry{
+					"test1": {Cmd: "hello"},
+					"test2": {Cmd: "world"},
 				},
 				VALUES,
 			},
 			tFunc:       printEntity,
 			tPipeOutput: "hello world\n",
 		},
+		{
+			tName: "Test print entity descriptions",
+			tInput: []T{
+				map[string]entry{
+					"test1": {Cmd: "hello", Desc: "say hello"},
+					"test2": {Cmd: "world"},
+				},
+				DESCS,
+			},
+			tFunc:       printEntity,
+			tPipeOutput: "test1\tsay hello\ntest2\t\n",
+		},
+		{
+			tName: "Test print entity tags",
+			tInput: []T{
+				map[string]entry{
+					"test1": {Cmd: "hello", Tags: []string{"k8s", "debug"}},
+					"test2": {Cmd: "world", Tags: []string{"k8s"}},
+				},
+				TAGS,
+			},
+			tFunc:       printEntity,
+			tPipeOutput: "debug k8s\n",
+		},
 	}
 	testPackageMethod(tt, t)
 }
@@ -166,9 +191,9 @@ func TestReadFile(t *testing.T) {
 			tName:  "Test read file which exists and is JSON valid",
 			tInput: []T{},
 			tFunc:  readFile,
-			tOutput: map[string]string{
-				"something": "new",
-				"hello":     "echo world",
+			tOutput: map[string]entry{
+				"something": {Cmd: "new"},
+				"hello":     {Cmd: "echo world"},
 			},
 		},
 	}
@@ -180,7 +205,7 @@ func TestReadFile(t *testing.T) {
 			tName:   "Test read file which exists but is JSON invalid",
 			tInput:  []T{},
 			tFunc:   readFile,
-			tOutput: map[string]string{},
+			tOutput: map[string]entry{},
 		},
 	}
 	testPackageMethod(tt, t)
@@ -191,7 +216,7 @@ func TestReadFile(t *testing.T) {
 			tName:   "Test read file which does not exists",
 			tInput:  []T{},
 			tFunc:   readFile,
-			tOutput: map[string]string{},
+			tOutput: map[string]entry{},
 		},
 	}
 	testPackageMethod(tt, t)
@@ -410,7 +435,7 @@ func TestIsValidSave(t *testing.T) {
 
 func TestDelete(t *testing.T) {
 	keyFile = "./test/.dial_keys_valid"
-	writeFile = func(sdMap map[string]string) {}
+	writeFile = func(sdMap map[string]entry) {}
 	tt := []ttFStruct{
 		{
 			tName: "Test delete command with insufficient args",
@@ -447,7 +472,7 @@ func TestDelete(t *testing.T) {
 
 func TestSave(t *testing.T) {
 	keyFile = "./test/.dial_keys_valid"
-	writeFile = func(sdMap map[string]string) {}
+	writeFile = func(sdMap map[string]entry) {}
 	tt := []ttFStruct{
 		{
 			tName: "Test save command with bad key 1",
@@ -455,6 +480,10 @@ func TestSave(t *testing.T) {
 				flag.NewFlagSet(SAVE, flag.ExitOnError),
 				"this wont work",
 				"echo hello world",
+				"",
+				[]string{},
+				[]string{},
+				SECRETSREFUSE,
 			},
 			tFunc:   save,
 			tOutput: 1,
@@ -465,6 +494,10 @@ func TestSave(t *testing.T) {
 				flag.NewFlagSet(SAVE, flag.ExitOnError),
 				"this wont work",
 				"",
+				"",
+				[]string{},
+				[]string{},
+				SECRETSREFUSE,
 			},
 			tFunc:   save,
 			tOutput: 1,
@@ -475,27 +508,113 @@ func TestSave(t *testing.T) {
 				flag.NewFlagSet(SAVE, flag.ExitOnError),
 				"",
 				"this wont work",
+				"",
+				[]string{},
+				[]string{},
+				SECRETSREFUSE,
 			},
 			tFunc:   save,
 			tOutput: 1,
 		},
+		{
+			tName: "Test save command with reserved key",
+			tInput: []T{
+				flag.NewFlagSet(SAVE, flag.ExitOnError),
+				"list",
+				"ls -l",
+				"",
+				[]string{},
+				[]string{},
+				SECRETSREFUSE,
+			},
+			tFunc:       save,
+			tPipeOutput: "cannot save key: \"list\", is a reserved subcommand name\n",
+			tOutput:     1,
+		},
+		{
+			tName: "Test save command with flag-like key",
+			tInput: []T{
+				flag.NewFlagSet(SAVE, flag.ExitOnError),
+				"-x",
+				"ls -l",
+				"",
+				[]string{},
+				[]string{},
+				SECRETSREFUSE,
+			},
+			tFunc:       save,
+			tPipeOutput: "cannot save key: \"-x\", starts with -\n",
+			tOutput:     1,
+		},
 		{
 			tName: "Test save command with valid content",
 			tInput: []T{
 				flag.NewFlagSet(SAVE, flag.ExitOnError),
 				"test",
 				"echo hello world",
+				"",
+				[]string{},
+				[]string{},
+				SECRETSREFUSE,
+			},
+			tFunc:       save,
+			tPipeOutput: "Saved key test as value: echo hello world",
+			tOutput:     0,
+		},
+		{
+			tName: "Test save command with description and tags",
+			tInput: []T{
+				flag.NewFlagSet(SAVE, flag.ExitOnError),
+				"test",
+				"echo hello world",
+				"say hello",
+				[]string{"greeting", "demo"},
+				[]string{},
+				SECRETSREFUSE,
 			},
 			tFunc:       save,
 			tPipeOutput: "Saved key test as value: echo hello world",
 			tOutput:     0,
 		},
+		{
+			tName: "Test save command with whitespace in tag",
+			tInput: []T{
+				flag.NewFlagSet(SAVE, flag.ExitOnError),
+				"test",
+				"echo hello world",
+				"",
+				[]string{"not valid"},
+				[]string{},
+				SECRETSREFUSE,
+			},
+			tFunc:   save,
+			tOutput: 1,
+		},
+		{
+			tName: "Test save command with invalid argument source",
+			tInput: []T{
+				flag.NewFlagSet(SAVE, flag.ExitOnError),
+				"test",
+				"echo {1}",
+				"",
+				[]string{},
+				[]string{"2=files"},
+				SECRETSREFUSE,
+			},
+			tFunc:       save,
+			tPipeOutput: "cannot save key: \"test\", invalid argument source \"2=files\", the command has no placeholder {2}\n",
+			tOutput:     1,
+		},
 		{
 			tName: "Test save command with invalid content",
 			tInput: []T{
 				flag.NewFlagSet(SAVE, flag.ExitOnError),
 				"test",
 				"echo {1|test} {2}",
+				"",
+				[]string{},
+				[]string{},
+				SECRETSREFUSE,
 			},
 			tFunc:       save,
 			tPipeOutput: "cannot save key: \"test\", value: \"echo {1|test} {2}\" contains default argument preceeding regular argument",
@@ -514,6 +633,10 @@ func TestCommandsWithNoExistingFile(t *testing.T) {
 				flag.NewFlagSet(SAVE, flag.ExitOnError),
 				"this",
 				"echo hello world",
+				"",
+				[]string{},
+				[]string{},
+				SECRETSREFUSE,
 			},
 			tFunc:       save,
 			tPipeOutput: "Saved key this as value: echo hello world",
@@ -535,6 +658,10 @@ func TestCommandsWithNoExistingFile(t *testing.T) {
 				flag.NewFlagSet(GET, flag.ExitOnError),
 				true,
 				false,
+				false,
+				false,
+				"",
+				false,
 			},
 			tFunc:   get,
 			tOutput: 1,
@@ -562,6 +689,24 @@ func TestGet(t *testing.T) {
 				flag.NewFlagSet(GET, flag.ExitOnError),
 				true,
 				true,
+				false,
+				false,
+				"",
+				false,
+			},
+			tFunc:   get,
+			tOutput: 1,
+		},
+		{
+			tName: "Test get command with both key and tags",
+			tInput: []T{
+				flag.NewFlagSet(GET, flag.ExitOnError),
+				true,
+				false,
+				false,
+				true,
+				"",
+				false,
 			},
 			tFunc:   get,
 			tOutput: 1,
@@ -572,17 +717,55 @@ func TestGet(t *testing.T) {
 				flag.NewFlagSet(GET, flag.ExitOnError),
 				true,
 				false,
+				false,
+				false,
+				"",
+				false,
 			},
 			tFunc:       get,
 			tOutput:     0,
 			tPipeOutput: "hello something\n",
 		},
+		{
+			tName: "Test get command with unknown format",
+			tInput: []T{
+				flag.NewFlagSet(GET, flag.ExitOnError),
+				true,
+				false,
+				false,
+				false,
+				"xml",
+				false,
+			},
+			tFunc:       get,
+			tOutput:     1,
+			tPipeOutput: "unknown output format \"xml\", expected one of: json, yaml, csv, tsv, plain\n",
+		},
+		{
+			tName: "Test get command with val one per line",
+			tInput: []T{
+				flag.NewFlagSet(GET, flag.ExitOnError),
+				false,
+				true,
+				false,
+				false,
+				OUTPLAIN,
+				false,
+			},
+			tFunc:       get,
+			tOutput:     0,
+			tPipeOutput: "echo world\nnew\n",
+		},
 		{
 			tName: "Test get command with val",
 			tInput: []T{
 				flag.NewFlagSet(GET, flag.ExitOnError),
 				false,
 				true,
+				false,
+				false,
+				"",
+				false,
 			},
 			tFunc:       get,
 			tOutput:     0,
@@ -594,7 +777,7 @@ func TestGet(t *testing.T) {
 
 func TestExecute(t *testing.T) {
 	keyFile = "./test/.dial_keys_valid"
-	execCmd = func(cmd string) int {
+	execCmd = func(cmd string, env []string) int {
 		return 0
 	}
 	tt := []ttFStruct{
@@ -625,33 +808,47 @@ func TestExecute(t *testing.T) {
 
 func TestExport(t *testing.T) {
 	keyFile = "./test/.dial_keys_valid"
-	transferFile = func(ip string, privateKeyFile string, user string, sshAlias string) int {
+	defer func(transfer func(remote, string) int, toAlias func(string, string) int) {
+		transferFile, exportToAlias = transfer, toAlias
+	}(transferFile, exportToAlias)
+	transferFile = func(r remote, strategy string) int {
+		return 0
+	}
+	exportToAlias = func(shell, file string) int {
 		return 0
 	}
-	exportToAlias = func() {}
 	tt := []ttFStruct{
 		{
 			tName: "Test export command without destination",
 			tInput: []T{
 				flag.NewFlagSet(EXPORT, flag.ExitOnError),
 				false,
+				BASH,
 				"",
 				"",
 				"",
 				"",
+				remote{},
+				fanOut{},
+				SECRETSREFUSE,
 			},
-			tFunc:   export,
-			tOutput: 1,
+			tFunc:       export,
+			tOutput:     1,
+			tPipeOutput: "cannot execute command: export, either -ip, -ssh, -hosts, -docker or -kube is required\n",
 		},
 		{
 			tName: "Test export command with destination alias",
 			tInput: []T{
 				flag.NewFlagSet(EXPORT, flag.ExitOnError),
 				false,
+				BASH,
+				"",
 				"",
 				"",
 				"",
-				"myAlias",
+				remote{sshAlias: "myAlias"},
+				fanOut{},
+				SECRETSREFUSE,
 			},
 			tFunc:   export,
 			tOutput: 0,
@@ -661,10 +858,14 @@ func TestExport(t *testing.T) {
 			tInput: []T{
 				flag.NewFlagSet(EXPORT, flag.ExitOnError),
 				false,
-				"127.0.0.1",
+				BASH,
 				"",
 				"",
 				"",
+				"",
+				remote{ip: "127.0.0.1"},
+				fanOut{},
+				SECRETSREFUSE,
 			},
 			tFunc:   export,
 			tOutput: 0,
@@ -674,23 +875,32 @@ func TestExport(t *testing.T) {
 			tInput: []T{
 				flag.NewFlagSet(EXPORT, flag.ExitOnError),
 				false,
-				"127.0.0.1",
+				BASH,
+				"",
+				"",
 				"",
 				"",
-				"myAlias",
+				remote{ip: "127.0.0.1", sshAlias: "myAlias"},
+				fanOut{},
+				SECRETSREFUSE,
 			},
-			tFunc:   export,
-			tOutput: 1,
+			tFunc:       export,
+			tOutput:     1,
+			tPipeOutput: "cannot execute command: export, either -ip or -ssh is required\n",
 		},
 		{
 			tName: "Test export command with local export to alias format",
 			tInput: []T{
 				flag.NewFlagSet(EXPORT, flag.ExitOnError),
 				true,
-				"127.0.0.1",
+				BASH,
+				"",
 				"",
 				"",
 				"",
+				remote{ip: "127.0.0.1"},
+				fanOut{},
+				SECRETSREFUSE,
 			},
 			tFunc:   export,
 			tOutput: 0,
@@ -698,3 +908,72 @@ func TestExport(t *testing.T) {
 	}
 	testPackageMethod(tt, t)
 }
+
+func TestEntryJSON(t *testing.T) {
+	marshal := func(e entry) string {
+		out, _ := json.Marshal(e)
+		return string(out)
+	}
+	unmarshal := func(data string) entry {
+		e := entry{}
+		json.Unmarshal([]byte(data), &e)
+		return e
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test marshal entry without metadata",
+			tInput:  []T{entry{Cmd: "echo hello"}},
+			tFunc:   marshal,
+			tOutput: "\"echo hello\"",
+		},
+		{
+			tName:   "Test marshal entry with metadata",
+			tInput:  []T{entry{Cmd: "echo hello", Desc: "greet", Tags: []string{"demo"}}},
+			tFunc:   marshal,
+			tOutput: "{\"cmd\":\"echo hello\",\"desc\":\"greet\",\"tags\":[\"demo\"]}",
+		},
+		{
+			tName:   "Test unmarshal plain command string",
+			tInput:  []T{"\"echo hello\""},
+			tFunc:   unmarshal,
+			tOutput: entry{Cmd: "echo hello"},
+		},
+		{
+			tName:   "Test unmarshal entry with metadata",
+			tInput:  []T{"{\"cmd\":\"echo hello\",\"desc\":\"greet\",\"tags\":[\"demo\"]}"},
+			tFunc:   unmarshal,
+			tOutput: entry{Cmd: "echo hello", Desc: "greet", Tags: []string{"demo"}},
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestPlaceholders(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName:   "Test placeholders without any",
+			tInput:  []T{"echo hello"},
+			tFunc:   placeholders,
+			tOutput: []placeholder{},
+		},
+		{
+			tName:   "Test placeholders sorted and unique",
+			tInput:  []T{"echo {2|x} {1} {1} {12}"},
+			tFunc:   placeholders,
+			tOutput: []placeholder{{idx: 1}, {idx: 2, def: "x", hasDef: true}, {idx: 12}},
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestPreviewCmd(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName:   "Test preview expands defaults only",
+			tInput:  []T{"kubectl logs {1} -n {2|default}"},
+			tFunc:   previewCmd,
+			tOutput: "kubectl logs {1} -n default",
+		},
+	}
+	testPackageMethod(tt, t)
+}
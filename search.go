@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+func filterByTags(sdMap map[string]entry, tags []string) map[string]entry {
+	filtered := map[string]entry{}
+	for key, value := range sdMap {
+		matches := true
+		for _, tag := range tags {
+			if !value.hasTag(tag) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
+
+func matchesTerm(key string, value entry, term string) bool {
+	term = strings.ToLower(term)
+	fields := append([]string{key, value.Desc, value.Cmd}, value.Tags...)
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), term) {
+			return true
+		}
+	}
+	return false
+}
+
+func searchKeys(sdMap map[string]entry, term string) map[string]entry {
+	found := map[string]entry{}
+	for key, value := range sdMap {
+		if matchesTerm(key, value, term) {
+			found[key] = value
+		}
+	}
+	return found
+}
+
+func search(command *flag.FlagSet, term string, listLong, wrap bool, colorMode string) int {
+	if term == "" {
+		command.PrintDefaults()
+		return 1
+	}
+	if !fileExists() {
+		return 1
+	}
+	found := searchKeys(readFile(), term)
+	if len(found) == 0 {
+		print("no keys matching \"%s\"\n", term)
+		return 1
+	}
+	printAsTable(found, listLong, wrap, colorMode)
+	return 0
+}
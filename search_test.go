@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"testing"
+)
+
+func TestFilterByTags(t *testing.T) {
+	keyFile = "./test/.dial_keys_tagged"
+	sdMap := readFile()
+	keys := func(m map[string]entry) []string {
+		var found []string
+		for key := range m {
+			found = append(found, key)
+		}
+		sort.Strings(found)
+		return found
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test filter without tags",
+			tInput:  []T{sdMap, []string{}},
+			tFunc:   func(m map[string]entry, tags []string) []string { return keys(filterByTags(m, tags)) },
+			tOutput: []string{"hello", "logs", "pods"},
+		},
+		{
+			tName:   "Test filter with one tag",
+			tInput:  []T{sdMap, []string{"k8s"}},
+			tFunc:   func(m map[string]entry, tags []string) []string { return keys(filterByTags(m, tags)) },
+			tOutput: []string{"logs", "pods"},
+		},
+		{
+			tName:   "Test filter with several tags",
+			tInput:  []T{sdMap, []string{"k8s", "debug"}},
+			tFunc:   func(m map[string]entry, tags []string) []string { return keys(filterByTags(m, tags)) },
+			tOutput: []string{"logs"},
+		},
+		{
+			tName:   "Test filter with unknown tag",
+			tInput:  []T{sdMap, []string{"nope"}},
+			tFunc:   func(m map[string]entry, tags []string) []string { return keys(filterByTags(m, tags)) },
+			tOutput: []string{},
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestMatchesTerm(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName:   "Test match on key",
+			tInput:  []T{"pods", entry{Cmd: "kubectl get pods"}, "POD"},
+			tFunc:   matchesTerm,
+			tOutput: true,
+		},
+		{
+			tName:   "Test match on description",
+			tInput:  []T{"l", entry{Cmd: "kubectl logs", Desc: "Follow pod logs"}, "follow"},
+			tFunc:   matchesTerm,
+			tOutput: true,
+		},
+		{
+			tName:   "Test match on tag",
+			tInput:  []T{"l", entry{Cmd: "kubectl logs", Tags: []string{"debug"}}, "debug"},
+			tFunc:   matchesTerm,
+			tOutput: true,
+		},
+		{
+			tName:   "Test match on command",
+			tInput:  []T{"l", entry{Cmd: "kubectl logs"}, "logs"},
+			tFunc:   matchesTerm,
+			tOutput: true,
+		},
+		{
+			tName:   "Test no match",
+			tInput:  []T{"l", entry{Cmd: "kubectl logs"}, "docker"},
+			tFunc:   matchesTerm,
+			tOutput: false,
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestSearch(t *testing.T) {
+	keyFile = "./test/.dial_keys_tagged"
+	tt := []ttFStruct{
+		{
+			tName: "Test search without term",
+			tInput: []T{
+				flag.NewFlagSet(SEARCH, flag.ExitOnError),
+				"",
+				false,
+				false,
+				COLORNEVER,
+			},
+			tFunc:   search,
+			tOutput: 1,
+		},
+		{
+			tName: "Test search without match",
+			tInput: []T{
+				flag.NewFlagSet(SEARCH, flag.ExitOnError),
+				"docker",
+				false,
+				false,
+				COLORNEVER,
+			},
+			tFunc:       search,
+			tOutput:     1,
+			tPipeOutput: "no keys matching \"docker\"\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
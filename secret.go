@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var SECRET = "secret"
+var (
+	SECRETSET    = "set"
+	SECRETDELETE = "delete"
+	SECRETLIST   = "list"
+)
+
+// Secrets are kept apart from the keys, so exporting or syncing the keys
+// never copies them: commands only hold {secret:NAME} placeholders.
+var secretsFile = getHomeDir() + string(os.PathSeparator) + ".dial_secrets"
+
+var (
+	rSecret     = regexp.MustCompile(`\{secret:([^{}\s]+)\}`)
+	rSecretName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+)
+
+var secretIterations = 600000
+
+// secretCheck is encrypted along with the secrets to tell a wrong passphrase
+// from a corrupted secret.
+const secretCheck = "speed-dial"
+
+type secretStore struct {
+	Salt    string            `json:"salt"`
+	Check   string            `json:"check"`
+	Secrets map[string]string `json:"secrets"`
+}
+
+func readSecrets() (*secretStore, error) {
+	content, err := ioutil.ReadFile(secretsFile)
+	if os.IsNotExist(err) {
+		return &secretStore{Secrets: map[string]string{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	store := &secretStore{}
+	if err := json.Unmarshal(content, store); err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", secretsFile, err)
+	}
+	if store.Secrets == nil {
+		store.Secrets = map[string]string{}
+	}
+	return store, nil
+}
+
+func writeSecrets(store *secretStore) error {
+	content, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(secretsFile, content, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(secretsFile, 0600)
+}
+
+// secretCipher derives the AES-256-GCM cipher of the store from the
+// passphrase.
+func secretCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, secretIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts a secret, authenticating its name so secrets cannot be
+// swapped in the file.
+func seal(aead cipher.AEAD, name, value string) string {
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), []byte(name)))
+}
+
+func unseal(aead cipher.AEAD, name, sealed string) (string, error) {
+	content, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(content) < aead.NonceSize() {
+		return "", fmt.Errorf("the secret %s is corrupted", name)
+	}
+	value, err := aead.Open(nil, content[:aead.NonceSize()], content[aead.NonceSize():], []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("the secret %s is corrupted", name)
+	}
+	return string(value), nil
+}
+
+// unlock asks for the passphrase of the store, choosing a new one when the
+// store is empty.
+func (store *secretStore) unlock() (cipher.AEAD, error) {
+	if store.Salt == "" {
+		passphrase, err := readPassphrase("New passphrase for the secrets: ", true)
+		if err != nil {
+			return nil, err
+		}
+		salt := make([]byte, 16)
+		rand.Read(salt)
+		aead, err := secretCipher(passphrase, salt)
+		if err != nil {
+			return nil, err
+		}
+		store.Salt = base64.StdEncoding.EncodeToString(salt)
+		store.Check = seal(aead, "", secretCheck)
+		return aead, nil
+	}
+	passphrase, err := readPassphrase("Passphrase for the secrets: ", false)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := base64.StdEncoding.DecodeString(store.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", secretsFile, err)
+	}
+	aead, err := secretCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if check, err := unseal(aead, "", store.Check); err != nil || check != secretCheck {
+		return nil, errors.New("wrong passphrase")
+	}
+	return aead, nil
+}
+
+// readPassphrase takes the passphrase from $SD_PASSPHRASE or asks for it on
+// the terminal, twice when confirm is set.
+var readPassphrase = func(prompt string, confirm bool) (string, error) {
+	if passphrase := os.Getenv("SD_PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+	passphrase, err := readHidden(prompt)
+	if err != nil {
+		return "", err
+	}
+	if passphrase == "" {
+		return "", errors.New("the passphrase cannot be empty")
+	}
+	if confirm {
+		again, err := readHidden("Repeat the passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", errors.New("the passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
+
+// readHidden reads a line from the terminal without echoing it.
+func readHidden(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("a terminal or SD_PASSPHRASE is required")
+	}
+	defer tty.Close()
+	restore, err := makeRaw(tty.Fd())
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+	io.WriteString(tty, prompt)
+	defer io.WriteString(tty, "\r\n")
+	return readHiddenLine(bufio.NewReader(tty))
+}
+
+func readHiddenLine(in *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		b, err := in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch b {
+		case '\r', '\n':
+			return string(line), nil
+		case 3, 4:
+			return "", errors.New("cancelled")
+		case 127, 8:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		default:
+			line = append(line, b)
+		}
+	}
+}
+
+// readSecretValue reads the value of a secret from the standard input when
+// piped, and from the terminal without echo otherwise.
+var readSecretValue = func(name string) (string, error) {
+	if !isTerminal(os.Stdin.Fd()) {
+		content, err := ioutil.ReadAll(os.Stdin)
+		return strings.TrimRight(string(content), "\r\n"), err
+	}
+	return readHidden("Value of " + name + ": ")
+}
+
+func secrets(command *flag.FlagSet, args []string) int {
+	usage := func(format string, a ...interface{}) int {
+		print("cannot execute command: %s, %s\n", SECRET, fmt.Sprintf(format, a...))
+		command.PrintDefaults()
+		return 1
+	}
+	fail := func(err error) int {
+		print("cannot execute command: %s, %v\n", SECRET, err)
+		return 1
+	}
+	if len(args) == 0 {
+		args = []string{SECRETLIST}
+	}
+	store, err := readSecrets()
+	if err != nil {
+		return fail(err)
+	}
+	switch args[0] {
+	case SECRETLIST:
+		names := make([]string, 0, len(store.Secrets))
+		for name := range store.Secrets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			print("%s\n", name)
+		}
+		return 0
+	case SECRETSET:
+		if len(args) != 2 {
+			return usage("usage: %s %s NAME", SECRET, SECRETSET)
+		}
+		name := args[1]
+		if !rSecretName.MatchString(name) {
+			return usage("invalid secret name \"%s\", use letters, digits, _, . and -", name)
+		}
+		aead, err := store.unlock()
+		if err != nil {
+			return fail(err)
+		}
+		value, err := readSecretValue(name)
+		if err != nil {
+			return fail(err)
+		}
+		if value == "" {
+			return fail(fmt.Errorf("the value of %s is empty", name))
+		}
+		store.Secrets[name] = seal(aead, name, value)
+		if err := writeSecrets(store); err != nil {
+			return fail(err)
+		}
+		print("Saved secret %s, use it in commands as {secret:%s}\n", name, name)
+		return 0
+	case SECRETDELETE:
+		if len(args) != 2 {
+			return usage("usage: %s %s NAME", SECRET, SECRETDELETE)
+		}
+		if _, ok := store.Secrets[args[1]]; !ok {
+			return usage("unknown secret \"%s\"", args[1])
+		}
+		delete(store.Secrets, args[1])
+		if err := writeSecrets(store); err != nil {
+			return fail(err)
+		}
+		print("deleted the secret: %s\n", args[1])
+		return 0
+	}
+	return usage("unknown action \"%s\", expected %s, %s or %s", args[0], SECRETSET, SECRETDELETE, SECRETLIST)
+}
+
+// secretEnv prefixes the environment variables passing the secrets to the
+// command.
+var secretEnv = "SD_SECRET_"
+
+// referSecrets replaces the {secret:NAME} placeholders of cmd with a
+// reference to the variable ref(NAME), quoted for where the placeholder
+// stands, so the value is never split or expanded by the shell. Single
+// quotes are closed around the reference and reopened after it.
+func referSecrets(cmd string, ref func(name string) string) string {
+	var out strings.Builder
+	var quote byte
+	for i := 0; i < len(cmd); {
+		if strings.HasPrefix(cmd[i:], "{secret:") {
+			if loc := rSecret.FindStringSubmatchIndex(cmd[i:]); loc != nil && loc[0] == 0 {
+				variable := ref(cmd[i+loc[2] : i+loc[3]])
+				switch quote {
+				case '"':
+					out.WriteString("${" + variable + "}")
+				case '\'':
+					out.WriteString(`'"$` + variable + `"'`)
+				default:
+					out.WriteString(`"$` + variable + `"`)
+				}
+				i += loc[1]
+				continue
+			}
+		}
+		c := cmd[i]
+		switch {
+		case c == '\\' && quote != '\'' && i+1 < len(cmd):
+			out.WriteString(cmd[i : i+2])
+			i += 2
+			continue
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case c == quote:
+			quote = 0
+		}
+		out.WriteByte(c)
+		i++
+	}
+	return out.String()
+}
+
+// resolveSecrets decrypts the secrets of the {secret:NAME} placeholders of
+// cmd, only asking for the passphrase when there are some. It returns cmd
+// referring to them as $SD_SECRET_N, and the variables to set as N=VALUE:
+// the values are neither interpreted by the shell nor seen in the arguments
+// of the process.
+func resolveSecrets(cmd string) (string, []string, error) {
+	matches := rSecret.FindAllStringSubmatch(cmd, -1)
+	if len(matches) == 0 {
+		return cmd, nil, nil
+	}
+	store, err := readSecrets()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, match := range matches {
+		if _, ok := store.Secrets[match[1]]; !ok {
+			return "", nil, fmt.Errorf("unknown secret %s, set it with sd %s %s %s", match[1], SECRET, SECRETSET, match[1])
+		}
+	}
+	aead, err := store.unlock()
+	if err != nil {
+		return "", nil, err
+	}
+	variables := map[string]string{}
+	var env []string
+	var failure error
+	cmd = referSecrets(cmd, func(name string) string {
+		if variable, ok := variables[name]; ok {
+			return variable
+		}
+		value, err := unseal(aead, name, store.Secrets[name])
+		if err != nil && failure == nil {
+			failure = err
+		}
+		variable := secretEnv + strconv.Itoa(len(variables)+1)
+		variables[name] = variable
+		env = append(env, variable+"="+value)
+		return variable
+	})
+	if failure != nil {
+		return "", nil, failure
+	}
+	return cmd, env, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecrets(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	defer func(file string, iterations int) { secretsFile, secretIterations = file, iterations }(secretsFile, secretIterations)
+	secretsFile = filepath.Join(dir, ".dial_secrets")
+	secretIterations = 1000
+	passphrase := "correct horse"
+	asked := 0
+	defer func(r func(string, bool) (string, error)) { readPassphrase = r }(readPassphrase)
+	readPassphrase = func(prompt string, confirm bool) (string, error) {
+		asked++
+		return passphrase, nil
+	}
+	defer func(r func(string) (string, error)) { readSecretValue = r }(readSecretValue)
+	readSecretValue = func(name string) (string, error) { return "value of " + name, nil }
+	run := func(args ...string) string {
+		return capturePrint(func() int { return secrets(flag.NewFlagSet(SECRET, flag.ContinueOnError), args) })
+	}
+	resolve := func(cmd, with string) string {
+		passphrase, asked = with, 0
+		resolved, env, err := resolveSecrets(cmd)
+		return fmt.Sprintf("%s %q %v asked %d", resolved, env, err, asked)
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test list without secrets",
+			tInput:  []T{},
+			tFunc:   run,
+			tOutput: "0\n",
+		},
+		{
+			tName:   "Test set with an invalid name",
+			tInput:  []T{"set", "my token"},
+			tFunc:   run,
+			tOutput: "1\ncannot execute command: secret, invalid secret name \"my token\", use letters, digits, _, . and -\n",
+		},
+		{
+			tName:   "Test set",
+			tInput:  []T{"set", "TOKEN"},
+			tFunc:   run,
+			tOutput: "0\nSaved secret TOKEN, use it in commands as {secret:TOKEN}\n",
+		},
+		{
+			tName:   "Test set another",
+			tInput:  []T{"set", "db.password"},
+			tFunc:   run,
+			tOutput: "0\nSaved secret db.password, use it in commands as {secret:db.password}\n",
+		},
+		{
+			tName:  "Test secrets file is private and encrypted",
+			tInput: []T{},
+			tFunc: func() string {
+				info, _ := os.Stat(secretsFile)
+				content, _ := ioutil.ReadFile(secretsFile)
+				return fmt.Sprint(info.Mode(), strings.Contains(string(content), "value of"))
+			},
+			tOutput: "-rw------- false",
+		},
+		{
+			tName:   "Test list",
+			tInput:  []T{"list"},
+			tFunc:   run,
+			tOutput: "0\nTOKEN\ndb.password\n",
+		},
+		{
+			tName:   "Test resolve",
+			tInput:  []T{"curl -H 'Authorization: Bearer {secret:TOKEN}' -u admin:{secret:db.password} {1}", "correct horse"},
+			tFunc:   resolve,
+			tOutput: `curl -H 'Authorization: Bearer '"$SD_SECRET_1"'' -u admin:"$SD_SECRET_2" {1} ["SD_SECRET_1=value of TOKEN" "SD_SECRET_2=value of db.password"] <nil> asked 1`,
+		},
+		{
+			tName:   "Test resolve a secret used twice in double quotes",
+			tInput:  []T{`echo "token: {secret:TOKEN}, \"{secret:TOKEN}\"" 'it''s'`, "correct horse"},
+			tFunc:   resolve,
+			tOutput: `echo "token: ${SD_SECRET_1}, \"${SD_SECRET_1}\"" 'it''s' ["SD_SECRET_1=value of TOKEN"] <nil> asked 1`,
+		},
+		{
+			tName:  "Test resolved secrets are not interpreted by bash",
+			tInput: []T{"set", "TRICKY"},
+			tFunc: func(args ...string) string {
+				defer func(r func(string) (string, error)) { readSecretValue = r }(readSecretValue)
+				readSecretValue = func(string) (string, error) { return "ab$$cd ef'g\"h `id` $(id)", nil }
+				run(args...)
+				cmd, env, err := resolveSecrets(`printf '%s|' {secret:TRICKY} "{secret:TRICKY}" '<{secret:TRICKY}>'`)
+				if err != nil {
+					return err.Error()
+				}
+				c := exec.Command("bash", "-c", cmd)
+				c.Env = append(os.Environ(), env...)
+				out, err := c.CombinedOutput()
+				return fmt.Sprint(string(out), err, strings.Contains(cmd, "ab$$"))
+			},
+			tOutput: "ab$$cd ef'g\"h `id` $(id)|ab$$cd ef'g\"h `id` $(id)|<ab$$cd ef'g\"h `id` $(id)>|<nil> false",
+		},
+		{
+			tName:   "Test resolve without secrets does not ask for the passphrase",
+			tInput:  []T{"echo {1}", "wrong"},
+			tFunc:   resolve,
+			tOutput: "echo {1} [] <nil> asked 0",
+		},
+		{
+			tName:   "Test resolve with a wrong passphrase",
+			tInput:  []T{"echo {secret:TOKEN}", "wrong"},
+			tFunc:   resolve,
+			tOutput: " [] wrong passphrase asked 1",
+		},
+		{
+			tName:   "Test resolve an unknown secret",
+			tInput:  []T{"echo {secret:OTHER}", "correct horse"},
+			tFunc:   resolve,
+			tOutput: " [] unknown secret OTHER, set it with sd secret set OTHER asked 0",
+		},
+		{
+			tName:  "Test resolve secrets swapped in the file",
+			tInput: []T{"echo {secret:TOKEN}", "correct horse"},
+			tFunc: func(cmd, with string) string {
+				store, _ := readSecrets()
+				store.Secrets["TOKEN"] = store.Secrets["db.password"]
+				writeSecrets(store)
+				return resolve(cmd, with)
+			},
+			tOutput: " [] the secret TOKEN is corrupted asked 1",
+		},
+		{
+			tName:   "Test delete",
+			tInput:  []T{"delete", "TOKEN"},
+			tFunc:   run,
+			tOutput: "0\ndeleted the secret: TOKEN\n",
+		},
+		{
+			tName:   "Test delete an unknown secret",
+			tInput:  []T{"delete", "TOKEN"},
+			tFunc:   run,
+			tOutput: "1\ncannot execute command: secret, unknown secret \"TOKEN\"\n",
+		},
+		{
+			tName:  "Test set failing to read the passphrase",
+			tInput: []T{"set", "TOKEN"},
+			tFunc: func(args ...string) string {
+				defer func(r func(string, bool) (string, error)) { readPassphrase = r }(readPassphrase)
+				readPassphrase = func(string, bool) (string, error) { return "", errors.New("cancelled") }
+				return run(args...)
+			},
+			tOutput: "1\ncannot execute command: secret, cancelled\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestReadHiddenLine(t *testing.T) {
+	read := func(input string) string {
+		line, err := readHiddenLine(bufio.NewReader(strings.NewReader(input)))
+		return fmt.Sprintf("%q %v", line, err)
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test line with backspace",
+			tInput:  []T{"secrex\x7ft\rignored"},
+			tFunc:   read,
+			tOutput: `"secret" <nil>`,
+		},
+		{
+			tName:   "Test cancelled line",
+			tInput:  []T{"sec\x03"},
+			tFunc:   read,
+			tOutput: `"" cancelled`,
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestWriteKeyFileIsPrivate(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, ".dial_keys")
+	ioutil.WriteFile(file, []byte(`{}`), 0644)
+	tt := []ttFStruct{
+		{
+			tName:  "Test existing world readable key file becomes private",
+			tInput: []T{file},
+			tFunc: func(file string) string {
+				writeKeyFile(file, map[string]entry{"a": {Cmd: "echo a"}})
+				info, _ := os.Stat(file)
+				return info.Mode().String()
+			},
+			tOutput: "-rw-------",
+		},
+	}
+	testPackageMethod(tt, t)
+}
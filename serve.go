@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var SERVE = "serve"
+
+// keyServer exposes the key file over a REST API:
+//
+//	GET    /keys       all keys
+//	PUT    /keys       replace all keys, the removed ones going to the trash
+//	GET    /keys/KEY   a single key
+//	PUT    /keys/KEY   save a single key
+//	DELETE /keys/KEY   delete a single key
+//
+// Responses carry an ETag, requests changing keys may send it back in
+// If-Match to only succeed when nobody changed the keys in between.
+type keyServer struct {
+	mu    sync.Mutex
+	token string
+	// secrets is the policy for likely credentials in the commands sent,
+	// refuse or warn as nobody can be asked for the passphrase of the
+	// secrets.
+	secrets string
+}
+
+func etag(v interface{}) string {
+	content, _ := json.Marshal(v)
+	sum := sha256.Sum256(content)
+	return "\"" + hex.EncodeToString(sum[:8]) + "\""
+}
+
+func writeJSON(w http.ResponseWriter, status int, tag string, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if tag != "" {
+		w.Header().Set("ETag", tag)
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, a ...interface{}) {
+	writeJSON(w, status, "", map[string]string{"error": fmt.Sprintf(format, a...)})
+}
+
+// maxRequestSize limits the body of the requests.
+var maxRequestSize int64 = 1 << 20
+
+// decodeBody decodes the JSON body of r into v, answering the request with
+// the error when it cannot.
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(v)
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, "the request is larger than %d bytes", tooLarge.Limit)
+	case err != nil:
+		writeError(w, http.StatusBadRequest, "%v", err)
+	}
+	return err == nil
+}
+
+func (s *keyServer) authorized(r *http.Request) bool {
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) == 1
+}
+
+func (s *keyServer) readKeys() (map[string]entry, error) {
+	if !fileExists() {
+		return map[string]entry{}, nil
+	}
+	return readKeyFile(keyFile)
+}
+
+// matches reports whether the If-Match and If-None-Match preconditions of
+// the request hold for a resource with the given ETag, empty if missing.
+func matches(r *http.Request, tag string) bool {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != tag && !(ifMatch == "*" && tag != "") {
+		return false
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && (ifNoneMatch == tag || (ifNoneMatch == "*" && tag != "")) {
+		return false
+	}
+	return true
+}
+
+func validEntry(key string, e entry) error {
+	if key == "" {
+		return fmt.Errorf("invalid key \"%s\"", key)
+	}
+	if err := validKey(key); err != nil {
+		return fmt.Errorf("invalid key \"%s\", it %v", key, err)
+	}
+	if e.Cmd == "" {
+		return fmt.Errorf("the command of key \"%s\" is empty", key)
+	}
+	if !isValidSave(e.Cmd) {
+		return fmt.Errorf("the command of key \"%s\" contains a default argument preceeding a regular argument", key)
+	}
+	specs := make([]string, 0, len(e.Args))
+	for idx, source := range e.Args {
+		specs = append(specs, fmt.Sprintf("%d=%s", idx, source))
+	}
+	sort.Strings(specs)
+	if _, err := parseArgSources(specs, e.Cmd); err != nil {
+		return fmt.Errorf("key \"%s\": %v", key, err)
+	}
+	return nil
+}
+
+func (s *keyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sdMap, err := s.readKeys()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	if r.URL.Path == "/keys" {
+		s.serveKeys(w, r, sdMap)
+		return
+	}
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/keys/"))
+	if !strings.HasPrefix(r.URL.Path, "/keys/") || err != nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	s.serveKey(w, r, sdMap, key)
+}
+
+// replaceKeys returns updated replacing sdMap like save and delete would:
+// removed keys go to the trash, and changed keys keep their previous value
+// in their history. The times and authors of the keys are the ones sent by
+// the client, a merged key keeping when and by whom it was saved.
+func replaceKeys(sdMap, updated map[string]entry) (map[string]entry, error) {
+	replaced := map[string]entry{}
+	for key, e := range updated {
+		if previous, exists := sdMap[key]; exists {
+			e = mergeHistory(e, previous)
+		}
+		replaced[key] = e
+	}
+	for key, e := range sdMap {
+		if _, exists := replaced[key]; !exists {
+			if err := trashKey(key, e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return replaced, nil
+}
+
+func (s *keyServer) serveKeys(w http.ResponseWriter, r *http.Request, sdMap map[string]entry) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, etag(sdMap), sdMap)
+	case http.MethodPut:
+		if !matches(r, etag(sdMap)) {
+			writeError(w, http.StatusPreconditionFailed, "the keys were changed in the meantime")
+			return
+		}
+		updated := map[string]entry{}
+		if !decodeBody(w, r, &updated) {
+			return
+		}
+		for key, e := range updated {
+			if err := validEntry(key, e); err != nil {
+				writeError(w, http.StatusBadRequest, "%v", err)
+				return
+			}
+		}
+		if !s.acceptSecrets(w, sdMap, updated) {
+			return
+		}
+		updated, err := replaceKeys(sdMap, updated)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		writeFile(updated)
+		commitKeys("Update the keys over HTTP")
+		writeJSON(w, http.StatusOK, etag(updated), updated)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+}
+
+// acceptSecrets reports whether the keys of updated new or changed since
+// sdMap may be saved, answering the request when their commands hold likely
+// credentials, which are only logged with the warn policy.
+func (s *keyServer) acceptSecrets(w http.ResponseWriter, sdMap, updated map[string]entry) bool {
+	incoming := incomingKeys(sdMap, updated)
+	if s.secrets == SECRETSWARN {
+		return guardSecrets(SERVE, incoming, SECRETSWARN)
+	}
+	found := scanKeys(incoming, false)
+	if len(found) == 0 {
+		return true
+	}
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	writeError(w, http.StatusUnprocessableEntity, "likely secrets in the commands of %s, use {secret:NAME} placeholders instead", strings.Join(keys, ", "))
+	return false
+}
+
+func (s *keyServer) serveKey(w http.ResponseWriter, r *http.Request, sdMap map[string]entry, key string) {
+	e, exists := sdMap[key]
+	tag := ""
+	if exists {
+		tag = etag(e)
+	}
+	if r.Method != http.MethodGet && !matches(r, tag) {
+		writeError(w, http.StatusPreconditionFailed, "the key \"%s\" was changed in the meantime", key)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			writeError(w, http.StatusNotFound, "unknown key \"%s\"", key)
+			return
+		}
+		writeJSON(w, http.StatusOK, tag, e)
+	case http.MethodPut:
+		var updated entry
+		if !decodeBody(w, r, &updated) {
+			return
+		}
+		if err := validEntry(key, updated); err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		ours := map[string]entry{}
+		if exists {
+			ours[key] = e
+		}
+		if !s.acceptSecrets(w, ours, map[string]entry{key: updated}) {
+			return
+		}
+		updated.Updated = timestamp()
+		updated = recordRevision(e, updated)
+		sdMap[key] = updated
+		writeFile(sdMap)
+		commitKeys("Save " + key)
+		status := http.StatusOK
+		if !exists {
+			status = http.StatusCreated
+		}
+		writeJSON(w, status, etag(updated), updated)
+	case http.MethodDelete:
+		if !exists {
+			writeError(w, http.StatusNotFound, "unknown key \"%s\"", key)
+			return
+		}
+		if err := trashKey(key, e); err != nil {
+			writeError(w, http.StatusInternalServerError, "%v", err)
+			return
+		}
+		delete(sdMap, key)
+		writeFile(sdMap)
+		commitKeys("Delete " + key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+}
+
+// listenAndServe serves handler on addr, with timeouts so that slow or
+// stalled clients cannot hold connections open.
+var listenAndServe = func(addr string, handler http.Handler) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	return server.ListenAndServe()
+}
+
+func serve(command *flag.FlagSet, addr, token, secretPolicy string) int {
+	if token == "" {
+		token = os.Getenv("SD_TOKEN")
+	}
+	if token == "" {
+		print("cannot execute command: %s, a token is required, use -token or SD_TOKEN\n", SERVE)
+		command.PrintDefaults()
+		return 1
+	}
+	if !isValidFormat(secretPolicy, SECRETSREFUSE, SECRETSWARN) {
+		print("unknown secrets policy \"%s\", expected one of: refuse, warn\n", secretPolicy)
+		return 1
+	}
+	print("serving %s on %s\n", keyFile, addr)
+	if err := listenAndServe(addr, &keyServer{token: token, secrets: secretPolicy}); err != nil {
+		print("cannot execute command: %s, %v\n", SERVE, err)
+		return 1
+	}
+	return 0
+}
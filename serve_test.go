@@ -0,0 +1,248 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServe(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	defer func(file, sync string) { keyFile, syncDir = file, sync }(keyFile, syncDir)
+	keyFile = filepath.Join(dir, ".dial_keys")
+	syncDir = filepath.Join(dir, ".dial_keys.sync")
+	ioutil.WriteFile(keyFile, []byte(`{"a":"echo a"}`), 0644)
+	defer func(write func(map[string]entry)) { writeFile = write }(writeFile)
+	writeFile = func(sdMap map[string]entry) { writeKeyFile(keyFile, sdMap) }
+	defer func(n func() time.Time) { now = n }(now)
+	now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+	server := httptest.NewServer(&keyServer{token: "secret", secrets: SECRETSREFUSE})
+	defer server.Close()
+	// tags holds the ETags of the previous responses, by path.
+	tags := map[string]string{}
+	request := func(method, path, token, precondition, body string) string {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		switch precondition {
+		case "":
+		case "*":
+			req.Header.Set("If-None-Match", "*")
+		case "last":
+			req.Header.Set("If-Match", tags[path])
+		default:
+			req.Header.Set("If-Match", precondition)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err.Error()
+		}
+		defer resp.Body.Close()
+		tags[path] = resp.Header.Get("ETag")
+		content, _ := ioutil.ReadAll(resp.Body)
+		return strings.TrimSpace(resp.Status + " " + string(content))
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test request without token",
+			tInput:  []T{"GET", "/keys", "", "", ""},
+			tFunc:   request,
+			tOutput: `401 Unauthorized {"error":"missing or invalid token"}`,
+		},
+		{
+			tName:   "Test request with a wrong token",
+			tInput:  []T{"GET", "/keys", "guess", "", ""},
+			tFunc:   request,
+			tOutput: `401 Unauthorized {"error":"missing or invalid token"}`,
+		},
+		{
+			tName:   "Test list",
+			tInput:  []T{"GET", "/keys", "secret", "", ""},
+			tFunc:   request,
+			tOutput: `200 OK {"a":"echo a"}`,
+		},
+		{
+			tName:   "Test get",
+			tInput:  []T{"GET", "/keys/a", "secret", "", ""},
+			tFunc:   request,
+			tOutput: `200 OK "echo a"`,
+		},
+		{
+			tName:   "Test get of an unknown key",
+			tInput:  []T{"GET", "/keys/b", "secret", "", ""},
+			tFunc:   request,
+			tOutput: `404 Not Found {"error":"unknown key \"b\""}`,
+		},
+		{
+			tName:   "Test put of a new key",
+			tInput:  []T{"PUT", "/keys/k8s%2Fpods", "secret", "*", `{"cmd":"kubectl get pods","desc":"pods"}`},
+			tFunc:   request,
+			tOutput: `201 Created {"cmd":"kubectl get pods","desc":"pods","updated":"2020-01-02T03:04:05Z"}`,
+		},
+		{
+			tName:   "Test put of an existing key refused by If-None-Match",
+			tInput:  []T{"PUT", "/keys/a", "secret", "*", `{"cmd":"echo b"}`},
+			tFunc:   request,
+			tOutput: `412 Precondition Failed {"error":"the key \"a\" was changed in the meantime"}`,
+		},
+		{
+			tName:   "Test put with a stale ETag",
+			tInput:  []T{"PUT", "/keys/a", "secret", `"stale"`, `{"cmd":"echo b"}`},
+			tFunc:   request,
+			tOutput: `412 Precondition Failed {"error":"the key \"a\" was changed in the meantime"}`,
+		},
+		{
+			tName:   "Test put with an invalid command",
+			tInput:  []T{"PUT", "/keys/a", "secret", "", `{"cmd":""}`},
+			tFunc:   request,
+			tOutput: `400 Bad Request {"error":"the command of key \"a\" is empty"}`,
+		},
+		{
+			tName:   "Test put of a reserved key",
+			tInput:  []T{"PUT", "/keys/list", "secret", "", `{"cmd":"ls"}`},
+			tFunc:   request,
+			tOutput: `400 Bad Request {"error":"invalid key \"list\", it is a reserved subcommand name"}`,
+		},
+		{
+			tName:   "Test put of a key holding a likely secret",
+			tInput:  []T{"PUT", "/keys/db", "secret", "", `{"cmd":"mysql -u root -phunter22 db"}`},
+			tFunc:   request,
+			tOutput: `422 Unprocessable Entity {"error":"likely secrets in the commands of db, use {secret:NAME} placeholders instead"}`,
+		},
+		{
+			tName:   "Test get before update",
+			tInput:  []T{"GET", "/keys/a", "secret", "", ""},
+			tFunc:   request,
+			tOutput: `200 OK "echo a"`,
+		},
+		{
+			tName:   "Test put with the current ETag",
+			tInput:  []T{"PUT", "/keys/a", "secret", "last", `{"cmd":"echo A"}`},
+			tFunc:   request,
+			tOutput: `200 OK {"cmd":"echo A","updated":"2020-01-02T03:04:05Z","history":[{"cmd":"echo a"}]}`,
+		},
+		{
+			tName:   "Test delete with the current ETag",
+			tInput:  []T{"DELETE", "/keys/a", "secret", "last", ""},
+			tFunc:   request,
+			tOutput: `204 No Content`,
+		},
+		{
+			tName:   "Test delete of a deleted key",
+			tInput:  []T{"DELETE", "/keys/a", "secret", "", ""},
+			tFunc:   request,
+			tOutput: `404 Not Found {"error":"unknown key \"a\""}`,
+		},
+		{
+			tName:   "Test list after changes",
+			tInput:  []T{"GET", "/keys", "secret", "", ""},
+			tFunc:   request,
+			tOutput: `200 OK {"k8s/pods":{"cmd":"kubectl get pods","desc":"pods","updated":"2020-01-02T03:04:05Z"}}`,
+		},
+		{
+			tName:   "Test replace all keys with a flag-like key",
+			tInput:  []T{"PUT", "/keys", "secret", "", `{"-x":"ls"}`},
+			tFunc:   request,
+			tOutput: `400 Bad Request {"error":"invalid key \"-x\", it starts with -"}`,
+		},
+		{
+			tName:   "Test replace all keys with a too large request",
+			tInput:  []T{"PUT", "/keys", "secret", "", `{"b":"` + strings.Repeat("b", 1<<20) + `"}`},
+			tFunc:   request,
+			tOutput: `413 Request Entity Too Large {"error":"the request is larger than 1048576 bytes"}`,
+		},
+		{
+			tName:   "Test list before replacing all keys",
+			tInput:  []T{"GET", "/keys", "secret", "", ""},
+			tFunc:   request,
+			tOutput: `200 OK {"k8s/pods":{"cmd":"kubectl get pods","desc":"pods","updated":"2020-01-02T03:04:05Z"}}`,
+		},
+		{
+			tName:   "Test replace all keys with the current ETag",
+			tInput:  []T{"PUT", "/keys", "secret", "last", `{"b":"echo b"}`},
+			tFunc:   request,
+			tOutput: `200 OK {"b":"echo b"}`,
+		},
+		{
+			tName:   "Test replaced key is in the trash",
+			tInput:  []T{},
+			tFunc:   func() string { items, _ := readTrash(); return items["k8s/pods"].Entry.Cmd },
+			tOutput: "kubectl get pods",
+		},
+		{
+			tName:   "Test replace all keys changing a key",
+			tInput:  []T{"PUT", "/keys", "secret", "last", `{"b":"echo B"}`},
+			tFunc:   request,
+			tOutput: `200 OK {"b":{"cmd":"echo B","history":[{"cmd":"echo b"}]}}`,
+		},
+		{
+			tName:   "Test replace all keys adding a likely secret",
+			tInput:  []T{"PUT", "/keys", "secret", "", `{"b":"echo B","db":"mysql -u root -phunter22 db"}`},
+			tFunc:   request,
+			tOutput: `422 Unprocessable Entity {"error":"likely secrets in the commands of db, use {secret:NAME} placeholders instead"}`,
+		},
+		{
+			tName:   "Test replace all keys with a stale ETag",
+			tInput:  []T{"PUT", "/keys", "secret", `"stale"`, `{"c":"echo c"}`},
+			tFunc:   request,
+			tOutput: `412 Precondition Failed {"error":"the keys were changed in the meantime"}`,
+		},
+		{
+			tName:   "Test unsupported method",
+			tInput:  []T{"POST", "/keys", "secret", "", ""},
+			tFunc:   request,
+			tOutput: `405 Method Not Allowed {"error":"method POST not allowed"}`,
+		},
+		{
+			tName:   "Test unknown path",
+			tInput:  []T{"GET", "/other", "secret", "", ""},
+			tFunc:   request,
+			tOutput: `404 Not Found {"error":"not found"}`,
+		},
+		{
+			tName:   "Test key file after changes",
+			tInput:  []T{keyFile},
+			tFunc:   func(file string) string { content, _ := ioutil.ReadFile(file); return string(content) },
+			tOutput: `{"b":{"cmd":"echo B","history":[{"cmd":"echo b"}]}}`,
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestServeToken(t *testing.T) {
+	defer os.Setenv("SD_TOKEN", os.Getenv("SD_TOKEN"))
+	os.Unsetenv("SD_TOKEN")
+	defer func(l func(string, http.Handler) error) { listenAndServe = l }(listenAndServe)
+	listenAndServe = func(addr string, handler http.Handler) error { return http.ErrServerClosed }
+	tt := []ttFStruct{
+		{
+			tName:       "Test serve without token",
+			tInput:      []T{flag.NewFlagSet(SERVE, flag.ContinueOnError), ":8080", "", SECRETSREFUSE},
+			tFunc:       serve,
+			tOutput:     1,
+			tPipeOutput: "cannot execute command: serve, a token is required, use -token or SD_TOKEN\n",
+		},
+		{
+			tName:       "Test serve moving secrets",
+			tInput:      []T{flag.NewFlagSet(SERVE, flag.ContinueOnError), ":8080", "secret", SECRETSMOVE},
+			tFunc:       serve,
+			tOutput:     1,
+			tPipeOutput: "unknown secrets policy \"move\", expected one of: refuse, warn\n",
+		},
+		{
+			tName:  "Test serve failing to listen",
+			tInput: []T{":8080", "secret"},
+			tFunc: func(addr, token string) string {
+				return capturePrint(func() int { return serve(nil, addr, token, SECRETSREFUSE) })
+			},
+			tOutput: "1\nserving " + keyFile + " on :8080\ncannot execute command: serve, http: Server closed\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
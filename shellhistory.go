@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	rZshExtended  = regexp.MustCompile(`^: \d+:\d+;`)
+	rBashTime     = regexp.MustCompile(`^#\d+$`)
+	rFishMetadata = regexp.MustCompile(`^\s+(when|paths):|^\s+- `)
+)
+
+// currentShell returns the name of the login shell, bash by default.
+func currentShell() string {
+	switch shell := filepath.Base(os.Getenv("SHELL")); shell {
+	case ZSH, FISH:
+		return shell
+	}
+	return BASH
+}
+
+// historyFile returns the history file of shell, $HISTFILE when set.
+func historyFile(shell string) string {
+	if file := os.Getenv("HISTFILE"); file != "" {
+		return file
+	}
+	switch shell {
+	case ZSH:
+		return filepath.Join(getHomeDir(), ".zsh_history")
+	case FISH:
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		if dataHome == "" {
+			dataHome = filepath.Join(getHomeDir(), ".local", "share")
+		}
+		return filepath.Join(dataHome, "fish", "fish_history")
+	}
+	return filepath.Join(getHomeDir(), ".bash_history")
+}
+
+// unmetafy decodes the bytes zsh escapes in its history file, as 0x83
+// followed by the byte xored with 32.
+func unmetafy(content []byte) []byte {
+	decoded := make([]byte, 0, len(content))
+	for i := 0; i < len(content); i++ {
+		if content[i] == 0x83 && i+1 < len(content) {
+			i++
+			decoded = append(decoded, content[i]^32)
+			continue
+		}
+		decoded = append(decoded, content[i])
+	}
+	return decoded
+}
+
+// parseShellHistory returns the commands of a bash, zsh (plain or extended)
+// or fish history, oldest first.
+func parseShellHistory(content, shell string) []string {
+	var commands []string
+	continued := false
+	for _, line := range strings.Split(strings.Replace(content, "\r\n", "\n", -1), "\n") {
+		if continued {
+			// zsh keeps the lines of a multi-line command ending with \.
+			commands[len(commands)-1] += "\n" + strings.TrimSuffix(line, `\`)
+			continued = strings.HasSuffix(line, `\`)
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "- cmd: "):
+			line = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(strings.TrimPrefix(line, "- cmd: "))
+		case rZshExtended.MatchString(line):
+			line = rZshExtended.ReplaceAllString(line, "")
+		case rBashTime.MatchString(line), shell == FISH && rFishMetadata.MatchString(line), strings.TrimSpace(line) == "":
+			continue
+		}
+		if continued = shell == ZSH && strings.HasSuffix(line, `\`); continued {
+			line = strings.TrimSuffix(line, `\`)
+		}
+		commands = append(commands, line)
+	}
+	return commands
+}
+
+// readShellHistory returns the commands of a history file of shell, oldest
+// first.
+func readShellHistory(file, shell string) ([]string, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if shell == ZSH {
+		content = unmetafy(content)
+	}
+	return parseShellHistory(string(content), shell), nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseShellHistory(t *testing.T) {
+	parse := func(content, shell string) string {
+		return fmt.Sprintf("%q", parseShellHistory(content, shell))
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test bash history with timestamps",
+			tInput:  []T{"ls -la\n#1700000000\ngit status\n\necho a \\\n", BASH},
+			tFunc:   parse,
+			tOutput: `["ls -la" "git status" "echo a \\"]`,
+		},
+		{
+			tName:   "Test zsh extended history with a multi-line command",
+			tInput:  []T{": 1700000000:0;ls -la\n: 1700000001:2;for i in 1 2; do\\\necho $i\\\ndone\nplain\n", ZSH},
+			tFunc:   parse,
+			tOutput: `["ls -la" "for i in 1 2; do\necho $i\ndone" "plain"]`,
+		},
+		{
+			tName:   "Test fish history",
+			tInput:  []T{"- cmd: ls -la\n  when: 1700000000\n- cmd: cat a\\nb \\\\n\n  when: 1700000001\n  paths:\n    - a\n", FISH},
+			tFunc:   parse,
+			tOutput: `["ls -la" "cat a\nb \\n"]`,
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestReadShellHistory(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	read := func(content, shell string) string {
+		ioutil.WriteFile(filepath.Join(dir, "history"), []byte(content), 0600)
+		commands, err := readShellHistory(filepath.Join(dir, "history"), shell)
+		return fmt.Sprintf("%q %v", commands, err)
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test zsh history is unmetafied",
+			tInput:  []T{": 1700000000:0;echo \xc4\x83\xa3\n", ZSH},
+			tFunc:   read,
+			tOutput: `["echo ă"] <nil>`,
+		},
+		{
+			tName:   "Test bash history is read as is",
+			tInput:  []T{"echo ă\n", BASH},
+			tFunc:   read,
+			tOutput: `["echo ă"] <nil>`,
+		},
+	}
+	testPackageMethod(tt, t)
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var knownHostsFile = getHomeDir() + string(os.PathSeparator) + ".ssh" + string(os.PathSeparator) + "known_hosts"
+
+// sshOptions are the options of ~/.ssh/config applying to a host, the
+// first value given for an option winning as with OpenSSH.
+type sshOptions struct {
+	hostName              string
+	user                  string
+	port                  string
+	proxyJump             string
+	strictHostKeyChecking string
+	identityFiles         []string
+	knownHostsFiles       []string
+}
+
+// readSSHOptions reads the options of configFile, and of the files it
+// includes, for host. Match blocks are not supported and skipped.
+func readSSHOptions(configFile, host string) sshOptions {
+	o := sshOptions{}
+	o.read(configFile, strings.ToLower(host), 0)
+	if o.hostName != "" {
+		o.hostName = expandSSHPath(o.hostName, host)
+	}
+	return o
+}
+
+func (o *sshOptions) read(file, host string, depth int) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	applies := true
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(strings.Replace(strings.TrimSpace(scanner.Text()), "=", " ", 1))
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.Trim(fields[i], `"`)
+		}
+		switch keyword, args := strings.ToLower(fields[0]), fields[1:]; keyword {
+		case "host":
+			applies = matchSSHHost(host, args)
+		case "match":
+			applies = len(args) == 1 && strings.ToLower(args[0]) == "all"
+		case "include":
+			if !applies || depth > 8 {
+				continue
+			}
+			for _, pattern := range args {
+				pattern = expandSSHPath(pattern, host)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(filepath.Dir(sshConfigFile), pattern)
+				}
+				included, _ := filepath.Glob(pattern)
+				for _, name := range included {
+					o.read(name, host, depth+1)
+				}
+			}
+		default:
+			if applies {
+				o.set(keyword, args, host)
+			}
+		}
+	}
+}
+
+func (o *sshOptions) set(keyword string, args []string, host string) {
+	first := func(option *string) {
+		if *option == "" {
+			*option = args[0]
+		}
+	}
+	switch keyword {
+	case "hostname":
+		first(&o.hostName)
+	case "user":
+		first(&o.user)
+	case "port":
+		first(&o.port)
+	case "proxyjump":
+		first(&o.proxyJump)
+	case "stricthostkeychecking":
+		first(&o.strictHostKeyChecking)
+	case "identityfile":
+		o.identityFiles = append(o.identityFiles, expandSSHPath(args[0], host))
+	case "userknownhostsfile":
+		if o.knownHostsFiles == nil {
+			for _, file := range args {
+				o.knownHostsFiles = append(o.knownHostsFiles, expandSSHPath(file, host))
+			}
+		}
+	}
+}
+
+// matchSSHHost reports whether host matches the patterns of a Host line,
+// a negated pattern excluding it whatever the others.
+func matchSSHHost(host string, patterns []string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		ok, _ := path.Match(strings.ToLower(strings.TrimPrefix(pattern, "!")), host)
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+// expandSSHPath expands ~ and the %d (home directory), %h (host), %u
+// (local user) and %% tokens of an option.
+func expandSSHPath(value, host string) string {
+	if value == "~" || strings.HasPrefix(value, "~/") {
+		value = getHomeDir() + value[1:]
+	}
+	return strings.NewReplacer("%d", getHomeDir(), "%h", host, "%u", localUser(), "%%", "%").Replace(value)
+}
+
+func localUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// sshHop is a host to connect to, the last of a chain of jump hosts.
+type sshHop struct {
+	name    string
+	user    string
+	addr    string
+	options sshOptions
+}
+
+// resolveSSHHop resolves [user@]host[:port] with the options of
+// ~/.ssh/config, user and port taking precedence over them.
+func resolveSSHHop(spec, user, port string) sshHop {
+	host := spec
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		user, host = host[:i], host[i+1:]
+	}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	o := readSSHOptions(sshConfigFile, host)
+	hop := sshHop{name: host, user: user, options: o}
+	if hop.user == "" {
+		hop.user = o.user
+	}
+	if hop.user == "" {
+		hop.user = localUser()
+	}
+	hostName := host
+	if o.hostName != "" {
+		hostName = o.hostName
+	}
+	if port == "" {
+		port = o.port
+	}
+	if port == "" {
+		port = "22"
+	}
+	hop.addr = net.JoinHostPort(hostName, port)
+	return hop
+}
+
+// sshHops returns the jump hosts of r, given by -jump or else by the
+// ProxyJump option, followed by r itself.
+func (r remote) sshHops() []sshHop {
+	target := resolveSSHHop(r.sshAlias, "", r.port)
+	if r.ip != "" {
+		target = resolveSSHHop(r.ip, r.user, r.port)
+	}
+	jumps := r.jump
+	if jumps == "" && target.options.proxyJump != "none" {
+		jumps = target.options.proxyJump
+	}
+	var hops []sshHop
+	for _, jump := range strings.Split(jumps, ",") {
+		if jump = strings.TrimSpace(jump); jump != "" {
+			hops = append(hops, resolveSSHHop(jump, "", ""))
+		}
+	}
+	return append(hops, target)
+}
+
+// readSSHSecret asks for the passphrase of a private key or a password on
+// the terminal.
+var readSSHSecret = readHidden
+
+// sshSecrets keeps the keys unlocked and the passwords typed, so they are
+// asked for once however many connections are made.
+var sshSecrets = struct {
+	sync.Mutex
+	signers   map[string]ssh.Signer
+	passwords map[string]string
+}{signers: map[string]ssh.Signer{}, passwords: map[string]string{}}
+
+// trustHost asks whether to trust the unknown key of host, which is then
+// added to the known hosts as ssh does.
+var trustHost = func(host string, key ssh.PublicKey) (bool, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer tty.Close()
+	fmt.Fprintf(tty, "The authenticity of host '%s' can't be established.\n%s key fingerprint is %s.\nAre you sure you want to continue connecting (yes/no)? ", host, key.Type(), ssh.FingerprintSHA256(key))
+	answer, err := bufio.NewReader(tty).ReadString('\n')
+	return strings.ToLower(strings.TrimSpace(answer)) == "yes", err
+}
+
+// sshSigners returns the keys to authenticate with: the identity given by
+// -id, the IdentityFile options, or else the default identities, and those
+// of the agent. Keys protected by a passphrase are skipped when the agent
+// holds them, and in batch mode.
+func sshSigners(hop sshHop, identity string, batch bool, agentClient agent.ExtendedAgent) []ssh.Signer {
+	var agentKeys []*agent.Key
+	var agentSigners []ssh.Signer
+	if agentClient != nil {
+		agentKeys, _ = agentClient.List()
+		agentSigners, _ = agentClient.Signers()
+	}
+	inAgent := func(key ssh.PublicKey) bool {
+		for _, k := range agentKeys {
+			if string(k.Marshal()) == string(key.Marshal()) {
+				return true
+			}
+		}
+		return false
+	}
+	files := hop.options.identityFiles
+	if len(files) == 0 {
+		for _, name := range []string{"id_rsa", "id_ecdsa", "id_ed25519"} {
+			files = append(files, filepath.Join(getHomeDir(), ".ssh", name))
+		}
+	}
+	if identity != "" {
+		files = append([]string{identity}, files...)
+	}
+	var signers []ssh.Signer
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(content)
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) && !batch && (missing.PublicKey == nil || !inAgent(missing.PublicKey)) {
+			signer, err = unlockKey(file, content)
+		}
+		if err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	return append(signers, agentSigners...)
+}
+
+func unlockKey(file string, content []byte) (ssh.Signer, error) {
+	sshSecrets.Lock()
+	defer sshSecrets.Unlock()
+	if signer, ok := sshSecrets.signers[file]; ok {
+		return signer, nil
+	}
+	passphrase, err := readSSHSecret("Enter passphrase for key '" + file + "': ")
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKeyWithPassphrase(content, []byte(passphrase))
+	if err == nil {
+		sshSecrets.signers[file] = signer
+	}
+	return signer, err
+}
+
+func askPassword(hop sshHop) (string, error) {
+	sshSecrets.Lock()
+	defer sshSecrets.Unlock()
+	id := hop.user + "@" + hop.addr
+	if password, ok := sshSecrets.passwords[id]; ok {
+		return password, nil
+	}
+	password, err := readSSHSecret(hop.user + "@" + hop.name + "'s password: ")
+	if err == nil {
+		sshSecrets.passwords[id] = password
+	}
+	return password, err
+}
+
+// sshAuth returns the ways to authenticate to hop: keys and, unless in
+// batch mode, a password.
+func sshAuth(hop sshHop, identity string, batch bool, agentClient agent.ExtendedAgent) []ssh.AuthMethod {
+	auth := []ssh.AuthMethod{ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		return sshSigners(hop, identity, batch, agentClient), nil
+	})}
+	if batch {
+		return auth
+	}
+	return append(auth,
+		ssh.PasswordCallback(func() (string, error) { return askPassword(hop) }),
+		ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i, question := range questions {
+				answer, err := readSSHSecret(question)
+				if err != nil {
+					return nil, err
+				}
+				answers[i] = answer
+			}
+			return answers, nil
+		}))
+}
+
+// hostKeyAlgorithms returns the algorithms of the keys known for addr, so
+// that the server offers one of them, or nil when the host is unknown.
+func hostKeyAlgorithms(check ssh.HostKeyCallback, addr string) []string {
+	placeholder, _ := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	var keyErr *knownhosts.KeyError
+	if err := check(addr, &net.TCPAddr{}, placeholder); !errors.As(err, &keyErr) {
+		return nil
+	}
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		if known.Key.Type() == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algorithms = append(algorithms, known.Key.Type())
+	}
+	return algorithms
+}
+
+// hostKeyCallback checks the key of hop against the known hosts. A changed
+// key is refused. An unknown one is added once accepted, which needs
+// StrictHostKeyChecking set to accept-new or no, or else confirming it
+// when not in batch mode.
+func hostKeyCallback(hop sshHop, batch bool) (ssh.HostKeyCallback, []string, error) {
+	files := hop.options.knownHostsFiles
+	if len(files) == 0 {
+		files = []string{knownHostsFile}
+	}
+	var existing []string
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			existing = append(existing, file)
+		}
+	}
+	check := func(string, net.Addr, ssh.PublicKey) error { return &knownhosts.KeyError{} }
+	if len(existing) > 0 {
+		var err error
+		if check, err = knownhosts.New(existing...); err != nil {
+			return nil, nil, err
+		}
+	}
+	callback := func(host string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(host, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			return fmt.Errorf("the host key of %s has changed, which may be an attack, check %s:%d", hop.name, keyErr.Want[0].Filename, keyErr.Want[0].Line)
+		}
+		switch strict := strings.ToLower(hop.options.strictHostKeyChecking); {
+		case strict == "accept-new" || strict == "no":
+		case strict == "yes" || batch:
+			return fmt.Errorf("the host key of %s is not known, connect to it with ssh once to add it to %s", hop.name, files[0])
+		default:
+			if trusted, err := trustHost(hop.name, key); err != nil || !trusted {
+				return fmt.Errorf("the host key of %s was not accepted", hop.name)
+			}
+		}
+		return addKnownHost(files[0], host, key)
+	}
+	return callback, hostKeyAlgorithms(check, hop.addr), nil
+}
+
+func addKnownHost(file, host string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(host)}, key))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// dialSSH connects to r through its jump hosts, every hop being
+// authenticated with the keys of the agent and of ~/.ssh/config, and
+// checked against the known hosts. Cancelling ctx closes the connection.
+// The returned function closes it.
+func dialSSH(ctx context.Context, r remote) (*ssh.Client, func(), error) {
+	var closers []func() error
+	closeAll := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+	var agentClient agent.ExtendedAgent
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			closers = append(closers, conn.Close)
+			agentClient = agent.NewClient(conn)
+		}
+	}
+	var client *ssh.Client
+	for _, hop := range r.sshHops() {
+		callback, algorithms, err := hostKeyCallback(hop, r.batch)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		config := &ssh.ClientConfig{
+			User:              hop.user,
+			Auth:              sshAuth(hop, r.identity, r.batch, agentClient),
+			HostKeyCallback:   callback,
+			HostKeyAlgorithms: algorithms,
+		}
+		var conn net.Conn
+		if client == nil {
+			conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", hop.addr)
+			if err == nil {
+				closers = append(closers, conn.Close)
+				stop := context.AfterFunc(ctx, func() { conn.Close() })
+				closers = append(closers, func() error { stop(); return nil })
+			}
+		} else {
+			conn, err = client.DialContext(ctx, "tcp", hop.addr)
+		}
+		if err != nil {
+			closeAll()
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			return nil, nil, fmt.Errorf("cannot connect to %s: %v", hop.name, err)
+		}
+		c, chans, reqs, err := ssh.NewClientConn(conn, hop.addr, config)
+		if err != nil {
+			conn.Close()
+			closeAll()
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			return nil, nil, fmt.Errorf("cannot connect to %s: %v", hop.name, err)
+		}
+		client = ssh.NewClient(c, chans, reqs)
+		closers = append(closers, client.Close)
+	}
+	return client, closeAll, nil
+}
+
+// sshCopy copies from to to over SFTP, the remote one of them being
+// prefixed by a colon and relative to the home directory of the user.
+// Copied files get the permissions of the file copied.
+var sshCopy = func(ctx context.Context, r remote, from, to string) error {
+	client, closeSSH, err := dialSSH(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer closeSSH()
+	files, err := sftp.NewClient(client)
+	if err == nil {
+		defer files.Close()
+		if strings.HasPrefix(from, ":") {
+			err = sftpDownload(files, from[1:], to)
+		} else {
+			err = sftpUpload(files, from, to[1:])
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func sftpDownload(files *sftp.Client, from, to string) error {
+	f, err := files.Open(from)
+	if err != nil {
+		return fmt.Errorf("%s: %w", from, err)
+	}
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", from, err)
+	}
+	return ioutil.WriteFile(to, content, 0600)
+}
+
+// sftpUpload replaces the remote file to by from. It gets the permissions
+// of from before any content is written to it, existing files included,
+// the key file being readable by the user only whatever it was before.
+func sftpUpload(files *sftp.Client, from, to string) error {
+	info, err := os.Stat(from)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	f, err := files.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("%s: %w", to, err)
+	}
+	if err = f.Chmod(info.Mode().Perm()); err == nil {
+		_, err = f.Write(content)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", to, err)
+	}
+	return nil
+}
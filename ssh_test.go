@@ -0,0 +1,588 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSSHServer serves the sftp subsystem over SSH on a local port, with
+// a directory standing for the home directory, and forwards connections
+// as jump hosts do. It accepts its authorized keys and the password
+// "secret".
+type testSSHServer struct {
+	addr      string
+	hostKey   ssh.PublicKey
+	mu        sync.Mutex
+	forwarded []string
+}
+
+func startSSHServer(t *testing.T, dir string, authorized ...ssh.PublicKey) *testSSHServer {
+	_, private, _ := ed25519.GenerateKey(rand.Reader)
+	signer, _ := ssh.NewSignerFromKey(private)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, k := range authorized {
+				if bytes.Equal(k.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("unknown key")
+		},
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+	}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	s := &testSSHServer{addr: listener.Addr().String(), hostKey: signer.PublicKey()}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config, dir)
+		}
+	}()
+	return s
+}
+
+func (s *testSSHServer) port() string {
+	_, port, _ := net.SplitHostPort(s.addr)
+	return port
+}
+
+func (s *testSSHServer) jumps() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.forwarded...)
+}
+
+func (s *testSSHServer) serve(conn net.Conn, config *ssh.ServerConfig, dir string) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go func() {
+				for req := range requests {
+					var exec struct{ Command string }
+					if req.Type == "exec" && ssh.Unmarshal(req.Payload, &exec) == nil {
+						req.Reply(true, nil)
+						go runTestCommand(channel, dir, exec.Command)
+						continue
+					}
+					var subsystem struct{ Name string }
+					ok := req.Type == "subsystem" && ssh.Unmarshal(req.Payload, &subsystem) == nil && subsystem.Name == "sftp"
+					req.Reply(ok, nil)
+					if ok {
+						go func() {
+							server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(dir))
+							if err == nil {
+								server.Serve()
+							}
+							channel.Close()
+						}()
+					}
+				}
+			}()
+		case "direct-tcpip":
+			var target struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			ssh.Unmarshal(newChannel.ExtraData(), &target)
+			addr := net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port)))
+			s.mu.Lock()
+			s.forwarded = append(s.forwarded, addr)
+			s.mu.Unlock()
+			upstream, err := net.Dial("tcp", addr)
+			if err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				upstream.Close()
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			go func() {
+				io.Copy(channel, upstream)
+				channel.Close()
+			}()
+			go func() {
+				io.Copy(upstream, channel)
+				upstream.Close()
+			}()
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "")
+		}
+	}
+}
+
+// runTestCommand runs command with sh in dir, as sshd runs the commands of
+// exec requests, and sends its exit status.
+func runTestCommand(channel ssh.Channel, dir, command string) {
+	defer channel.Close()
+	c := exec.Command("sh", "-c", command)
+	c.Dir, c.Stdin, c.Stdout, c.Stderr = dir, channel, channel, channel.Stderr()
+	status := 0
+	if err := c.Run(); err != nil {
+		status = 255
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			status = exitErr.ExitCode()
+		}
+	}
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+}
+
+func TestSSHOptions(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	defer func(file string) { sshConfigFile = file }(sshConfigFile)
+	sshConfigFile = filepath.Join(dir, "config")
+	ioutil.WriteFile(sshConfigFile, []byte(`# the first value of an option wins
+Include conf.d/*
+
+Host web-* !web-old
+    HostName %h.example.com
+    User deploy
+    IdentityFile ~/.ssh/web
+
+Match exec "true"
+    User matched
+
+Host *
+    User=nobody
+    Port 2200
+    IdentityFile "~/.ssh/id_all"
+    UserKnownHostsFile ~/.ssh/hosts_a ~/.ssh/hosts_b
+`), 0600)
+	os.MkdirAll(filepath.Join(dir, "conf.d"), 0700)
+	ioutil.WriteFile(filepath.Join(dir, "conf.d", "jump"), []byte("Host db\n    ProxyJump bastion,admin@gw:2222\n    StrictHostKeyChecking accept-new\n"), 0600)
+	options := func(host string) string {
+		return fmt.Sprintf("%+v", readSSHOptions(sshConfigFile, host))
+	}
+	home := getHomeDir()
+	tt := []ttFStruct{
+		{
+			tName:   "Test options of a host matching a pattern",
+			tInput:  []T{"web-1"},
+			tFunc:   options,
+			tOutput: "{hostName:web-1.example.com user:deploy port:2200 proxyJump: strictHostKeyChecking: identityFiles:[" + home + "/.ssh/web " + home + "/.ssh/id_all] knownHostsFiles:[" + home + "/.ssh/hosts_a " + home + "/.ssh/hosts_b]}",
+		},
+		{
+			tName:   "Test options of a host excluded by a negated pattern",
+			tInput:  []T{"web-old"},
+			tFunc:   options,
+			tOutput: "{hostName: user:nobody port:2200 proxyJump: strictHostKeyChecking: identityFiles:[" + home + "/.ssh/id_all] knownHostsFiles:[" + home + "/.ssh/hosts_a " + home + "/.ssh/hosts_b]}",
+		},
+		{
+			tName:   "Test options of an included file",
+			tInput:  []T{"db"},
+			tFunc:   options,
+			tOutput: "{hostName: user:nobody port:2200 proxyJump:bastion,admin@gw:2222 strictHostKeyChecking:accept-new identityFiles:[" + home + "/.ssh/id_all] knownHostsFiles:[" + home + "/.ssh/hosts_a " + home + "/.ssh/hosts_b]}",
+		},
+	}
+	testPackageMethod(tt, t)
+
+	hops := func(r remote) string {
+		var out []string
+		for _, hop := range r.sshHops() {
+			out = append(out, hop.user+"@"+hop.addr)
+		}
+		return strings.Join(out, " -> ")
+	}
+	tt = []ttFStruct{
+		{
+			tName:   "Test hops of an alias with jump hosts",
+			tInput:  []T{remote{sshAlias: "db"}},
+			tFunc:   hops,
+			tOutput: "nobody@bastion:2200 -> admin@gw:2222 -> nobody@db:2200",
+		},
+		{
+			tName:   "Test hops with -jump, -port and -user",
+			tInput:  []T{remote{ip: "10.0.0.1", user: "me", port: "22", jump: "ops@web-1"}},
+			tFunc:   hops,
+			tOutput: "ops@web-1.example.com:2200 -> me@10.0.0.1:22",
+		},
+		{
+			tName:   "Test hops of a [user@]host alias",
+			tInput:  []T{remote{sshAlias: "root@web-2"}},
+			tFunc:   hops,
+			tOutput: "root@web-2.example.com:2200",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestSSHClient(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	defer func(file, config, hosts string) { keyFile, sshConfigFile, knownHostsFile = file, config, hosts }(keyFile, sshConfigFile, knownHostsFile)
+	keyFile = filepath.Join(dir, ".dial_keys")
+	sshConfigFile = filepath.Join(dir, "config")
+	knownHostsFile = filepath.Join(dir, "known_hosts")
+	defer func(write func(map[string]entry)) { writeFile = write }(writeFile)
+	writeFile = func(sdMap map[string]entry) { writeKeyFile(keyFile, sdMap) }
+	defer func(read func(string) (string, error)) { readSSHSecret = read }(readSSHSecret)
+	var prompts []string
+	readSSHSecret = func(prompt string) (string, error) {
+		prompts = append(prompts, prompt)
+		if strings.Contains(prompt, "password") {
+			return "secret", nil
+		}
+		return "passphrase", nil
+	}
+	defer func(trust func(string, ssh.PublicKey) (bool, error)) { trustHost = trust }(trustHost)
+	trustHost = func(host string, key ssh.PublicKey) (bool, error) { return host == "trusted", nil }
+	defer func() { sshSecrets.signers, sshSecrets.passwords = map[string]ssh.Signer{}, map[string]string{} }()
+
+	writeKey := func(name string, encrypted bool) ed25519.PrivateKey {
+		_, private, _ := ed25519.GenerateKey(rand.Reader)
+		block, _ := ssh.MarshalPrivateKey(private, "")
+		if encrypted {
+			block, _ = ssh.MarshalPrivateKeyWithPassphrase(private, "", []byte("passphrase"))
+		}
+		ioutil.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0600)
+		return private
+	}
+	public := func(private ed25519.PrivateKey) ssh.PublicKey {
+		key, _ := ssh.NewPublicKey(private.Public())
+		return key
+	}
+	plain, locked, agentKey := writeKey("id_plain", false), writeKey("id_locked", true), writeKey("id_agent", false)
+	writeKey("id_other", false)
+	remoteHome, jumpHome := filepath.Join(dir, "remote"), filepath.Join(dir, "jump")
+	os.MkdirAll(filepath.Join(remoteHome, "bin"), 0700)
+	os.MkdirAll(jumpHome, 0700)
+	server := startSSHServer(t, remoteHome, public(plain), public(locked), public(agentKey))
+	jump := startSSHServer(t, jumpHome, public(plain))
+	silent, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+	_, silentPort, _ := net.SplitHostPort(silent.Addr().String())
+
+	ioutil.WriteFile(knownHostsFile, []byte(
+		knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, server.hostKey)+"\n"+
+			knownhosts.Line([]string{knownhosts.Normalize(jump.addr)}, jump.hostKey)+"\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "known_hosts_changed"), []byte(
+		knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, jump.hostKey)+"\n"), 0600)
+	host := func(name, port string, options ...string) string {
+		return "Host " + name + "\n    HostName 127.0.0.1\n    Port " + port + "\n    User me\n    " + strings.Join(options, "\n    ") + "\n"
+	}
+	id := func(name string) string { return "IdentityFile " + filepath.Join(dir, name) }
+	ioutil.WriteFile(sshConfigFile, []byte(
+		host("box", server.port(), id("id_plain"))+
+			host("hidden", server.port(), id("id_plain"), "ProxyJump bastion")+
+			host("bastion", jump.port(), id("id_plain"))+
+			host("locked", server.port(), id("id_locked"))+
+			host("agent", server.port())+
+			host("wrong", server.port(), id("id_other"))+
+			host("password", server.port(), id("id_other"))+
+			host("changed", server.port(), id("id_plain"), "UserKnownHostsFile "+filepath.Join(dir, "known_hosts_changed"))+
+			host("stranger", server.port(), id("id_plain"), "UserKnownHostsFile "+filepath.Join(dir, "known_hosts_stranger"))+
+			host("trusted", server.port(), id("id_plain"), "UserKnownHostsFile "+filepath.Join(dir, "known_hosts_trusted"))+
+			host("new", server.port(), id("id_plain"), "UserKnownHostsFile "+filepath.Join(dir, "known_hosts_new"), "StrictHostKeyChecking accept-new")+
+			host("silent", silentPort, id("id_plain"))), 0600)
+
+	sock := filepath.Join(dir, "agent.sock")
+	agentListener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agentListener.Close()
+	keyring := agent.NewKeyring()
+	keyring.Add(agent.AddedKey{PrivateKey: agentKey})
+	go func() {
+		for {
+			conn, err := agentListener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	read := func(path ...string) string {
+		content, err := ioutil.ReadFile(filepath.Join(path...))
+		if err != nil {
+			return err.Error()
+		}
+		return string(content)
+	}
+	exported := func(r remote) string {
+		prompts = nil
+		out := capturePrint(func() int { return transferFile(r, MERGEOURS) })
+		if len(prompts) > 0 {
+			out += strings.Join(prompts, "\n") + "\n"
+		}
+		return out
+	}
+	batched := func(r remote) string {
+		r.batch = true
+		return exported(r)
+	}
+	ioutil.WriteFile(keyFile, []byte(`{"a":"echo a"}`), 0600)
+	tt := []ttFStruct{
+		{
+			tName:   "Test export creating the remote key file",
+			tInput:  []T{remote{sshAlias: "box"}},
+			tFunc:   exported,
+			tOutput: "0\nadded a: echo a\nexported 1 key(s), 1 added and 0 updated in box:.dial_keys\n",
+		},
+		{
+			tName:   "Test remote key file",
+			tInput:  []T{remoteHome, ".dial_keys"},
+			tFunc:   read,
+			tOutput: `{"a":"echo a"}`,
+		},
+		{
+			tName:  "Test remote key file created readable by its owner only",
+			tInput: []T{},
+			tFunc: func() string {
+				info, _ := os.Stat(filepath.Join(remoteHome, ".dial_keys"))
+				return info.Mode().Perm().String()
+			},
+			tOutput: "-rw-------",
+		},
+		{
+			tName: "Test pull",
+			tInput: []T{func() int {
+				ioutil.WriteFile(filepath.Join(remoteHome, ".dial_keys"), []byte(`{"a":"echo a","r":"echo r"}`), 0600)
+				return pull(nil, remote{sshAlias: "box"}, MERGEOURS, false, SECRETSREFUSE)
+			}},
+			tFunc:   capturePrint,
+			tOutput: "0\nadded r: echo r\npulled 2 key(s), 1 added and 0 updated in " + keyFile + "\n",
+		},
+		{
+			tName:  "Test export over a remote key file readable by everyone",
+			tInput: []T{remote{sshAlias: "box"}},
+			tFunc: func(r remote) string {
+				ioutil.WriteFile(filepath.Join(remoteHome, ".dial_keys"), []byte(`{"a":"echo a"}`), 0644)
+				os.Chmod(filepath.Join(remoteHome, ".dial_keys"), 0644)
+				return exported(r)
+			},
+			tOutput: "0\nadded r: echo r\nexported 2 key(s), 1 added and 0 updated in box:.dial_keys\n",
+		},
+		{
+			tName:  "Test remote key file made readable by its owner only",
+			tInput: []T{},
+			tFunc: func() string {
+				info, _ := os.Stat(filepath.Join(remoteHome, ".dial_keys"))
+				return info.Mode().Perm().String()
+			},
+			tOutput: "-rw-------",
+		},
+		{
+			tName:   "Test export to a remote path through the jump host of the configuration",
+			tInput:  []T{remote{sshAlias: "hidden", path: "keys.json"}},
+			tFunc:   exported,
+			tOutput: "0\nadded a: echo a\nadded r: echo r\nexported 2 key(s), 2 added and 0 updated in hidden:keys.json\n",
+		},
+		{
+			tName:   "Test connections forwarded by the jump host",
+			tInput:  []T{},
+			tFunc:   func() string { return strings.Join(jump.jumps(), " ") },
+			tOutput: server.addr + " " + server.addr,
+		},
+		{
+			tName:   "Test export by ip, port and identity through -jump",
+			tInput:  []T{remote{ip: "127.0.0.1", user: "me", port: server.port(), identity: filepath.Join(dir, "id_plain"), jump: "bastion", path: "keys.json"}},
+			tFunc:   exported,
+			tOutput: "0\nexported 2 key(s), 0 added and 0 updated in me@127.0.0.1:keys.json\n",
+		},
+		{
+			tName:   "Test export in batch mode skipping a key protected by a passphrase",
+			tInput:  []T{remote{sshAlias: "locked"}},
+			tFunc:   batched,
+			tOutput: "1\ncannot execute command: export, cannot fetch locked:.dial_keys: cannot connect to locked: ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain\n",
+		},
+		{
+			tName:   "Test export with a key protected by a passphrase",
+			tInput:  []T{remote{sshAlias: "locked"}},
+			tFunc:   exported,
+			tOutput: "0\nexported 2 key(s), 0 added and 0 updated in locked:.dial_keys\nEnter passphrase for key '" + filepath.Join(dir, "id_locked") + "': \n",
+		},
+		{
+			tName:   "Test export without the key",
+			tInput:  []T{remote{sshAlias: "agent"}},
+			tFunc:   batched,
+			tOutput: "1\ncannot execute command: export, cannot fetch agent:.dial_keys: cannot connect to agent: ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain\n",
+		},
+		{
+			tName: "Test export with the key of the agent",
+			tInput: []T{func() int {
+				os.Setenv("SSH_AUTH_SOCK", sock)
+				defer os.Unsetenv("SSH_AUTH_SOCK")
+				return transferFile(remote{sshAlias: "agent", batch: true}, MERGEOURS)
+			}},
+			tFunc:   capturePrint,
+			tOutput: "0\nexported 2 key(s), 0 added and 0 updated in agent:.dial_keys\n",
+		},
+		{
+			tName:   "Test export with a password",
+			tInput:  []T{remote{sshAlias: "password"}},
+			tFunc:   exported,
+			tOutput: "0\nexported 2 key(s), 0 added and 0 updated in password:.dial_keys\nme@password's password: \n",
+		},
+		{
+			tName:   "Test export with a changed host key",
+			tInput:  []T{remote{sshAlias: "changed"}},
+			tFunc:   exported,
+			tOutput: "1\ncannot execute command: export, cannot fetch changed:.dial_keys: cannot connect to changed: ssh: handshake failed: the host key of changed has changed, which may be an attack, check " + filepath.Join(dir, "known_hosts_changed") + ":1\n",
+		},
+		{
+			tName:   "Test export in batch mode to an unknown host",
+			tInput:  []T{remote{sshAlias: "stranger"}},
+			tFunc:   batched,
+			tOutput: "1\ncannot execute command: export, cannot fetch stranger:.dial_keys: cannot connect to stranger: ssh: handshake failed: the host key of stranger is not known, connect to it with ssh once to add it to " + filepath.Join(dir, "known_hosts_stranger") + "\n",
+		},
+		{
+			tName:   "Test export to an unknown host not accepted",
+			tInput:  []T{remote{sshAlias: "stranger"}},
+			tFunc:   exported,
+			tOutput: "1\ncannot execute command: export, cannot fetch stranger:.dial_keys: cannot connect to stranger: ssh: handshake failed: the host key of stranger was not accepted\n",
+		},
+		{
+			tName:   "Test export to an unknown host accepted",
+			tInput:  []T{remote{sshAlias: "trusted"}},
+			tFunc:   exported,
+			tOutput: "0\nexported 2 key(s), 0 added and 0 updated in trusted:.dial_keys\n",
+		},
+		{
+			tName:   "Test accepted host key added to the known hosts",
+			tInput:  []T{dir, "known_hosts_trusted"},
+			tFunc:   read,
+			tOutput: knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, server.hostKey) + "\n",
+		},
+		{
+			tName:   "Test export in batch mode to a new host with StrictHostKeyChecking accept-new",
+			tInput:  []T{remote{sshAlias: "new"}},
+			tFunc:   batched,
+			tOutput: "0\nexported 2 key(s), 0 added and 0 updated in new:.dial_keys\n",
+		},
+		{
+			tName: "Test export of the binary in chunks",
+			tInput: []T{func() int {
+				defer func(exe func() (string, error)) { executable = exe }(executable)
+				executable = func() (string, error) { return filepath.Join(dir, "sd"), nil }
+				ioutil.WriteFile(filepath.Join(dir, "sd"), bytes.Repeat([]byte("sd"), 50000), 0755)
+				return transferFile(remote{sshAlias: "box", binary: "bin/sd"}, MERGEOURS)
+			}},
+			tFunc: func(f func() int) string {
+				out := capturePrint(f)
+				info, _ := os.Stat(filepath.Join(remoteHome, "bin", "sd"))
+				return fmt.Sprint(out, read(remoteHome, "bin", "sd") == read(dir, "sd"), " ", info.Mode().Perm())
+			},
+			tOutput: "0\nexported 2 key(s), 0 added and 0 updated in box:.dial_keys\ntrue -rwxr-xr-x",
+		},
+		{
+			tName: "Test pull of a missing remote file",
+			tInput: []T{func() int {
+				return pull(nil, remote{sshAlias: "box", path: "missing.json"}, MERGEOURS, false, SECRETSREFUSE)
+			}},
+			tFunc:   capturePrint,
+			tOutput: "1\ncannot execute command: pull, cannot fetch box:missing.json: missing.json: file does not exist\n",
+		},
+		{
+			tName: "Test command run through the jump host with the secrets on its input",
+			tInput: []T{func() int {
+				env := []string{"TOKEN=it's $ecret"}
+				cmd, _ := readSecretsFirst(`echo "$TOKEN" in $(basename "$(pwd)"); exit 3`, env)
+				return runOn(context.Background(), remote{sshAlias: "hidden"}, cmd, secretsInput(env), "hidden | ", 0).code
+			}},
+			tFunc:   capturePrint,
+			tOutput: "3\nhidden | it's $ecret in remote\n",
+		},
+		{
+			tName: "Test key executed on a host by port and identity through -jump",
+			tInput: []T{func() int {
+				command := flag.NewFlagSet(ON, flag.ContinueOnError)
+				o := onFlags(command)
+				command.Parse([]string{"-on", "127.0.0.1", "-port", server.port(), "-jump", "bastion", "-id", filepath.Join(dir, "id_plain"), "a"})
+				return executeOn(command, *o)
+			}},
+			tFunc:   capturePrint,
+			tOutput: "0\n127.0.0.1 | a\n",
+		},
+		{
+			tName: "Test key executed on several hosts without prompts",
+			tInput: []T{func() int {
+				defer func(e func(string, ...interface{}) (int, error)) { printErr = e }(printErr)
+				printErr = print
+				defer func(s func(time.Time) time.Duration) { since = s }(since)
+				since = func(time.Time) time.Duration { return 0 }
+				command := flag.NewFlagSet(ON, flag.ContinueOnError)
+				o := onFlags(command)
+				command.Parse([]string{"-on", "box,stranger", "-workers", "1", "a"})
+				return executeOn(command, *o)
+			}},
+			tFunc: capturePrint,
+			tOutput: "255\nbox      | a\n" +
+				"HOST      STATUS  EXIT  TIME  DETAILS\n" +
+				"box       ok      0     0s    \n" +
+				"stranger  failed  255   0s    cannot connect to stranger: ssh: handshake failed: the host key of stranger is not known, connect to it with ssh once to add it to " + filepath.Join(dir, "known_hosts_stranger") + "\n",
+		},
+		{
+			tName: "Test copy to a host that does not answer in time",
+			tInput: []T{func() string {
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+				err := sshCopy(ctx, remote{sshAlias: "silent", batch: true}, ":.dial_keys", filepath.Join(dir, "copy"))
+				return fmt.Sprint(err)
+			}},
+			tFunc:   func(f func() string) string { return f() },
+			tOutput: "context deadline exceeded",
+		},
+	}
+	testPackageMethod(tt, t)
+}
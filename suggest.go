@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+var SUGGEST = "suggest"
+
+// suggestOptions are the flags of sd suggest.
+type suggestOptions struct {
+	shell     string
+	file      string
+	minCount  int
+	minLength int
+	maxVary   int
+	limit     int
+	printOnly bool
+	secrets   string
+}
+
+type suggestion struct {
+	key     string
+	cmd     string
+	count   int
+	example string
+}
+
+var rKeyWord = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.]*$`)
+
+// commandGroup gathers commands with the same words but at the positions
+// in vary.
+type commandGroup struct {
+	words        []string
+	vary         []bool
+	count        int
+	example      string
+	exampleCount int
+}
+
+// shellFields splits cmd into words at the blanks outside of quotes, the
+// quotes being kept, or returns nil when a quote is left open.
+func shellFields(cmd string) []string {
+	var words []string
+	var word strings.Builder
+	var quote byte
+	inWord := false
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case quote == 0 && (c == ' ' || c == '\t'):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		case c == '\\' && quote != '\'' && i+1 < len(cmd):
+			word.WriteByte(c)
+			i++
+			c = cmd[i]
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case c == quote:
+			quote = 0
+		}
+		word.WriteByte(c)
+		inWord = true
+	}
+	if quote != 0 {
+		return nil
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// quoteOf returns the quote enclosing the whole of word, if any.
+func quoteOf(word string) byte {
+	if len(word) < 2 || (word[0] != '\'' && word[0] != '"') || word[len(word)-1] != word[0] ||
+		strings.IndexByte(word[1:len(word)-1], word[0]) >= 0 {
+		return 0
+	}
+	return word[0]
+}
+
+// varying returns the positions varying once words joins the group, or -1
+// when a varying word would be quoted differently, as one placeholder could
+// not stand for both.
+func (g *commandGroup) varying(words []string) int {
+	n := 0
+	for i, word := range words {
+		if g.vary[i] || g.words[i] != word {
+			if quoteOf(g.words[i]) != quoteOf(word) {
+				return -1
+			}
+			n++
+		}
+	}
+	return n
+}
+
+// template replaces the varying words by placeholders, quoted like the
+// words they stand for so that an argument with blanks stays one word.
+func (g *commandGroup) template() string {
+	words := make([]string, len(g.words))
+	idx := 0
+	for i, word := range g.words {
+		words[i] = word
+		if g.vary[i] {
+			idx++
+			words[i] = fmt.Sprintf("{%d}", idx)
+			if quote := quoteOf(word); quote != 0 {
+				words[i] = string(quote) + words[i] + string(quote)
+			}
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// suggestKeyName names a key after the first constant words of the
+// command, other than options.
+func suggestKeyName(g *commandGroup, taken map[string]bool) string {
+	var words []string
+	for i, word := range g.words {
+		if !g.vary[i] && rKeyWord.MatchString(word) {
+			words = append(words, word)
+		}
+		if len(words) == 3 {
+			break
+		}
+	}
+	name := strings.Join(words, "-")
+	if name == "" {
+		name = "suggested"
+	}
+	key := name
+	for i := 2; taken[key] || validKey(key) != nil; i++ {
+		key = fmt.Sprintf("%s-%d", name, i)
+	}
+	taken[key] = true
+	return key
+}
+
+// suggestKeys groups the commands of a history repeated at least minCount
+// times, commands of the same length differing in at most maxVary words,
+// and fewer than half of them, sharing a key with placeholders for those
+// words. Commands already saved as keys are left out.
+func suggestKeys(history []string, sdMap map[string]entry, o suggestOptions) []suggestion {
+	counts := map[string]int{}
+	for _, cmd := range history {
+		if cmd = strings.TrimSpace(cmd); cmd != "" {
+			counts[cmd]++
+		}
+	}
+	taken := map[string]bool{}
+	saved := map[string]bool{}
+	for key, e := range sdMap {
+		taken[key] = true
+		saved[e.Cmd] = true
+	}
+	commands := make([]string, 0, len(counts))
+	for cmd := range counts {
+		words := shellFields(cmd)
+		if len(cmd) >= o.minLength && len(words) > 1 && !saved[cmd] && words[0] != "sd" && !strings.Contains(cmd, "\n") {
+			commands = append(commands, cmd)
+		}
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		if counts[commands[i]] != counts[commands[j]] {
+			return counts[commands[i]] > counts[commands[j]]
+		}
+		return commands[i] < commands[j]
+	})
+	var groups []*commandGroup
+	for _, cmd := range commands {
+		words := shellFields(cmd)
+		var joined *commandGroup
+		for _, g := range groups {
+			if len(g.words) != len(words) || g.words[0] != words[0] {
+				continue
+			}
+			if n := g.varying(words); n >= 0 && n <= o.maxVary && 2*n < len(words) {
+				joined = g
+				break
+			}
+		}
+		if joined == nil {
+			joined = &commandGroup{words: words, vary: make([]bool, len(words))}
+			groups = append(groups, joined)
+		}
+		for i, word := range words {
+			joined.vary[i] = joined.vary[i] || joined.words[i] != word
+		}
+		joined.count += counts[cmd]
+		if counts[cmd] > joined.exampleCount {
+			joined.example, joined.exampleCount = cmd, counts[cmd]
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].count > groups[j].count })
+	var suggestions []suggestion
+	for _, g := range groups {
+		if g.count < o.minCount || saved[g.template()] {
+			continue
+		}
+		cmd := g.template()
+		if g.exampleCount == g.count {
+			// Never varied, keep the command with its own spacing and quoting.
+			cmd = g.example
+		}
+		suggestions = append(suggestions, suggestion{key: suggestKeyName(g, taken), cmd: cmd, count: g.count, example: g.example})
+		if len(suggestions) == o.limit {
+			break
+		}
+	}
+	return suggestions
+}
+
+func formatSuggestions(suggestions []suggestion) string {
+	var out bytes.Buffer
+	w := tabwriter.NewWriter(&out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COUNT\tKEY\tCOMMAND")
+	for _, s := range suggestions {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.count, s.key, s.cmd)
+	}
+	w.Flush()
+	return out.String()
+}
+
+// promptSuggestions asks which suggestions to save and under which key,
+// returning the accepted keys.
+func promptSuggestions(in *bufio.Reader, out io.Writer, suggestions []suggestion, sdMap map[string]entry) map[string]entry {
+	accepted := map[string]entry{}
+	for i, s := range suggestions {
+		fmt.Fprintf(out, "(%d/%d) used %d times: %s\n", i+1, len(suggestions), s.count, s.cmd)
+		if s.example != s.cmd {
+			fmt.Fprintf(out, "  e.g. %s\n", s.example)
+		}
+		for {
+			fmt.Fprintf(out, "save as %s? [y]es, [n]o, [q]uit or another key: ", s.key)
+			answer, err := in.ReadString('\n')
+			answer = strings.TrimSpace(answer)
+			if err != nil && answer == "" {
+				return accepted
+			}
+			key := s.key
+			switch strings.ToLower(answer) {
+			case "", "y", "yes":
+			case "n", "no":
+				key = ""
+			case "q", "quit":
+				return accepted
+			default:
+				key = answer
+			}
+			if key == "" {
+				break
+			}
+			_, exists := sdMap[key]
+			if _, taken := accepted[key]; exists || taken {
+				fmt.Fprintf(out, "the key %s exists, choose another one\n", key)
+				continue
+			}
+			if err := validKey(key); err != nil {
+				fmt.Fprintf(out, "the key %s %v, choose another one\n", key, err)
+				continue
+			}
+			accepted[key] = entry{Cmd: s.cmd}
+			break
+		}
+	}
+	return accepted
+}
+
+// suggest proposes keys for the commands repeated in the history of the
+// shell, saving the ones accepted on the terminal.
+func suggest(o suggestOptions) int {
+	if !isValidSecretPolicy(o.secrets) {
+		return 1
+	}
+	if o.shell != BASH && o.shell != ZSH && o.shell != FISH {
+		print("cannot execute command: %s, unknown shell \"%s\", expected one of: bash, zsh, fish\n", SUGGEST, o.shell)
+		return 1
+	}
+	file := o.file
+	if file == "" {
+		file = historyFile(o.shell)
+	}
+	history, err := readShellHistory(file, o.shell)
+	if err != nil {
+		print("cannot execute command: %s, %v\n", SUGGEST, err)
+		return 1
+	}
+	sdMap := map[string]entry{}
+	if fileExists() {
+		sdMap = readFile()
+	}
+	suggestions := suggestKeys(history, sdMap, o)
+	if len(suggestions) == 0 {
+		print("no command is repeated at least %d times in %s\n", o.minCount, file)
+		return 0
+	}
+	if o.printOnly || !isTerminal(os.Stdin.Fd()) {
+		print("%s", formatSuggestions(suggestions))
+		return 0
+	}
+	return saveSuggestions(bufio.NewReader(os.Stdin), os.Stdout, suggestions, sdMap, o.secrets)
+}
+
+func saveSuggestions(in *bufio.Reader, out io.Writer, suggestions []suggestion, sdMap map[string]entry, secretPolicy string) int {
+	accepted := promptSuggestions(in, out, suggestions, sdMap)
+	if len(accepted) == 0 {
+		print("no key saved\n")
+		return 0
+	}
+	for key, e := range accepted {
+		e.Updated = timestamp()
+		accepted[key] = e
+	}
+	if !guardSecrets(SUGGEST, accepted, secretPolicy) {
+		return 1
+	}
+	keys := make([]string, 0, len(accepted))
+	for key, e := range accepted {
+		sdMap[key] = recordRevision(entry{}, e)
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	writeFile(sdMap)
+	commitKeys("Save suggested keys")
+	for _, key := range keys {
+		print("Saved key %s as value: %s\n", key, accepted[key].Cmd)
+	}
+	return 0
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var suggestHistory = []string{
+	"kubectl logs -f api-7d9f -n prod",
+	"kubectl logs -f api-7d9f -n prod",
+	"kubectl logs -f web-1234 -n staging",
+	"kubectl logs -f db-5555 -n prod",
+	"ls",
+	"git log --oneline --graph --decorate -20",
+	"git log --oneline --graph --decorate -20",
+	"git log  --oneline --graph --decorate -20",
+	"docker run --rm -it ubuntu:22.04 bash",
+	"docker run --rm -it alpine:3 sh",
+	"sd save -key x -last",
+	"sd save -key x -last",
+	"sd save -key x -last",
+}
+
+func TestSuggestKeys(t *testing.T) {
+	suggested := func(sdMap map[string]entry, minCount, maxVary int) string {
+		o := suggestOptions{minCount: minCount, minLength: 20, maxVary: maxVary, limit: 10}
+		return formatSuggestions(suggestKeys(suggestHistory, sdMap, o))
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test repeated commands",
+			tInput:  []T{map[string]entry{}, 3, 2},
+			tFunc:   suggested,
+			tOutput: "COUNT  KEY           COMMAND\n4      kubectl-logs  kubectl logs -f {1} -n {2}\n3      git-log       git log --oneline --graph --decorate -20\n",
+		},
+		{
+			tName:   "Test commands varying in too many words",
+			tInput:  []T{map[string]entry{}, 2, 1},
+			tFunc:   suggested,
+			tOutput: "COUNT  KEY                COMMAND\n3      git-log            git log --oneline --graph --decorate -20\n3      kubectl-logs-prod  kubectl logs -f {1} -n prod\n",
+		},
+		{
+			tName:   "Test saved commands and taken key names",
+			tInput:  []T{map[string]entry{"kubectl-logs": {Cmd: "kubectl logs {1}"}, "gl": {Cmd: "git log --oneline --graph --decorate -20"}}, 2, 2},
+			tFunc:   suggested,
+			tOutput: "COUNT  KEY             COMMAND\n4      kubectl-logs-2  kubectl logs -f {1} -n {2}\n2      docker-run      docker run --rm -it {1} {2}\n",
+		},
+		{
+			tName: "Test quoted arguments",
+			tInput: []T{[]string{
+				`git commit -am "fix bug"`, `git commit -am "add tests"`, `git commit -am 'fix "it"'`,
+				`echo 'one two' three`, `echo 'one two' four`, `grep -r "unterminated *.go`, `grep -r "unterminated *.go`,
+			}},
+			tFunc: func(history []string) string {
+				o := suggestOptions{minCount: 2, minLength: 10, maxVary: 2, limit: 10}
+				return formatSuggestions(suggestKeys(history, map[string]entry{}, o))
+			},
+			tOutput: "COUNT  KEY         COMMAND\n2      echo        echo 'one two' {1}\n2      git-commit  git commit -am \"{1}\"\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestShellFields(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName:   "Test quoted words",
+			tInput:  []T{`git commit -m "fix  bug" --author='A B' a\ b "x\"y"`},
+			tFunc:   func(cmd string) string { return fmt.Sprintf("%q", shellFields(cmd)) },
+			tOutput: `["git" "commit" "-m" "\"fix  bug\"" "--author='A B'" "a\\ b" "\"x\\\"y\""]`,
+		},
+		{
+			tName:   "Test unterminated quote",
+			tInput:  []T{`echo "a b`},
+			tFunc:   func(cmd string) string { return fmt.Sprintf("%q", shellFields(cmd)) },
+			tOutput: `[]`,
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestSaveSuggestions(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	defer func(file, sync string) { keyFile, syncDir = file, sync }(keyFile, syncDir)
+	keyFile = filepath.Join(dir, ".dial_keys")
+	syncDir = filepath.Join(dir, ".dial_keys.sync")
+	defer func(write func(map[string]entry)) { writeFile = write }(writeFile)
+	writeFile = func(sdMap map[string]entry) { writeKeyFile(keyFile, sdMap) }
+	defer func(n func() time.Time) { now = n }(now)
+	now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+	suggestions := []suggestion{
+		{key: "kubectl-logs", cmd: "kubectl logs -f {1} -n {2}", count: 4, example: "kubectl logs -f api-7d9f -n prod"},
+		{key: "git-log", cmd: "git log --oneline --graph --decorate -20", count: 3, example: "git log --oneline --graph --decorate -20"},
+		{key: "mysql", cmd: "mysql -u root -phunter22 db", count: 3, example: "mysql -u root -phunter22 db"},
+	}
+	answered := func(answers string, n int, showPrompts bool) string {
+		var prompts bytes.Buffer
+		sdMap := map[string]entry{"gl": {Cmd: "git log"}}
+		out := capturePrint(func() int {
+			return saveSuggestions(bufio.NewReader(strings.NewReader(answers)), &prompts, suggestions[:n], sdMap, SECRETSREFUSE)
+		})
+		content, _ := ioutil.ReadFile(keyFile)
+		if !showPrompts {
+			prompts.Reset()
+		}
+		return fmt.Sprintf("%s%s%s", prompts.String(), out, content)
+	}
+	tt := []ttFStruct{
+		{
+			tName:  "Test accepting, renaming and quitting",
+			tInput: []T{"\ngl\nlog\n-x\ngraph\nq\n", 3, true},
+			tFunc:  answered,
+			tOutput: "(1/3) used 4 times: kubectl logs -f {1} -n {2}\n  e.g. kubectl logs -f api-7d9f -n prod\nsave as kubectl-logs? [y]es, [n]o, [q]uit or another key: " +
+				"(2/3) used 3 times: git log --oneline --graph --decorate -20\nsave as git-log? [y]es, [n]o, [q]uit or another key: the key gl exists, choose another one\n" +
+				"save as git-log? [y]es, [n]o, [q]uit or another key: the key log is a reserved subcommand name, choose another one\n" +
+				"save as git-log? [y]es, [n]o, [q]uit or another key: the key -x starts with -, choose another one\n" +
+				"save as git-log? [y]es, [n]o, [q]uit or another key: (3/3) used 3 times: mysql -u root -phunter22 db\nsave as mysql? [y]es, [n]o, [q]uit or another key: " +
+				"0\nSaved key graph as value: git log --oneline --graph --decorate -20\nSaved key kubectl-logs as value: kubectl logs -f {1} -n {2}\n" +
+				`{"gl":"git log","graph":{"cmd":"git log --oneline --graph --decorate -20","updated":"2020-01-02T03:04:05Z"},"kubectl-logs":{"cmd":"kubectl logs -f {1} -n {2}","updated":"2020-01-02T03:04:05Z"}}`,
+		},
+		{
+			tName:  "Test refusing likely secrets",
+			tInput: []T{"n\nn\ny\n", 3, false},
+			tFunc:  answered,
+			tOutput: "1\nlikely secrets in the commands\nKEY    KIND      VALUE\nmysql  password  ********\n" +
+				"cannot execute command: suggest, use -secrets move to move them into secrets, or -secrets warn to go on anyway\n" +
+				`{"gl":"git log","graph":{"cmd":"git log --oneline --graph --decorate -20","updated":"2020-01-02T03:04:05Z"},"kubectl-logs":{"cmd":"kubectl logs -f {1} -n {2}","updated":"2020-01-02T03:04:05Z"}}`,
+		},
+		{
+			tName:   "Test declining every suggestion",
+			tInput:  []T{"n\nn\nn\n", 3, false},
+			tFunc:   func(answers string, n int, showPrompts bool) string { return answered(answers, n, showPrompts)[:15] },
+			tOutput: "0\nno key saved\n",
+		},
+	}
+	testPackageMethod(tt, t)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var SYNC = "sync"
+
+var syncDir = getHomeDir() + string(os.PathSeparator) + ".dial_keys.sync"
+var syncFile = "dial_keys.json"
+
+func git(args ...string) (string, error) {
+	out, err := runCommand(context.Background(), "git", append([]string{"-C", syncDir}, args...)...)
+	return strings.TrimSpace(out), err
+}
+
+func isSynced() bool {
+	_, err := os.Stat(filepath.Join(syncDir, ".git"))
+	return err == nil
+}
+
+// writeSyncFile writes the keys indented, one key per line for plain
+// commands, so that git diff and blame work per key.
+func writeSyncFile(sdMap map[string]entry) error {
+	content, err := json.MarshalIndent(sdMap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(syncDir, syncFile), append(content, '\n'), 0600)
+}
+
+// readGitKeys reads the keys at the revision rev. A revision without a key
+// file, or no revision at all such as the missing merge base of unrelated
+// histories, gives no keys.
+func readGitKeys(rev string) (map[string]entry, error) {
+	sdMap := map[string]entry{}
+	if rev == "" {
+		return sdMap, nil
+	}
+	files, err := git("ls-tree", "--name-only", rev, "--", syncFile)
+	if err != nil {
+		return nil, err
+	}
+	if files == "" {
+		return sdMap, nil
+	}
+	content, err := git("show", rev+":"+syncFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(content), &sdMap); err != nil {
+		return nil, fmt.Errorf("%s at %s: %v", syncFile, rev, err)
+	}
+	return sdMap, nil
+}
+
+func commitSyncFile(sdMap map[string]entry, message string) error {
+	if err := writeSyncFile(sdMap); err != nil {
+		return err
+	}
+	if _, err := git("add", syncFile); err != nil {
+		return err
+	}
+	if status, err := git("status", "--porcelain"); err != nil || status == "" {
+		return err
+	}
+	_, err := git("commit", "-q", "-m", message)
+	return err
+}
+
+// commitKeys commits the local keys to the sync repository, if any.
+func commitKeys(message string) {
+	if !isSynced() || !fileExists() {
+		return
+	}
+	if err := commitSyncFile(readFile(), message); err != nil {
+		print("cannot commit the keys to %s: %v\n", syncDir, err)
+	}
+}
+
+func sameKey(a entry, inA bool, b entry, inB bool) bool {
+	return inA == inB && (!inA || sameEntry(a, b))
+}
+
+// mergeThreeWay merges the changes of ours and theirs since base key by
+// key. Keys changed on both sides are resolved by the strategy, a key
+// deleted on one side and changed on the other is kept.
+func mergeThreeWay(base, ours, theirs map[string]entry, strategy string) (map[string]entry, []string, error) {
+	keys := map[string]bool{}
+	for _, sdMap := range []map[string]entry{base, ours, theirs} {
+		for key := range sdMap {
+			keys[key] = true
+		}
+	}
+	merged := map[string]entry{}
+	var conflicts []string
+	for key := range keys {
+		b, inB := base[key]
+		o, inO := ours[key]
+		t, inT := theirs[key]
+		switch {
+		case sameKey(o, inO, t, inT) || sameKey(b, inB, t, inT):
+			if inO && inT {
+				merged[key] = mergeHistory(o, t)
+			} else if inO {
+				merged[key] = o
+			}
+		case sameKey(b, inB, o, inO):
+			if inO && inT {
+				merged[key] = mergeHistory(t, o)
+			} else if inT {
+				merged[key] = t
+			}
+		case !inO:
+			merged[key] = t
+		case !inT:
+			merged[key] = o
+		default:
+			conflicts = append(conflicts, key)
+		}
+	}
+	sort.Strings(conflicts)
+	for _, key := range conflicts {
+		resolved, _, err := mergeKeys(map[string]entry{key: ours[key]}, map[string]entry{key: theirs[key]}, strategy)
+		if err != nil {
+			return nil, nil, err
+		}
+		merged[key] = resolved[key]
+	}
+	return merged, conflicts, nil
+}
+
+// rebaseKeys rebases the local commits on the remote ones. When git cannot,
+// or the result is not a valid key file, the keys are merged key by key in
+// a merge commit instead.
+func rebaseKeys(remoteRef, strategy string) ([]string, error) {
+	if _, err := git("rebase", "-q", remoteRef); err == nil {
+		if _, err := readGitKeys("HEAD"); err == nil {
+			return nil, nil
+		}
+		if _, err := git("reset", "-q", "--hard", "ORIG_HEAD"); err != nil {
+			return nil, err
+		}
+	} else if _, err := git("rebase", "--abort"); err != nil {
+		return nil, err
+	}
+	// git merge-base fails with status 1 and no message when the histories
+	// are unrelated, leaving no common keys.
+	mergeBase, err := git("merge-base", "HEAD", remoteRef)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		mergeBase = ""
+	} else if err != nil {
+		return nil, err
+	}
+	base, err := readGitKeys(mergeBase)
+	if err != nil {
+		return nil, err
+	}
+	ours, err := readGitKeys("HEAD")
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := readGitKeys(remoteRef)
+	if err != nil {
+		return nil, err
+	}
+	merged, conflicts, err := mergeThreeWay(base, ours, theirs, strategy)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := git("merge", "-q", "--no-commit", "-s", "ours", remoteRef); err != nil {
+		return nil, err
+	}
+	if err := writeSyncFile(merged); err != nil {
+		return nil, err
+	}
+	if _, err := git("add", syncFile); err != nil {
+		return nil, err
+	}
+	_, err = git("commit", "-q", "-m", "Merge the keys of "+remoteRef)
+	return conflicts, err
+}
+
+func syncKeys(command *flag.FlagSet, gitURL, strategy, secretPolicy string) int {
+	if !isValidStrategy(strategy) || !isValidSecretPolicy(secretPolicy) {
+		return 1
+	}
+	fail := func(err error) int {
+		print("cannot execute command: %s, %v\n", SYNC, err)
+		return 1
+	}
+	ours := map[string]entry{}
+	if fileExists() {
+		ours = readFile()
+	}
+	hostname, _ := os.Hostname()
+	var conflicts []string
+	cloned := !isSynced()
+	if cloned {
+		if gitURL == "" {
+			print("cannot execute command: %s, no repository to sync with yet\n", SYNC)
+			command.PrintDefaults()
+			return 1
+		}
+		if _, err := runCommand(context.Background(), "git", "clone", "-q", gitURL, syncDir); err != nil {
+			return fail(err)
+		}
+		// Nothing was synced yet, all the local keys are new to the repository,
+		// which has no commit at all when it is empty.
+		theirs := map[string]entry{}
+		if _, err := git("rev-parse", "-q", "--verify", "HEAD"); err == nil {
+			if theirs, err = readGitKeys("HEAD"); err != nil {
+				return fail(err)
+			}
+		}
+		merged, mergeConflicts, err := mergeThreeWay(map[string]entry{}, ours, theirs, strategy)
+		if err == nil {
+			err = commitSyncFile(merged, "Add the keys of "+hostname)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		conflicts = mergeConflicts
+	} else {
+		if gitURL != "" {
+			if _, err := git("remote", "set-url", "origin", gitURL); err != nil {
+				return fail(err)
+			}
+		}
+		if fileExists() {
+			if err := commitSyncFile(ours, "Update the keys of "+hostname); err != nil {
+				return fail(err)
+			}
+		}
+	}
+
+	// The commit of the local keys, to go back to when the keys received
+	// are refused.
+	local, err := git("rev-parse", "HEAD")
+	var branch string
+	if err == nil {
+		branch, err = git("symbolic-ref", "--short", "HEAD")
+	}
+	if err == nil {
+		_, err = git("fetch", "-q", "origin")
+	}
+	if err != nil {
+		return fail(err)
+	}
+	remoteRef := "origin/" + branch
+	if _, err := git("rev-parse", "-q", "--verify", remoteRef); err == nil {
+		rebaseConflicts, err := rebaseKeys(remoteRef, strategy)
+		if err != nil {
+			return fail(err)
+		}
+		conflicts = append(conflicts, rebaseConflicts...)
+	}
+	merged, err := readGitKeys("HEAD")
+	if err != nil {
+		return fail(err)
+	}
+	if !guardIncoming(SYNC, ours, merged, secretPolicy) {
+		// The repository must not keep keys the key file lacks, which the
+		// next commit of the keys would delete.
+		if cloned {
+			err = os.RemoveAll(syncDir)
+		} else {
+			_, err = git("reset", "-q", "--hard", local)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		return 1
+	}
+	err = commitSyncFile(merged, "Move secrets out of the keys")
+	if err == nil {
+		_, err = git("push", "-q", "origin", "HEAD:"+branch)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	writeFile(merged)
+	printMergeSummary("synced", keyFile, ours, merged, conflicts)
+	return 0
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeThreeWay(t *testing.T) {
+	base := map[string]entry{
+		"same":    {Cmd: "echo same"},
+		"ours":    {Cmd: "echo base"},
+		"theirs":  {Cmd: "echo base"},
+		"deleted": {Cmd: "echo deleted"},
+		"both":    {Cmd: "echo base"},
+		"gone":    {Cmd: "echo base"},
+	}
+	ours := map[string]entry{
+		"same":   {Cmd: "echo same"},
+		"ours":   {Cmd: "echo ours"},
+		"theirs": {Cmd: "echo base"},
+		"both":   {Cmd: "echo ours"},
+		"gone":   {Cmd: "echo changed"},
+		"new":    {Cmd: "echo new"},
+	}
+	theirs := map[string]entry{
+		"same":    {Cmd: "echo same"},
+		"ours":    {Cmd: "echo base"},
+		"theirs":  {Cmd: "echo theirs"},
+		"deleted": {Cmd: "echo deleted"},
+		"both":    {Cmd: "echo theirs"},
+	}
+	merge := func(strategy string) string {
+		merged, conflicts, err := mergeThreeWay(base, ours, theirs, strategy)
+		var keys []string
+		for _, r := range toRecords(merged) {
+			keys = append(keys, r.Key+"="+r.Cmd)
+		}
+		return fmt.Sprintf("%v %v %v", keys, conflicts, err)
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test three way merge keeping ours",
+			tInput:  []T{MERGEOURS},
+			tFunc:   merge,
+			tOutput: "[both=echo ours gone=echo changed new=echo new ours=echo ours same=echo same theirs=echo theirs] [both] <nil>",
+		},
+		{
+			tName:   "Test three way merge taking theirs",
+			tInput:  []T{MERGETHEIRS},
+			tFunc:   merge,
+			tOutput: "[both=echo theirs gone=echo changed new=echo new ours=echo ours same=echo same theirs=echo theirs] [both] <nil>",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestSyncKeys(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	dir, _ := ioutil.TempDir("", "sd")
+	defer os.RemoveAll(dir)
+	for _, env := range []string{"GIT_AUTHOR_NAME", "GIT_AUTHOR_EMAIL", "GIT_COMMITTER_NAME", "GIT_COMMITTER_EMAIL", "GIT_CONFIG_GLOBAL", "GIT_CONFIG_NOSYSTEM"} {
+		defer os.Setenv(env, os.Getenv(env))
+	}
+	os.Setenv("GIT_AUTHOR_NAME", "sd")
+	os.Setenv("GIT_AUTHOR_EMAIL", "sd@localhost")
+	os.Setenv("GIT_COMMITTER_NAME", "sd")
+	os.Setenv("GIT_COMMITTER_EMAIL", "sd@localhost")
+	os.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	os.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	repo := filepath.Join(dir, "team.git")
+	if out, err := exec.Command("git", "init", "-q", "--bare", repo).CombinedOutput(); err != nil {
+		t.Fatalf("cannot create the repository: %v %s", err, out)
+	}
+	defer func(file, sync string) { keyFile, syncDir = file, sync }(keyFile, syncDir)
+	defer func(write func(map[string]entry)) { writeFile = write }(writeFile)
+	writeFile = func(sdMap map[string]entry) { writeKeyFile(keyFile, sdMap) }
+	defer func(secrets string, iterations int) { secretsFile, secretIterations = secrets, iterations }(secretsFile, secretIterations)
+	secretsFile = filepath.Join(dir, ".dial_secrets")
+	secretIterations = 1000
+	defer func(r func(string, bool) (string, error)) { readPassphrase = r }(readPassphrase)
+	readPassphrase = func(string, bool) (string, error) { return "passphrase", nil }
+	// on switches between the key files and sync repositories of two machines.
+	on := func(machine string) {
+		keyFile = filepath.Join(dir, machine, ".dial_keys")
+		syncDir = filepath.Join(dir, machine, ".dial_keys.sync")
+		os.MkdirAll(filepath.Join(dir, machine), 0755)
+	}
+	run := func(machine string, f func() int) string {
+		on(machine)
+		return capturePrint(f)
+	}
+	saved := func(key, val string) func() int {
+		return func() int {
+			return save(flag.NewFlagSet(SAVE, flag.ExitOnError), key, val, "", nil, nil, SECRETSREFUSE)
+		}
+	}
+	synced := func(gitURL string) func() int {
+		return func() int {
+			return syncKeys(flag.NewFlagSet(SYNC, flag.ExitOnError), gitURL, MERGETHEIRS, SECRETSREFUSE)
+		}
+	}
+	// committed runs f on machine and tells the last commit of its sync
+	// repository.
+	committed := func(machine string, f func() int) string {
+		out := run(machine, f)
+		subject, _ := git("log", "-1", "--format=%s")
+		return out + subject
+	}
+	keys := func(machine string) string {
+		on(machine)
+		var keys []string
+		for _, r := range toRecords(readFile()) {
+			keys = append(keys, r.Key+"="+r.Cmd)
+		}
+		return fmt.Sprint(keys)
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test sync without repository",
+			tInput:  []T{"a", synced("")},
+			tFunc:   run,
+			tOutput: "1\ncannot execute command: sync, no repository to sync with yet\n",
+		},
+		{
+			tName:   "Test save on the first machine",
+			tInput:  []T{"a", saved("a", "echo a")},
+			tFunc:   run,
+			tOutput: "0\nSaved key a as value: echo a",
+		},
+		{
+			tName:   "Test first sync pushing the keys",
+			tInput:  []T{"a", synced(repo)},
+			tFunc:   run,
+			tOutput: "0\nsynced 1 key(s), 0 added and 0 updated in " + filepath.Join(dir, "a", ".dial_keys") + "\n",
+		},
+		{
+			tName:   "Test save on the second machine",
+			tInput:  []T{"b", saved("b", "echo b")},
+			tFunc:   run,
+			tOutput: "0\nSaved key b as value: echo b",
+		},
+		{
+			tName:   "Test first sync of the second machine merging the keys",
+			tInput:  []T{"b", synced(repo)},
+			tFunc:   run,
+			tOutput: "0\nadded a: echo a\nsynced 2 key(s), 1 added and 0 updated in " + filepath.Join(dir, "b", ".dial_keys") + "\n",
+		},
+		{
+			tName:   "Test change on the second machine",
+			tInput:  []T{"b", saved("a", "echo changed")},
+			tFunc:   run,
+			tOutput: "0\nSaved key a as value: echo changed",
+		},
+		{
+			tName: "Test delete on the second machine",
+			tInput: []T{"b", func() int {
+				return deleted(flag.NewFlagSet(DELETE, flag.ExitOnError), "b")
+			}},
+			tFunc:   run,
+			tOutput: "0\ndeleted the key: b from speed dial keys",
+		},
+		{
+			tName:   "Test sync of the second machine",
+			tInput:  []T{"b", synced("")},
+			tFunc:   run,
+			tOutput: "0\nsynced 1 key(s), 0 added and 0 updated in " + filepath.Join(dir, "b", ".dial_keys") + "\n",
+		},
+		{
+			tName:   "Test conflicting change on the first machine",
+			tInput:  []T{"a", saved("a", "echo conflict")},
+			tFunc:   run,
+			tOutput: "0\nSaved key a as value: echo conflict",
+		},
+		{
+			tName:   "Test sync of the first machine resolving the conflict",
+			tInput:  []T{"a", synced("")},
+			tFunc:   run,
+			tOutput: "0\nupdated a: echo changed\nsynced 1 key(s), 0 added and 1 updated in " + filepath.Join(dir, "a", ".dial_keys") + "\n",
+		},
+		{
+			tName:   "Test keys of the first machine",
+			tInput:  []T{"a"},
+			tFunc:   keys,
+			tOutput: "[a=echo changed]",
+		},
+		{
+			tName: "Test sync of a likely secret from the second machine",
+			tInput: []T{"b", func() int {
+				save(flag.NewFlagSet(SAVE, flag.ExitOnError), "db", "mysql -u root -phunter22 db", "", nil, nil, SECRETSWARN)
+				return syncKeys(flag.NewFlagSet(SYNC, flag.ExitOnError), "", MERGETHEIRS, SECRETSWARN)
+			}},
+			tFunc:   run,
+			tOutput: "0\nwarning: likely secrets in the commands\nKEY  KIND      VALUE\ndb   password  ********\nSaved key db as value: mysql -u root -phunter22 dbsynced 2 key(s), 0 added and 0 updated in " + filepath.Join(dir, "b", ".dial_keys") + "\n",
+		},
+		{
+			tName:   "Test sync of the first machine refusing the likely secret",
+			tInput:  []T{"a", synced("")},
+			tFunc:   run,
+			tOutput: "1\nlikely secrets in the commands\nKEY  KIND      VALUE\ndb   password  ********\ncannot execute command: sync, use -secrets move to move them into secrets, or -secrets warn to go on anyway\n",
+		},
+		{
+			tName:   "Test keys of the first machine after the refused sync",
+			tInput:  []T{"a"},
+			tFunc:   keys,
+			tOutput: "[a=echo changed]",
+		},
+		{
+			tName: "Test sync of the first machine keeping the refused key after a save",
+			tInput: []T{"a", func() int {
+				saved("c", "echo c")()
+				return syncKeys(flag.NewFlagSet(SYNC, flag.ExitOnError), "", MERGETHEIRS, SECRETSWARN)
+			}},
+			tFunc:   run,
+			tOutput: "0\nSaved key c as value: echo cwarning: likely secrets in the commands\nKEY  KIND      VALUE\ndb   password  ********\nadded db: mysql -u root -phunter22 db\nsynced 3 key(s), 1 added and 0 updated in " + filepath.Join(dir, "a", ".dial_keys") + "\n",
+		},
+		{
+			tName:  "Test history of the key file",
+			tInput: []T{},
+			tFunc: func() string {
+				out, _ := git("log", "--format=%s", "--first-parent")
+				return out
+			},
+			tOutput: "Merge the keys of origin/master\nSave c\nMerge the keys of origin/master\nSave a\nAdd the keys of " + hostname(),
+		},
+		{
+			tName:  "Test keys without revision",
+			tInput: []T{""},
+			tFunc: func(rev string) string {
+				sdMap, err := readGitKeys(rev)
+				return fmt.Sprint(sdMap, err)
+			},
+			tOutput: "map[] <nil>",
+		},
+		{
+			tName:  "Test keys of an unknown revision",
+			tInput: []T{"unknown"},
+			tFunc: func(rev string) string {
+				sdMap, err := readGitKeys(rev)
+				return fmt.Sprint(sdMap == nil, err != nil)
+			},
+			tOutput: "true true",
+		},
+		{
+			tName: "Test import from an exported file commits the keys",
+			tInput: []T{"a", func() int {
+				file := filepath.Join(dir, "exported.json")
+				writeKeyFile(file, map[string]entry{"i": {Cmd: "echo i"}})
+				return importFile(file, MERGEOURS, false, SECRETSREFUSE)
+			}},
+			tFunc:   committed,
+			tOutput: "0\nadded i: echo i\nimported 4 key(s), 1 added and 0 updated in " + filepath.Join(dir, "a", ".dial_keys") + "\nImport the keys of exported.json",
+		},
+		{
+			tName: "Test import from a shell rc file commits the keys",
+			tInput: []T{"a", func() int {
+				file := filepath.Join(dir, "aliases")
+				ioutil.WriteFile(file, []byte("alias r='echo r'\n"), 0644)
+				return importRC(flag.NewFlagSet(IMPORT, flag.ExitOnError), FROMBASHALIASES, file, false, false, SECRETSREFUSE)
+			}},
+			tFunc:   committed,
+			tOutput: "0\nimported r: echo r\nimported 1 key(s) from " + filepath.Join(dir, "aliases") + "\nImport 1 key(s) from aliases",
+		},
+		{
+			tName:   "Test moving a password into a secret scrubs the sync repository",
+			tInput:  []T{"a", func() int { return audit(true) }},
+			tFunc:   committed,
+			tOutput: "0\nmoved the password of key db into the secret db_password\nwarning: the git history of " + filepath.Join(dir, "a", ".dial_keys.sync") + " still holds the moved secrets, rotate them or rewrite the history\nMove secrets out of the keys",
+		},
+		{
+			tName:  "Test the git history keeps the moved password",
+			tInput: []T{},
+			tFunc: func() string {
+				current, _ := git("show", "HEAD:"+syncFile)
+				history, _ := git("log", "--format=%s", "-S", "hunter22")
+				return fmt.Sprint(strings.Contains(current, "hunter22"), history != "")
+			},
+			tOutput: "false true",
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
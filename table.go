@@ -0,0 +1,265 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	keyTableTitle     = "Key"
+	descTableTitle    = "Description"
+	valueTableTitle   = "Value"
+	overflowIndicator = "..."
+	tablePadding      = 5
+	minValueWidth     = 10
+)
+
+var (
+	COLORAUTO   = "auto"
+	COLORALWAYS = "always"
+	COLORNEVER  = "never"
+)
+
+var (
+	colorReset  = "\x1b[0m"
+	colorHeader = "\x1b[1m"
+	colorKey    = "\x1b[36m"
+)
+
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F},
+	{0x2E80, 0x303E},
+	{0x3041, 0x33FF},
+	{0x3400, 0x4DBF},
+	{0x4E00, 0x9FFF},
+	{0xA000, 0xA4CF},
+	{0xAC00, 0xD7A3},
+	{0xF900, 0xFAFF},
+	{0xFE30, 0xFE4F},
+	{0xFF00, 0xFF60},
+	{0xFFE0, 0xFFE6},
+	{0x1F300, 0x1F64F},
+	{0x1F900, 0x1F9FF},
+	{0x20000, 0x2FFFD},
+	{0x30000, 0x3FFFD},
+}
+
+func runeWidth(r rune) int {
+	if r == 0 || unicode.IsControl(r) || unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+	for _, wide := range wideRanges {
+		if r >= wide[0] && r <= wide[1] {
+			return 2
+		}
+	}
+	return 1
+}
+
+func displayWidth(str string) int {
+	width := 0
+	for _, r := range str {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// splitWidth splits str after at most width display columns, never in the
+// middle of a rune.
+func splitWidth(str string, width int) (string, string) {
+	used := 0
+	for idx, r := range str {
+		w := runeWidth(r)
+		if used+w > width {
+			return str[:idx], str[idx:]
+		}
+		used += w
+	}
+	return str, ""
+}
+
+func cellLines(val string, width int, wrap bool) ([]string, bool) {
+	var lines []string
+	ellipsed := false
+	for _, line := range strings.Split(strings.Replace(val, "\t", "    ", -1), "\n") {
+		if width <= 0 || displayWidth(line) <= width {
+			lines = append(lines, line)
+			continue
+		}
+		if !wrap {
+			head, _ := splitWidth(line, width-displayWidth(overflowIndicator))
+			lines = append(lines, head+overflowIndicator)
+			ellipsed = true
+			continue
+		}
+		for line != "" {
+			head, rest := splitWidth(line, width)
+			if idx := strings.LastIndex(head, " "); rest != "" && idx > 0 {
+				head, rest = line[:idx], line[idx+1:]
+			}
+			lines = append(lines, head)
+			line = rest
+		}
+	}
+	return lines, ellipsed
+}
+
+func maxLineWidth(val string) int {
+	width := 0
+	for _, line := range strings.Split(strings.Replace(val, "\t", "    ", -1), "\n") {
+		if w := displayWidth(line); w > width {
+			width = w
+		}
+	}
+	return width
+}
+
+// columnWidths returns the content width of the key, description and value
+// columns. The description column has a zero width when no key has a
+// description. When the table does not fit in windowWidth the value column
+// is shrunk first, the description column second. Keys are never shrunk.
+func columnWidths(sdMap map[string]entry, windowWidth int) []int {
+	widths := []int{displayWidth(keyTableTitle), 0, displayWidth(valueTableTitle)}
+	for key, value := range sdMap {
+		if w := displayWidth(key); w > widths[0] {
+			widths[0] = w
+		}
+		if w := maxLineWidth(value.Desc); w > widths[1] {
+			widths[1] = w
+		}
+		if w := maxLineWidth(value.Cmd); w > widths[2] {
+			widths[2] = w
+		}
+	}
+	if widths[1] > 0 && widths[1] < displayWidth(descTableTitle) {
+		widths[1] = displayWidth(descTableTitle)
+	}
+	if windowWidth <= 0 {
+		return widths
+	}
+	excess := tableWidth(widths) - windowWidth
+	if excess > 0 && widths[2] > minValueWidth {
+		shrink := excess
+		if widths[2]-shrink < minValueWidth {
+			shrink = widths[2] - minValueWidth
+		}
+		widths[2] -= shrink
+		excess -= shrink
+	}
+	if excess > 0 && widths[1] > displayWidth(descTableTitle) {
+		shrink := excess
+		if widths[1]-shrink < displayWidth(descTableTitle) {
+			shrink = widths[1] - displayWidth(descTableTitle)
+		}
+		widths[1] -= shrink
+	}
+	return widths
+}
+
+func tableWidth(widths []int) int {
+	total := 1
+	for _, w := range widths {
+		if w > 0 {
+			total += w + 2*tablePadding + 1
+		}
+	}
+	return total
+}
+
+// renderTable renders sdMap as a table fitting in windowWidth columns, a
+// windowWidth of zero meaning unlimited. Values which do not fit are ellipsed
+// unless wrap is set, in which case they continue on the following lines.
+func renderTable(sdMap map[string]entry, windowWidth int, wrap, color bool) (string, bool) {
+	widths := columnWidths(sdMap, windowWidth)
+	ellipsedAny := false
+
+	paint := func(str, code string) string {
+		if !color || str == "" || code == "" {
+			return str
+		}
+		return code + str + colorReset
+	}
+
+	renderRow := func(cells []string, codes []string) string {
+		var columns [][]string
+		height := 0
+		for i, cell := range cells {
+			var lines []string
+			if widths[i] > 0 {
+				var ellipsed bool
+				lines, ellipsed = cellLines(cell, widths[i], wrap)
+				ellipsedAny = ellipsedAny || ellipsed
+			}
+			if len(lines) > height {
+				height = len(lines)
+			}
+			columns = append(columns, lines)
+		}
+		str := ""
+		for row := 0; row < height; row++ {
+			str += "|"
+			for i, lines := range columns {
+				if widths[i] == 0 {
+					continue
+				}
+				line := ""
+				if row < len(lines) {
+					line = lines[row]
+				}
+				str += strings.Repeat(" ", tablePadding) + paint(line, codes[i]) + strings.Repeat(" ", widths[i]-displayWidth(line)+tablePadding) + "|"
+			}
+			str += "\n"
+		}
+		return str
+	}
+
+	var sortedKeys []string
+	for key := range sdMap {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	border := strings.Repeat("-", tableWidth(widths)) + "\n"
+	headerCodes := []string{colorHeader, colorHeader, colorHeader}
+	str := border + renderRow([]string{keyTableTitle, descTableTitle, valueTableTitle}, headerCodes) + border
+	for _, key := range sortedKeys {
+		str += renderRow([]string{key, sdMap[key].Desc, sdMap[key].Cmd}, []string{colorKey, "", ""})
+	}
+	return str + border, ellipsedAny
+}
+
+func windowWidth() int {
+	if width, ok := terminalWidth(os.Stdout.Fd()); ok {
+		return width
+	}
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+	return 0
+}
+
+func useColor(colorMode string) bool {
+	switch colorMode {
+	case COLORALWAYS:
+		return true
+	case COLORNEVER:
+		return false
+	}
+	_, isTerminal := terminalWidth(os.Stdout.Fd())
+	return isTerminal && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+}
+
+func printAsTable(sdMap map[string]entry, listLong, wrap bool, colorMode string) {
+	width := 0
+	if !listLong {
+		width = windowWidth()
+	}
+	table, ellipsed := renderTable(sdMap, width, wrap, useColor(colorMode))
+	print("%s", table)
+	if ellipsed {
+		print("Note: some values have been ellipsed. Add \"-l\" to see values in full or \"-wrap\" to wrap them.\n")
+	}
+}
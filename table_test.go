@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDisplayWidth(t *testing.T) {
+	tt := []ttFStruct{
+		{
+			tName:   "Test display width of ascii",
+			tInput:  []T{"echo hello"},
+			tFunc:   displayWidth,
+			tOutput: 10,
+		},
+		{
+			tName:   "Test display width of multibyte characters",
+			tInput:  []T{"échö"},
+			tFunc:   displayWidth,
+			tOutput: 4,
+		},
+		{
+			tName:   "Test display width of wide characters",
+			tInput:  []T{"日本語"},
+			tFunc:   displayWidth,
+			tOutput: 6,
+		},
+		{
+			tName:   "Test display width of combining characters",
+			tInput:  []T{"é"},
+			tFunc:   displayWidth,
+			tOutput: 1,
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestCellLines(t *testing.T) {
+	lines := func(val string, width int, wrap bool) []string {
+		cell, _ := cellLines(val, width, wrap)
+		return cell
+	}
+	tt := []ttFStruct{
+		{
+			tName:   "Test cell which fits",
+			tInput:  []T{"echo hello", 10, false},
+			tFunc:   lines,
+			tOutput: []string{"echo hello"},
+		},
+		{
+			tName:   "Test cell which is ellipsed",
+			tInput:  []T{"echo hello world", 10, false},
+			tFunc:   lines,
+			tOutput: []string{"echo he..."},
+		},
+		{
+			tName:   "Test cell with wide characters which is ellipsed",
+			tInput:  []T{"日本語日本語", 10, false},
+			tFunc:   lines,
+			tOutput: []string{"日本語..."},
+		},
+		{
+			tName:   "Test cell which is wrapped on whitespace",
+			tInput:  []T{"echo hello world", 10, true},
+			tFunc:   lines,
+			tOutput: []string{"echo", "hello", "world"},
+		},
+		{
+			tName:   "Test cell which is wrapped without whitespace",
+			tInput:  []T{"abcdefghijkl", 5, true},
+			tFunc:   lines,
+			tOutput: []string{"abcde", "fghij", "kl"},
+		},
+		{
+			tName:   "Test cell with embedded newline",
+			tInput:  []T{"echo a\necho b", 0, false},
+			tFunc:   lines,
+			tOutput: []string{"echo a", "echo b"},
+		},
+	}
+	testPackageMethod(tt, t)
+}
+
+func TestRenderTable(t *testing.T) {
+	sdMap := map[string]entry{
+		"hello": {Cmd: "echo hello world"},
+		"multi": {Cmd: "echo a\necho b"},
+	}
+	tt := []ttFStruct{
+		{
+			tName:  "Test render table without width limit",
+			tInput: []T{sdMap, 0, false, false},
+			tFunc:  renderTable,
+			tOutput: strings.Repeat("-", 44) + "\n" +
+				"|     Key       |     Value                |\n" +
+				strings.Repeat("-", 44) + "\n" +
+				"|     hello     |     echo hello world     |\n" +
+				"|     multi     |     echo a               |\n" +
+				"|               |     echo b               |\n" +
+				strings.Repeat("-", 44) + "\n",
+		},
+		{
+			tName:  "Test render table which is ellipsed",
+			tInput: []T{sdMap, 38, false, false},
+			tFunc:  renderTable,
+			tOutput: strings.Repeat("-", 38) + "\n" +
+				"|     Key       |     Value          |\n" +
+				strings.Repeat("-", 38) + "\n" +
+				"|     hello     |     echo he...     |\n" +
+				"|     multi     |     echo a         |\n" +
+				"|               |     echo b         |\n" +
+				strings.Repeat("-", 38) + "\n",
+		},
+		{
+			tName:  "Test render table with colors",
+			tInput: []T{map[string]entry{"k": {Cmd: "v"}}, 0, false, true},
+			tFunc:  renderTable,
+			tOutput: strings.Repeat("-", 31) + "\n" +
+				"|     \x1b[1mKey\x1b[0m     |     \x1b[1mValue\x1b[0m     |\n" +
+				strings.Repeat("-", 31) + "\n" +
+				"|     \x1b[36mk\x1b[0m       |     v         |\n" +
+				strings.Repeat("-", 31) + "\n",
+		},
+	}
+	for _, tc := range tt {
+		tc.tFunc = func(sdMap map[string]entry, width int, wrap, color bool) string {
+			table, _ := renderTable(sdMap, width, wrap, color)
+			return table
+		}
+		testPackageMethod([]ttFStruct{tc}, t)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func terminalSize(fd uintptr) (int, int, bool) {
+	return 0, 0, false
+}
+
+func terminalWidth(fd uintptr) (int, bool) {
+	return 0, false
+}
+
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
+func makeRaw(fd uintptr) (func(), error) {
+	return nil, fmt.Errorf("raw terminal mode is not supported on %s", runtime.GOOS)
+}
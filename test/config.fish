@@ -0,0 +1,6 @@
+alias ll 'ls -alF'
+alias gs='git status'
+
+function kl
+    kubectl logs -f $argv[1] $argv
+end